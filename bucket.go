@@ -0,0 +1,82 @@
+package simnet
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token-bucket rate limiter measured in bytes, used to simulate
+// bandwidth limits. Credit accrues at the configured bandwidth up to the
+// burst size, and callers that take more credit than is available must wait
+// for it to be repaid, so sustained throughput converges to the bandwidth.
+type bucket struct {
+	mu     sync.Mutex
+	clock  Clock     // Source of time for refilling credit
+	tokens float64   // Available credit in bytes (negative when in debt)
+	last   time.Time // Last time credit was added
+	init   bool      // Whether the bucket has been filled initially
+}
+
+// newBucket returns a bucket that limits traffic in the given direction to
+// the configured bandwidth. With shared bandwidth, every connection using the
+// config gets the same bucket for each direction.
+func newBucket(cfg *Config, dir direction) *bucket {
+	clock := cfg.clock()
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if !cfg.SharedBandwidth {
+		return &bucket{clock: clock}
+	}
+	if cfg.sharedBuckets[dir] == nil {
+		cfg.sharedBuckets[dir] = &bucket{clock: clock}
+	}
+	return cfg.sharedBuckets[dir]
+}
+
+// take reserves n bytes of credit, at the bandwidth and burst of the given
+// conditions, and returns how long the caller must wait before the bytes may
+// be sent. It returns zero when bandwidth is unlimited.
+//
+// A bandwidth varying over time is sampled when credit is taken, and credit
+// accrued since the last take is credited at that bandwidth.
+func (b *bucket) take(cond DirectionConfig, n int) time.Duration {
+	if (cond.Bandwidth <= 0 && cond.BandwidthFunc == nil) || n <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	bandwidth := cond.Bandwidth
+	if cond.BandwidthFunc != nil {
+		bandwidth = cond.BandwidthFunc(now)
+	}
+	if bandwidth <= 0 {
+		return 0
+	}
+
+	burst := cond.Burst
+	if burst <= 0 {
+		burst = bandwidth // One second of bandwidth
+	}
+
+	if !b.init {
+		b.tokens = float64(burst)
+		b.init = true
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(bandwidth)
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(bandwidth) * float64(time.Second))
+}
@@ -0,0 +1,210 @@
+package simnet
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestBucket(t *testing.T) {
+	t.Run("unlimited bandwidth never waits", func(t *testing.T) {
+		cfg := NewConfig()
+		b := newBucket(cfg, outbound)
+		must.Eq(t, 0, b.take(cfg.conditions(outbound, nil), 1<<20))
+	})
+
+	t.Run("burst is available immediately", func(t *testing.T) {
+		cfg := NewConfig(WithBandwidth(1000), WithBurst(500))
+		cond := cfg.conditions(outbound, nil)
+		b := newBucket(cfg, outbound)
+		must.Eq(t, 0, b.take(cond, 500))
+
+		// The next 100 bytes must wait for 100ms of credit.
+		wait := b.take(cond, 100)
+		must.Between(t, 90*time.Millisecond, wait, 100*time.Millisecond)
+	})
+
+	t.Run("burst defaults to one second of bandwidth", func(t *testing.T) {
+		cfg := NewConfig(WithBandwidth(1000))
+		cond := cfg.conditions(outbound, nil)
+		b := newBucket(cfg, outbound)
+		must.Eq(t, 0, b.take(cond, 1000))
+		must.Positive(t, b.take(cond, 100))
+	})
+
+	t.Run("concurrent takes converge to bandwidth", func(t *testing.T) {
+		const (
+			bandwidth = 10_000
+			writers   = 10
+			size      = 1_000
+		)
+
+		cfg := NewConfig(WithBandwidth(bandwidth), WithBurst(1))
+		cond := cfg.conditions(outbound, nil)
+		b := newBucket(cfg, outbound)
+
+		var (
+			mu      sync.Mutex
+			longest time.Duration
+			wg      sync.WaitGroup
+		)
+		for range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wait := b.take(cond, size)
+				mu.Lock()
+				longest = max(longest, wait)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		// 10KB at 10KB/s takes one second in total, regardless of how
+		// the writes are interleaved.
+		must.Between(t, 900*time.Millisecond, longest, time.Second)
+	})
+
+	t.Run("direction conditions are used", func(t *testing.T) {
+		cfg := NewConfig(WithInbound(DirectionConfig{Bandwidth: 1000, Burst: 1}))
+		must.Eq(t, 0, newBucket(cfg, outbound).take(cfg.conditions(outbound, nil), 1000))
+		must.Positive(t, newBucket(cfg, inbound).take(cfg.conditions(inbound, nil), 1000))
+	})
+}
+
+func TestBandwidthFunc(t *testing.T) {
+	const (
+		bandwidth = 10_000
+		chunk     = 100
+	)
+
+	// The bandwidth drops to almost nothing after one second.
+	clock := NewFakeClock()
+	step := clock.Now().Add(time.Second)
+	cfg := NewConfig(
+		WithClock(clock),
+		WithBurst(chunk),
+		WithBandwidthFunc(func(t time.Time) int64 {
+			if t.Before(step) {
+				return bandwidth
+			}
+			return 1
+		}),
+	)
+	cond := cfg.conditions(outbound, nil)
+	b := newBucket(cfg, outbound)
+
+	// Send chunks back to back for two seconds, waiting as long as the
+	// bucket says before each is sent.
+	var before, after int
+	for end := step.Add(time.Second); clock.Now().Before(end); {
+		clock.Advance(b.take(cond, chunk))
+		if clock.Now().Before(step) {
+			before += chunk
+		} else if clock.Now().Before(end) {
+			after += chunk
+		}
+	}
+	must.Between(t, 0.9*bandwidth, float64(before), bandwidth)
+	must.LessEq(t, chunk, after)
+}
+
+func TestSharedBandwidth(t *testing.T) {
+	const (
+		bandwidth = 100_000 // 100KBps
+		conns     = 4
+		chunk     = 1_000
+		chunks    = 25
+	)
+
+	// transfer writes chunks on every connection at once, returning the
+	// combined throughput in bytes per second once every chunk is received.
+	transfer := func(t *testing.T, opts ...Option) float64 {
+		cfg := NewConfig(append(opts, WithBandwidth(bandwidth), WithBurst(chunk))...)
+
+		var wg sync.WaitGroup
+		start := time.Now()
+		for range conns {
+			a, b := Pipe(cfg)
+			t.Cleanup(func() {
+				a.Close()
+				b.Close()
+			})
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				io.CopyN(io.Discard, b, chunks*chunk)
+			}()
+
+			go func() {
+				buf := make([]byte, chunk)
+				for range chunks {
+					a.Write(buf)
+				}
+			}()
+		}
+		wg.Wait()
+		return float64(conns*chunks*chunk) / time.Since(start).Seconds()
+	}
+
+	// Each connection gets the full bandwidth by default.
+	must.Greater(t, 3*bandwidth, transfer(t))
+
+	// Shared bandwidth caps the combined throughput.
+	must.Between(t, 0.8*bandwidth, transfer(t, WithSharedBandwidth(true)), 1.1*bandwidth)
+
+	t.Run("one bucket per direction", func(t *testing.T) {
+		cfg := NewConfig(WithSharedBandwidth(true))
+		must.EqOp(t, newBucket(cfg, outbound), newBucket(cfg, outbound))
+		must.EqOp(t, newBucket(cfg, inbound), newBucket(cfg, inbound))
+		must.NotEqOp(t, newBucket(cfg, inbound), newBucket(cfg, outbound))
+	})
+}
+
+func TestSharedBandwidthHeadOfLineBlocking(t *testing.T) {
+	const (
+		bandwidth = 20_000 // 20KBps
+		bulk      = 20_000
+		chunk     = 1_000
+	)
+
+	// ping queues a bulk transfer on one connection, then returns how long
+	// a ping written on another connection takes to arrive behind it.
+	ping := func(t *testing.T, opts ...Option) time.Duration {
+		cfg := NewConfig(append(opts, WithBandwidth(bandwidth), WithBurst(chunk))...)
+
+		bulkA, bulkB := Pipe(cfg)
+		pingA, pingB := Pipe(cfg)
+		t.Cleanup(func() {
+			bulkA.Close()
+			bulkB.Close()
+			pingA.Close()
+			pingB.Close()
+		})
+
+		go io.CopyN(io.Discard, bulkB, bulk)
+		buf := make([]byte, chunk)
+		for range bulk / chunk {
+			_, err := bulkA.Write(buf)
+			must.NoError(t, err)
+		}
+
+		start := time.Now()
+		_, err := pingA.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(pingB, make([]byte, 4))
+		must.NoError(t, err)
+		return time.Since(start)
+	}
+
+	// On separate links the ping is only held up by its own bandwidth.
+	must.Less(t, 250*time.Millisecond, ping(t))
+
+	// On a shared link the ping waits for the bulk transfer queued ahead of
+	// it to be sent.
+	must.Between(t, 800*time.Millisecond, ping(t, WithSharedBandwidth(true)), 1500*time.Millisecond)
+}
@@ -0,0 +1,193 @@
+package simnet
+
+import (
+	"maps"
+	"math/rand"
+	"time"
+)
+
+// Chain returns a Config combining the conditions of links that traffic
+// crosses one after another, such as from a client to an edge, a core, and a
+// server. Latency, jitter, and connect latency add up, and a packet must make
+// it across every link, so loss, reordering, duplication, and connection
+// failure and reset rates compose as one minus the product of one minus each
+// rate. Bandwidth and MTU are limited by the
+// narrowest link, and partitions on any link apply, including flapping
+// partitions, which keep the schedule they were given on their link.
+//
+// Conditions for specific addresses set with AddrConditions are not carried
+// over: they replace a link's conditions rather than adding to them, and a
+// single entry cannot describe how every link treats that address. Set them
+// on the returned config instead.
+//
+// The links are combined when Chain is called, so later changes to them do
+// not affect the returned config. Settings other than network conditions and
+// partitions, such as the seed, clock, and callbacks, are taken from the
+// first link.
+func Chain(links ...*Config) *Config {
+	cfg := NewConfig()
+	if len(links) == 0 {
+		return cfg
+	}
+
+	var in, out []DirectionConfig
+	directional := false
+	for _, link := range links {
+		in = append(in, link.conditions(inbound, nil))
+		out = append(out, link.conditions(outbound, nil))
+
+		link.mu.Lock()
+		directional = directional || link.Inbound != nil || link.Outbound != nil ||
+			link.DownloadBandwidth > 0 || link.UploadBandwidth > 0
+		if link.MTU > 0 && (cfg.MTU == 0 || link.MTU < cfg.MTU) {
+			cfg.MTU = link.MTU
+		}
+		cfg.ConnectLatency += link.ConnectLatency
+		cfg.ConnectFailureRate = composeRates(cfg.ConnectFailureRate, link.ConnectFailureRate)
+		cfg.ResetRate = composeRates(cfg.ResetRate, link.ResetRate)
+		if link.ResetAfter > 0 && (cfg.ResetAfter == 0 || link.ResetAfter < cfg.ResetAfter) {
+			cfg.ResetAfter = link.ResetAfter
+		}
+		maps.Copy(cfg.PartitionedAddrs, link.PartitionedAddrs)
+		cfg.partitionGroups = append(cfg.partitionGroups, link.partitionGroups...)
+		cfg.flapping = append(cfg.flapping, link.flapping...)
+		link.mu.Unlock()
+	}
+
+	if directional {
+		inDC, outDC := chainConditions(in), chainConditions(out)
+		cfg.Inbound, cfg.Outbound = &inDC, &outDC
+	} else {
+		// Without direction configs both directions are the same, so the
+		// top-level fields are set, allowing them to be changed later.
+		dc := chainConditions(out)
+		cfg.Latency = dc.Latency
+		cfg.Jitter = dc.Jitter
+		cfg.SymmetricJitter = dc.SymmetricJitter
+		cfg.LatencyDist = dc.LatencyDist
+		cfg.SpikeRate = dc.SpikeRate
+		cfg.SpikeLatency = dc.SpikeLatency
+		cfg.Bandwidth = dc.Bandwidth
+		cfg.BandwidthFunc = dc.BandwidthFunc
+		cfg.Burst = dc.Burst
+		cfg.LossRate = dc.LossRate
+		cfg.BitErrorRate = dc.BitErrorRate
+		cfg.ReorderRate = dc.ReorderRate
+		cfg.Reorder = dc.Reorder
+		cfg.DuplicateRate = dc.DuplicateRate
+		cfg.MaxDuplicates = dc.MaxDuplicates
+		cfg.DuplicateDelay = dc.DuplicateDelay
+	}
+
+	first := links[0]
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	cfg.Seed = first.Seed
+	cfg.RandSource = first.RandSource
+	cfg.Clock = first.Clock
+	cfg.Deterministic = first.Deterministic
+	cfg.InOrderDelivery = first.InOrderDelivery
+	cfg.NATRebind = first.NATRebind
+	cfg.ScriptedEvents = first.ScriptedEvents
+	cfg.SharedBandwidth = first.SharedBandwidth
+	cfg.QueueSize = first.QueueSize
+	cfg.LinkBuffer = first.LinkBuffer
+	cfg.LinkBufferDrop = first.LinkBufferDrop
+	cfg.ReadBufferSize = first.ReadBufferSize
+	cfg.MaxRetransmits = first.MaxRetransmits
+	cfg.RetransmitTimeout = first.RetransmitTimeout
+	cfg.TailDrop = first.TailDrop
+	cfg.WriteErrorOnLoss = first.WriteErrorOnLoss
+	cfg.StrictDatagramTruncation = first.StrictDatagramTruncation
+	cfg.DontFragment = first.DontFragment
+	cfg.PartitionTimeout = first.PartitionTimeout
+	cfg.MaxConns = first.MaxConns
+	cfg.RejectOverMaxConns = first.RejectOverMaxConns
+	cfg.CorruptFunc = first.CorruptFunc
+	cfg.Logger = first.Logger
+	cfg.TraceWriter = first.TraceWriter
+	cfg.OnDrop = first.OnDrop
+	cfg.OnDropTagged = first.OnDropTagged
+	cfg.OnDuplicate = first.OnDuplicate
+	cfg.OnReorder = first.OnReorder
+	cfg.OnDelay = first.OnDelay
+	cfg.OnSendError = first.OnSendError
+	return cfg
+}
+
+// chainConditions combines the conditions of links crossed one after
+// another in the same direction.
+func chainConditions(links []DirectionConfig) DirectionConfig {
+	var dc DirectionConfig
+	var bandwidthFuncs []func(time.Time) int64
+	uniform := true
+	for i, link := range links {
+		dc.Latency += link.Latency
+		dc.Jitter += link.Jitter
+		if link.LatencyDist != nil || link.SpikeRate > 0 || link.SymmetricJitter != links[0].SymmetricJitter {
+			uniform = false
+		}
+		if link.BandwidthFunc != nil {
+			bandwidthFuncs = append(bandwidthFuncs, link.BandwidthFunc)
+		} else if link.Bandwidth > 0 && (dc.Bandwidth == 0 || link.Bandwidth < dc.Bandwidth) {
+			dc.Bandwidth, dc.Burst = link.Bandwidth, link.Burst
+		}
+		if dc.Reorder == nil && link.Reorder != nil {
+			reorder := *link.Reorder
+			dc.Reorder = &reorder
+		}
+		if i == 0 {
+			dc.SymmetricJitter = link.SymmetricJitter
+		}
+		dc.LossRate = composeRates(dc.LossRate, link.LossRate)
+		dc.BitErrorRate = composeRates(dc.BitErrorRate, link.BitErrorRate)
+		dc.ReorderRate = composeRates(dc.ReorderRate, link.ReorderRate)
+		dc.DuplicateRate = composeRates(dc.DuplicateRate, link.DuplicateRate)
+		dc.MaxDuplicates = max(dc.MaxDuplicates, link.MaxDuplicates)
+		dc.DuplicateDelay = max(dc.DuplicateDelay, link.DuplicateDelay)
+	}
+
+	// Latency that is not a base latency with the same kind of jitter on
+	// every link, such as latency with spikes, is sampled from each link in
+	// turn.
+	if !uniform {
+		dc.LatencyDist = chainedLatency(links)
+	}
+	if len(bandwidthFuncs) > 0 {
+		dc.BandwidthFunc = narrowestBandwidth(dc.Bandwidth, bandwidthFuncs)
+	}
+	return dc
+}
+
+// narrowestBandwidth returns a bandwidth function returning the narrowest of
+// a fixed bandwidth and the bandwidths varying over time, where zero means
+// unlimited.
+func narrowestBandwidth(bandwidth int64, funcs []func(time.Time) int64) func(time.Time) int64 {
+	return func(t time.Time) int64 {
+		narrowest := bandwidth
+		for _, fn := range funcs {
+			if b := fn(t); b > 0 && (narrowest == 0 || b < narrowest) {
+				narrowest = b
+			}
+		}
+		return narrowest
+	}
+}
+
+// composeRates returns the rate at which something happens at least once
+// across two links, happening independently at rates a and b.
+func composeRates(a, b float64) float64 {
+	return 1 - (1-a)*(1-b)
+}
+
+// chainedLatency is a LatencyDistribution summing the latency of each link.
+type chainedLatency []DirectionConfig
+
+// Sample returns the sum of a latency drawn from each link.
+func (links chainedLatency) Sample(rand *rand.Rand) time.Duration {
+	var latency time.Duration
+	for _, link := range links {
+		latency += link.latency(rand)
+	}
+	return latency
+}
@@ -0,0 +1,150 @@
+package simnet_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestChain(t *testing.T) {
+	t.Run("latency adds up", func(t *testing.T) {
+		const latency = 10 * time.Millisecond
+
+		link := simnet.NewConfig(simnet.WithLatency(latency))
+		cfg := simnet.Chain(link, link, link)
+		must.Eq(t, 3*latency, cfg.Latency)
+
+		a, b := simnet.Pipe(cfg)
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		start := time.Now()
+		_, err := a.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(b, make([]byte, 4))
+		must.NoError(t, err)
+		must.Between(t, 3*latency, time.Since(start), 3*latency+100*time.Millisecond)
+	})
+
+	t.Run("loss compounds", func(t *testing.T) {
+		const packets = 5000
+
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithLossRate(0.1), simnet.WithSeed(42)),
+			simnet.NewConfig(simnet.WithLossRate(0.2)),
+			simnet.NewConfig(simnet.WithLossRate(0.3)),
+		)
+
+		// A packet survives with probability 0.9*0.8*0.7.
+		must.InDelta(t, 0.496, cfg.LossRate, 1e-9)
+
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		for range packets {
+			_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+		stats := conn.(simnet.StatsProvider).Stats()
+		must.Between(t, 0.47, float64(stats.PacketsDropped)/packets, 0.52)
+	})
+
+	t.Run("narrowest link", func(t *testing.T) {
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithBandwidth(1_000_000), simnet.WithMTU(9000)),
+			simnet.NewConfig(simnet.WithBandwidth(10_000), simnet.WithBurst(1_000), simnet.WithMTU(1500)),
+			simnet.NewConfig(),
+		)
+		must.Eq(t, 10_000, cfg.Bandwidth)
+		must.Eq(t, 1_000, cfg.Burst)
+		must.Eq(t, 1500, cfg.MTU)
+	})
+
+	t.Run("narrowest link over time", func(t *testing.T) {
+		start := time.Now()
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithBandwidth(10_000)),
+			simnet.NewConfig(simnet.WithBandwidthFunc(func(t time.Time) int64 {
+				if t.Before(start.Add(time.Second)) {
+					return 1_000_000
+				}
+				return 1_000
+			})),
+		)
+		must.Eq(t, 10_000, cfg.BandwidthFunc(start))
+		must.Eq(t, 1_000, cfg.BandwidthFunc(start.Add(time.Second)))
+	})
+
+	t.Run("connection setup", func(t *testing.T) {
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithConnectLatency(10*time.Millisecond), simnet.WithConnectFailureRate(0.5)),
+			simnet.NewConfig(simnet.WithConnectLatency(20*time.Millisecond), simnet.WithConnectFailureRate(0.5)),
+		)
+		must.Eq(t, 30*time.Millisecond, cfg.ConnectLatency)
+		must.Eq(t, 0.75, cfg.ConnectFailureRate)
+	})
+
+	t.Run("partitions on any link", func(t *testing.T) {
+		edge := simnet.NewConfig()
+		edge.AddPartition("10.0.0.1")
+		core := simnet.NewConfig()
+		core.AddPartition("10.0.0.2")
+
+		cfg := simnet.Chain(edge, core)
+		_, err := simnet.NewDialer(cfg).Dial("tcp", "10.0.0.1:80")
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+		_, err = simnet.NewDialer(cfg).Dial("tcp", "10.0.0.2:80")
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+	})
+
+	t.Run("flapping partitions", func(t *testing.T) {
+		clock := simnet.NewFakeClock()
+		edge := simnet.NewConfig(simnet.WithClock(clock))
+		core := simnet.NewConfig(simnet.WithClock(clock))
+		core.AddFlappingPartition("10.0.0.3", time.Minute, time.Minute)
+
+		cfg := simnet.Chain(edge, core)
+		must.True(t, cfg.IsPartitioned("10.0.0.3:80"))
+		clock.Advance(time.Minute)
+		must.False(t, cfg.IsPartitioned("10.0.0.3:80"))
+		clock.Advance(time.Minute)
+		must.True(t, cfg.IsPartitioned("10.0.0.3:80"))
+	})
+
+	t.Run("direction configs", func(t *testing.T) {
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithLatency(10*time.Millisecond)),
+			simnet.NewConfig(simnet.WithOutbound(simnet.DirectionConfig{Latency: 20 * time.Millisecond})),
+		)
+		must.NotNil(t, cfg.Inbound)
+		must.NotNil(t, cfg.Outbound)
+		must.Eq(t, 10*time.Millisecond, cfg.Inbound.Latency)
+		must.Eq(t, 30*time.Millisecond, cfg.Outbound.Latency)
+	})
+
+	t.Run("asymmetric bandwidth", func(t *testing.T) {
+		cfg := simnet.Chain(
+			simnet.NewConfig(simnet.WithAsymmetricBandwidth(100_000, 10_000)),
+			simnet.NewConfig(simnet.WithBandwidth(50_000)),
+		)
+		must.NotNil(t, cfg.Inbound)
+		must.NotNil(t, cfg.Outbound)
+		must.Eq(t, 50_000, cfg.Inbound.Bandwidth)
+		must.Eq(t, 10_000, cfg.Outbound.Bandwidth)
+	})
+}
@@ -0,0 +1,144 @@
+package simnet
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for simulated delays. The default is the real
+// clock; a FakeClock lets tests control time, so large simulated delays cost
+// no wall-clock time.
+//
+// Simulated delays block the goroutine applying them, such as the caller of
+// Write or the goroutine delivering packets, until the clock reaches the end
+// of the delay. With a FakeClock that means until another goroutine advances
+// it, so the goroutine calling Advance must not be the one blocked in Read or
+// Write.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Sleep blocks until the duration has elapsed.
+	Sleep(d time.Duration)
+
+	// After returns a channel that receives the current time once the
+	// duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// newClockTimer returns a channel that receives the time once d has elapsed on
+// clock, and a function that abandons the wait, so a loop that stops waiting
+// early does not leave the wait pending on the clock.
+func newClockTimer(clock Clock, d time.Duration) (<-chan time.Time, func()) {
+	switch c := clock.(type) {
+	case realClock:
+		t := time.NewTimer(d)
+		return t.C, func() { t.Stop() }
+	case *FakeClock:
+		ch := c.After(d)
+		return ch, func() { c.remove(ch) }
+	}
+	return clock.After(d), func() {}
+}
+
+// FakeClock is a Clock that only moves when advanced, for tests that need
+// simulated delays without waiting for them in real time.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a pending Sleep or After on a FakeClock.
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock returns a FakeClock starting at a fixed point in time.
+func NewFakeClock() *FakeClock {
+	c := &FakeClock{
+		now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the clock has been advanced by the duration.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// After returns a channel that receives the current time once the clock has
+// been advanced by the duration.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{
+		until: c.now.Add(d),
+		ch:    ch,
+	})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward by the duration, waking any sleepers whose
+// delay has elapsed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	clear(c.waiters[len(waiters):])
+	c.waiters = waiters
+}
+
+// remove abandons a pending After, so it no longer counts as a waiter.
+func (c *FakeClock) remove(ch <-chan time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waiters = slices.DeleteFunc(c.waiters, func(w fakeWaiter) bool {
+		return w.ch == ch
+	})
+}
+
+// BlockUntil blocks until at least n goroutines are waiting on the clock,
+// so a test can advance it once the delays it expects are in place.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
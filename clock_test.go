@@ -0,0 +1,109 @@
+package simnet_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := simnet.NewFakeClock()
+	start := clock.Now()
+
+	ch := clock.After(time.Second)
+	select {
+	case <-ch:
+		t.Fatal("expected After not to fire before the clock advances")
+	default:
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("expected After not to fire before its duration")
+	default:
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	must.Eq(t, start.Add(time.Second), <-ch)
+	must.Eq(t, start.Add(time.Second), clock.Now())
+
+	// Non-positive durations fire immediately.
+	must.Eq(t, start.Add(time.Second), <-clock.After(0))
+}
+
+func TestFakeClockLatency(t *testing.T) {
+	const latency = 10 * time.Second
+
+	t.Run("stream", func(t *testing.T) {
+		clock := simnet.NewFakeClock()
+		a, b := simnet.Pipe(simnet.NewConfig(
+			simnet.WithLatency(latency),
+			simnet.WithClock(clock),
+		))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		start := time.Now()
+		_, err := a.Write([]byte("ping"))
+		must.NoError(t, err)
+
+		read := make(chan string, 1)
+		go func() {
+			buf := make([]byte, 4)
+			io.ReadFull(b, buf)
+			read <- string(buf)
+		}()
+
+		// The write is held on the fake clock until it is advanced by the
+		// full latency.
+		clock.BlockUntil(1)
+		clock.Advance(latency - time.Millisecond)
+		select {
+		case <-read:
+			t.Fatal("expected write to wait for the full latency")
+		case <-time.After(10 * time.Millisecond):
+		}
+		clock.Advance(time.Millisecond)
+		must.Eq(t, "ping", <-read)
+		must.Less(t, time.Second, time.Since(start))
+	})
+
+	t.Run("packet conn", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		clock := simnet.NewFakeClock()
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(latency),
+			simnet.WithClock(clock),
+		)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		go conn.WriteTo([]byte("ping"), peer.LocalAddr())
+
+		clock.BlockUntil(1)
+		clock.Advance(latency)
+
+		buf := make([]byte, 4)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := peer.ReadFrom(buf)
+		must.NoError(t, err)
+		must.Eq(t, "ping", string(buf[:n]))
+		must.Less(t, time.Second, time.Since(start))
+	})
+}
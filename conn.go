@@ -1,131 +1,769 @@
 package simnet
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"os"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// minRetransmitTimeout is the minimum time taken to retransmit a lost
+// segment on a stream, matching the minimum TCP retransmission timeout used
+// by Linux.
+const minRetransmitTimeout = 200 * time.Millisecond
+
+// closeLinger is how long Close waits for pending writes to reach the
+// underlying connection, similar to SO_LINGER.
+const closeLinger = time.Second
+
+const (
+	readChunkSize = 32 << 10 // Bytes read from the underlying connection at once
+	maxReadBuffer = 1 << 20  // Bytes received but not yet read, like a receive buffer
+	copyChunkSize = 32 << 10 // Bytes copied at once by ReadFrom and WriteTo
+)
+
+// errWriteShut is returned by writes after CloseWrite.
+var errWriteShut = fmt.Errorf("%w: write side shut down", net.ErrClosed)
+
+// TaggedWriter is implemented by the stream connections returned by this
+// package, and by connected packet conns returned by DialUDP, to attach a tag
+// to a write, such as the ID of an application message. If the write is lost,
+// the tag is reported to the OnDropTagged callback. On stream connections a
+// lost write is retransmitted rather than lost for good, delaying it.
+type TaggedWriter interface {
+	WriteTagged(p []byte, tag string) (int, error)
+}
+
+// HalfCloser is implemented by stream connections that can shut down one side
+// of the connection while leaving the other open, such as *net.TCPConn and
+// *net.UnixConn. The connections returned by Dialer and Listener implement
+// it, forwarding to the underlying connection, along with syscall.Conn.
+// Other methods specific to the underlying connection, such as
+// SetNoDelay, can be reached through SyscallConn.
+type HalfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+// Flusher is implemented by the stream connections returned by this package,
+// allowing the data written to be waited for rather than slept through. Write
+// returns before the simulated delay, so without it a test asserting that
+// the peer received the data races against the simulation.
+type Flusher interface {
+	// Flush blocks until every write accepted so far has been written to
+	// the underlying connection, once its simulated delay has elapsed, or
+	// ctx is done. With concurrent writers, it waits until no writes are
+	// pending. It returns the context's error if ctx is done first, and
+	// ErrSendFailed if the underlying connection failed to send the data.
+	Flush(ctx context.Context) error
+}
+
 // simulatedConn is a net.Conn that simulates network conditions
 // such as latency, loss, duplication, and reordering.
+//
+// Simulated delays are applied by schedulers rather than by sleeping in Read
+// and Write, so that many writes and reads can be in flight at once, as on a
+// real link. Written data is scheduled for delivery to the underlying
+// connection once its delay has elapsed, and data received from the
+// underlying connection by readLoop is scheduled for delivery to Read.
 type simulatedConn struct {
 	conn    net.Conn
 	cfg     *Config
 	rand    *rand.Rand
-	readBuf []byte
-	mu      sync.Mutex
+	clock   Clock    // Source of time for simulated delays
+	sources []string // Addresses the local end may be reached at, for partition groups
+
+	inBucket  *bucket // Bandwidth limiter for reads
+	outBucket *bucket // Bandwidth limiter for writes
+	stats     stats   // Runtime statistics
+
+	// Pipe endpoints apply conditions only to the data they write, using
+	// writeDir, so traffic between them is simulated exactly once.
+	writeOnly bool
+	writeDir  direction
+
+	// Connections using a Passthrough config delegate reads and writes
+	// directly to the underlying connection, without any of the machinery
+	// below.
+	passthrough bool
+
+	writeSched *scheduler    // Delivers writes to the write queue
+	writeQueue chan *buffer  // Writes ready for the underlying connection
+	inflight   chan struct{} // Holds a slot for each write not yet queued
+
+	writeMu       sync.Mutex    // Guards the write state below
+	writeShut     bool          // Set by CloseWrite
+	pending       int           // Writes not yet written to the underlying connection
+	drained       chan struct{} // Closed once pending reaches zero, for CloseWrite and Flush
+	writeDeadline time.Time     // Deadline for Write
+	writeErr      error         // Failure to write to the underlying connection, returned by later calls
+
+	readSched    *scheduler    // Delivers received data to readBuf
+	mu           sync.Mutex    // Guards the read state below
+	readBuf      []byte        // Data delivered, read from readOff on
+	readOff      int           // Bytes of readBuf already read
+	readErr      error         // Error ending the stream, once delivered
+	buffered     int           // Bytes received but not yet read
+	readDeadline time.Time     // Deadline for Read
+	readChanged  chan struct{} // Closed and replaced when the read state changes
+	reset        bool          // Set once the connection is reset
+
+	closeOnce sync.Once
+	onClose   func()        // Called once when the connection is closed (optional)
+	closed    chan struct{} // Closed by Close
+	stopped   chan struct{} // Closed once pending writes are delivered
+	flushed   chan struct{} // Closed once the write queue is drained
+}
 
-	writeQueue chan []byte
-	closeOnce  sync.Once
-	closed     chan struct{}
+// WrapConn wraps an established connection with simulated network
+// conditions, for connections obtained other than through a Dialer or
+// Listener, such as from a library. Data written to the returned connection
+// experiences outbound conditions, and data read from it inbound conditions.
+// The returned connection takes ownership of conn, and implements
+// StatsProvider and PartitionReporter. A nil cfg applies no conditions.
+func WrapConn(conn net.Conn, cfg *Config) net.Conn {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	sc := wrapConn(conn, cfg)
+	sc.sources = sourceAddrs(conn.LocalAddr())
+	return sc
 }
 
 // wrapConn wraps an existing net.Conn with simulated network conditions.
-func wrapConn(conn net.Conn, cfg *Config) net.Conn {
-	sc := &simulatedConn{
-		conn:       conn,
-		cfg:        cfg,
-		rand:       cfg.randSource(),
-		writeQueue: make(chan []byte, 100),
-		closed:     make(chan struct{}),
+func wrapConn(conn net.Conn, cfg *Config) *simulatedConn {
+	if cfg.passthrough {
+		sc := &simulatedConn{conn: conn, cfg: cfg, passthrough: true}
+		sc.stats.link(cfg.totalStats())
+		return sc
+	}
+
+	sc := newSimulatedConn(conn, cfg)
+	sc.writeDir = outbound
+	sc.readSched = newScheduler(sc.clock, sc.closed, cfg.isDeterministic())
+	go sc.readLoop()
+	go sc.processWriteQueue()
+
+	cfg.mu.Lock()
+	resetAfter := cfg.ResetAfter
+	cfg.mu.Unlock()
+	if resetAfter > 0 {
+		go func() {
+			select {
+			case <-sc.clock.After(resetAfter):
+				sc.resetConn()
+			case <-sc.closed:
+			}
+		}()
 	}
+	return sc
+}
+
+// wrapPipeEnd wraps one end of an in-memory pipe, applying the conditions
+// for dir to the data it writes and none to the data it reads.
+func wrapPipeEnd(conn net.Conn, cfg *Config, dir direction) net.Conn {
+	if cfg.passthrough {
+		sc := &simulatedConn{conn: conn, cfg: cfg, passthrough: true}
+		sc.stats.link(cfg.totalStats())
+		return sc
+	}
+
+	sc := newSimulatedConn(conn, cfg)
+	sc.writeOnly = true
+	sc.writeDir = dir
 	go sc.processWriteQueue()
 	return sc
 }
 
-// Read reads data from the connection into a buffer, applying network conditions.
+// newSimulatedConn returns a simulatedConn for conn, without starting its
+// read loop or write queue.
+func newSimulatedConn(conn net.Conn, cfg *Config) *simulatedConn {
+	sc := &simulatedConn{
+		conn:        conn,
+		cfg:         cfg,
+		rand:        cfg.deriveRand(),
+		clock:       cfg.clock(),
+		inBucket:    newBucket(cfg, inbound),
+		outBucket:   newBucket(cfg, outbound),
+		writeQueue:  make(chan *buffer, cfg.queueSize()),
+		inflight:    make(chan struct{}, cfg.queueSize()),
+		readChanged: make(chan struct{}),
+		closed:      make(chan struct{}),
+		stopped:     make(chan struct{}),
+		flushed:     make(chan struct{}),
+	}
+	sc.writeSched = newScheduler(sc.clock, sc.stopped, cfg.isDeterministic())
+	sc.stats.link(cfg.totalStats())
+	return sc
+}
+
+// Read reads data from the connection into a buffer, once inbound network
+// conditions have been applied to it. Data that has already arrived is
+// returned before the connection reports being closed or reaching EOF, while
+// data still being delayed when the connection is closed is lost.
 func (sc *simulatedConn) Read(b []byte) (int, error) {
-	// Simulate loss
-	if sc.simulateLoss() {
-		// Return an error to simulate a network error
-		return 0, io.EOF
+	if sc.passthrough {
+		n, err := sc.conn.Read(b)
+		sc.stats.bytesReceived.Add(int64(n))
+		return n, err
+	}
+	if sc.writeOnly {
+		if sc.isReset() {
+			return 0, sc.resetError("read")
+		}
+		n, err := sc.conn.Read(b)
+		sc.stats.bytesReceived.Add(int64(n))
+		return n, err
 	}
 
-	// Read from the underlying connection into a buffer
-	buffer := make([]byte, len(b))
-	n, err := sc.conn.Read(buffer)
-	if n > 0 {
+	for {
 		sc.mu.Lock()
+		if sc.readOff < len(sc.readBuf) {
+			n := copy(b, sc.readBuf[sc.readOff:])
+			sc.readOff += n
+			if sc.readOff == len(sc.readBuf) {
+				// Keep the space for the data delivered next.
+				sc.readBuf, sc.readOff = sc.readBuf[:0], 0
+			}
+			sc.buffered -= n
+			sc.readStateChanged()
+			sc.mu.Unlock()
 
-		// Simulate duplication
-		if sc.simulateDuplication() {
-			sc.readBuf = append(sc.readBuf, buffer[:n]...)
+			sc.stats.bytesReceived.Add(int64(n))
+			return n, nil
 		}
-
-		// Simulate reordering
-		if sc.simulateReordering() && len(sc.readBuf) > 0 {
-			// Swap the current buffer with the stored buffer
-			temp := buffer[:n]
-			copy(b, sc.readBuf)
-			sc.readBuf = temp
+		if sc.reset {
+			sc.mu.Unlock()
+			return 0, sc.resetError("read")
+		}
+		select {
+		case <-sc.closed:
+			sc.mu.Unlock()
+			return 0, net.ErrClosed
+		default:
+		}
+		if sc.readErr != nil {
+			err := sc.readErr
 			sc.mu.Unlock()
+			return 0, err
+		}
+		if err := sc.getWriteErr(); err != nil {
+			sc.mu.Unlock()
+			return 0, err
+		}
+		deadline := sc.readDeadline
+		changed := sc.readChanged
+		sc.mu.Unlock()
+
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-sc.closed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
 
-			// Apply latency
-			sc.simulateLatency(n)
+// WriteTo implements io.WriterTo, writing data read from the connection to w
+// until EOF or an error. Each chunk is read as by Read, once inbound network
+// conditions have been applied to it. On a passthrough connection the copy is
+// delegated to the underlying connection, keeping its fast paths.
+func (sc *simulatedConn) WriteTo(w io.Writer) (int64, error) {
+	if sc.passthrough {
+		n, err := io.Copy(w, sc.conn)
+		sc.stats.bytesReceived.Add(n)
+		return n, err
+	}
 
-			return len(b), nil
+	var written int64
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, err := sc.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if m != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
 		}
+	}
+}
 
-		sc.mu.Unlock()
+// Write writes data to the connection, applying outbound network conditions.
+// It returns once the data is scheduled for delivery, without waiting for
+// the simulated delay, unless too many writes are already in flight. Since
+// the data is written to the underlying connection later, a failure to write
+// it is returned by subsequent calls to Write and Read, as ErrSendFailed.
+func (sc *simulatedConn) Write(b []byte) (int, error) {
+	return sc.write(b, "")
+}
 
-		// Apply latency
-		sc.simulateLatency(n)
+// WriteTagged writes data to the connection like Write, reporting the tag to
+// the OnDropTagged callback if the write is lost.
+func (sc *simulatedConn) WriteTagged(b []byte, tag string) (int, error) {
+	return sc.write(b, tag)
+}
 
-		// Copy data to the provided slice
-		copy(b, buffer[:n])
+// ReadFrom implements io.ReaderFrom, writing data read from r to the
+// connection until EOF or an error. Each chunk read from r is written as by
+// Write, so outbound network conditions are applied per chunk. On a
+// passthrough connection the copy is delegated to the underlying connection,
+// keeping its fast paths, such as sendfile.
+func (sc *simulatedConn) ReadFrom(r io.Reader) (int64, error) {
+	if sc.passthrough {
+		n, err := io.Copy(sc.conn, r)
+		sc.stats.bytesSent.Add(n)
 		return n, err
 	}
 
-	return n, err
+	var written int64
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// Write copies the data, so the buffer can be reused.
+			m, werr := sc.write(buf[:n], "")
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
 }
 
-// Write writes data to the connection, applying network conditions.
-func (sc *simulatedConn) Write(b []byte) (int, error) {
-	// Simulate loss
-	if sc.simulateLoss() {
-		// Pretend data was sent successfully
-		return len(b), nil
+// write writes data to the connection, applying outbound network conditions,
+// with the tag, if any, identifying the data in callbacks.
+func (sc *simulatedConn) write(b []byte, tag string) (int, error) {
+	if sc.passthrough {
+		n, err := sc.conn.Write(b)
+		sc.stats.bytesSent.Add(int64(n))
+		return n, err
+	}
+	if sc.isReset() {
+		return 0, sc.resetError("write")
+	}
+	select {
+	case <-sc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if err := sc.getWriteErr(); err != nil {
+		return 0, err
+	}
+	if sc.isWriteShut() {
+		return 0, errWriteShut
+	}
+	if deadline := sc.getWriteDeadline(); !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
 	}
 
-	// Simulate duplication
-	if sc.simulateDuplication() {
-		// Enqueue the data to be sent twice
-		dataCopy := append([]byte(nil), b...)
-		sc.enqueueWrite(dataCopy)
+	cond := sc.cfg.conditions(sc.writeDir, sc.conn.RemoteAddr())
+	sc.stats.packetsSent.Add(1)
+
+	// The random source is used by both the read and write paths, and may be
+	// shared with other connections, so decisions are drawn under the config
+	// lock.
+	sc.cfg.mu.Lock()
+	lost := sc.stats.loss(cond, sc.rand)
+	duplicates := sc.stats.duplicates(cond, sc.rand)
+	reorder := sc.stats.reorder(cond, sc.rand)
+	reset := sc.drawReset()
+	sc.cfg.mu.Unlock()
+
+	// Simulate a reset, failing the write and the connection.
+	if reset {
+		sc.resetConn()
+		return 0, sc.resetError("write")
 	}
 
-	// Simulate reordering
-	if sc.simulateReordering() {
-		// Enqueue the data to be sent later
-		dataCopy := append([]byte(nil), b...)
-		go func() {
-			sc.simulateLatency(len(dataCopy))
-			sc.enqueueWrite(dataCopy)
-		}()
-		return len(b), nil
+	delay := sc.delay(cond, sc.writeDir, len(b))
+
+	// Simulate loss. A stream retransmits lost segments, so rather than
+	// losing data its delivery, and that of the data behind it, is delayed
+	// by the retransmission.
+	if lost {
+		sc.cfg.onDrop(sc.conn.RemoteAddr(), len(b), tag)
+		delay += sc.retransmitDelay(cond, len(b))
 	}
 
-	// Apply latency
-	sc.simulateLatency(len(b))
+	// The data is copied into a pooled buffer, shared by the write and its
+	// duplicates, and released by each once delivered.
+	data := copyBuffer(b)
+	defer data.release()
+	data.b = sc.cfg.corrupt(data.b)
+
+	// Simulate bit errors in the data delivered.
+	if cond.BitErrorRate > 0 {
+		sc.cfg.mu.Lock()
+		cond.flipBits(data.b, sc.rand)
+		sc.cfg.mu.Unlock()
+	}
 
-	// Enqueue the data to be sent
-	dataCopy := append([]byte(nil), b...)
-	sc.enqueueWrite(dataCopy)
+	// Simulate duplication. Each duplicate takes its share of the
+	// bandwidth, delaying the write behind it.
+	for range duplicates {
+		sc.cfg.onDuplicate(sc.conn.RemoteAddr(), len(b))
+		if err := sc.scheduleWrite(delay, data); err != nil {
+			return 0, err
+		}
+		delay += sc.bucket(sc.writeDir).take(cond, len(b))
+	}
+
+	// Simulate reordering. A stream delivers bytes in order, so a segment
+	// arriving out of order is held by the receiver until the segments
+	// before it arrive; it is modeled as the whole write arriving late and
+	// holding up the data behind it.
+	if reorder {
+		sc.cfg.onReorder(sc.conn.RemoteAddr(), len(b))
+		delay += sc.delay(cond, sc.writeDir, 0)
+	}
+	if err := sc.scheduleWrite(delay, data); err != nil {
+		return 0, err
+	}
 
 	return len(b), nil
 }
 
-// Close closes the connection.
+// scheduleWrite schedules data to be queued for the underlying connection
+// after delay, but not before the data written before it. It blocks while too
+// many writes are in flight, until the write deadline.
+func (sc *simulatedConn) scheduleWrite(delay time.Duration, data *buffer) error {
+	sc.writeMu.Lock()
+	if sc.writeShut {
+		sc.writeMu.Unlock()
+		return errWriteShut
+	}
+	sc.pending++
+	deadline := sc.writeDeadline
+	sc.writeMu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case sc.inflight <- struct{}{}:
+	case <-sc.closed:
+		sc.writeDone()
+		return net.ErrClosed
+	case <-timeout:
+		sc.writeDone()
+		return os.ErrDeadlineExceeded
+	}
+
+	data.retain()
+	deliver := func() {
+		if err := sc.enqueueWrite(data); err != nil {
+			sc.writeDone()
+			data.release()
+		}
+		<-sc.inflight
+	}
+	sc.writeSched.scheduleInOrder(delay, deliver)
+	return nil
+}
+
+// writeDone records that a pending write has been written to the underlying
+// connection, or abandoned.
+func (sc *simulatedConn) writeDone() {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.pending--
+	if sc.pending == 0 && sc.drained != nil {
+		close(sc.drained)
+		sc.drained = nil
+	}
+}
+
+// getWriteDeadline returns the deadline for Write.
+func (sc *simulatedConn) getWriteDeadline() time.Time {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return sc.writeDeadline
+}
+
+// getWriteErr returns the failure to write to the underlying connection, if
+// any.
+func (sc *simulatedConn) getWriteErr() error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return sc.writeErr
+}
+
+// isWriteShut reports whether the write side has been closed by CloseWrite.
+func (sc *simulatedConn) isWriteShut() bool {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return sc.writeShut
+}
+
+// readLoop receives data from the underlying connection, scheduling it for
+// delivery to Read with inbound network conditions applied, until the
+// connection fails or is closed.
+func (sc *simulatedConn) readLoop() {
+	for {
+		if !sc.waitForReadSpace() {
+			return
+		}
+
+		buf := newBuffer(readChunkSize)
+		n, err := sc.conn.Read(buf.b)
+		buf.b = buf.b[:n]
+		if n > 0 {
+			sc.receive(buf)
+		}
+		buf.release()
+		if err != nil {
+			// The error is delivered after the data received before it.
+			sc.readSched.scheduleInOrder(0, func() {
+				sc.mu.Lock()
+				defer sc.mu.Unlock()
+				sc.readErr = err
+				sc.readStateChanged()
+			})
+			return
+		}
+	}
+}
+
+// waitForReadSpace waits until fewer than maxReadBuffer bytes are buffered,
+// reporting false if the connection is closed first.
+func (sc *simulatedConn) waitForReadSpace() bool {
+	for {
+		sc.mu.Lock()
+		if sc.buffered < maxReadBuffer {
+			sc.mu.Unlock()
+			return true
+		}
+		changed := sc.readChanged
+		sc.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-sc.closed:
+			return false
+		}
+	}
+}
+
+// receive schedules data received from the underlying connection for
+// delivery to Read, applying inbound network conditions.
+func (sc *simulatedConn) receive(buf *buffer) {
+	buf.b = sc.cfg.corrupt(buf.b)
+	data := buf.b
+	cond := sc.cfg.conditions(inbound, sc.conn.RemoteAddr())
+
+	sc.cfg.mu.Lock()
+	lost := sc.stats.loss(cond, sc.rand)
+	duplicates := sc.stats.duplicates(cond, sc.rand)
+	reorder := sc.stats.reorder(cond, sc.rand)
+	reset := sc.drawReset()
+	sc.cfg.mu.Unlock()
+
+	delay := sc.delay(cond, inbound, len(data))
+
+	// Simulate a reset arriving in place of the data, after the data
+	// received before it.
+	if reset {
+		sc.readSched.scheduleInOrder(delay, sc.resetConn)
+		return
+	}
+
+	// Simulate loss, retransmitting the lost segment.
+	if lost {
+		sc.cfg.onDrop(sc.conn.RemoteAddr(), len(data), "")
+		delay += sc.retransmitDelay(cond, len(data))
+	}
+
+	// Simulate bit errors in the data delivered.
+	if cond.BitErrorRate > 0 {
+		sc.cfg.mu.Lock()
+		cond.flipBits(data, sc.rand)
+		sc.cfg.mu.Unlock()
+	}
+
+	// Simulate duplication
+	for range duplicates {
+		sc.cfg.onDuplicate(sc.conn.RemoteAddr(), len(data))
+		sc.scheduleRead(delay, buf)
+	}
+
+	// Simulate reordering, holding up the data behind it as in Write.
+	if reorder {
+		sc.cfg.onReorder(sc.conn.RemoteAddr(), len(data))
+		delay += sc.delay(cond, inbound, 0)
+	}
+	sc.scheduleRead(delay, buf)
+}
+
+// scheduleRead schedules received data to be delivered to Read after delay,
+// but not before the data received before it.
+func (sc *simulatedConn) scheduleRead(delay time.Duration, data *buffer) {
+	sc.mu.Lock()
+	sc.buffered += len(data.b)
+	sc.mu.Unlock()
+
+	data.retain()
+	deliver := func() {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		// Move the unread data to the front rather than grow the buffer.
+		if sc.readOff > 0 && len(sc.readBuf)+len(data.b) > cap(sc.readBuf) {
+			n := copy(sc.readBuf, sc.readBuf[sc.readOff:])
+			sc.readBuf, sc.readOff = sc.readBuf[:n], 0
+		}
+		sc.readBuf = append(sc.readBuf, data.b...)
+		sc.readStateChanged()
+		data.release()
+	}
+	sc.readSched.scheduleInOrder(delay, deliver)
+}
+
+// readStateChanged wakes goroutines waiting on the read state. The caller
+// must hold sc.mu.
+func (sc *simulatedConn) readStateChanged() {
+	close(sc.readChanged)
+	sc.readChanged = make(chan struct{})
+}
+
+// Close closes the connection. Pending writes are delivered to the underlying
+// connection first, waiting up to closeLinger for them to be written, and
+// subsequent reads and writes return net.ErrClosed. Data received but not
+// yet read is discarded.
 func (sc *simulatedConn) Close() error {
+	if sc.passthrough {
+		sc.closeOnce.Do(func() {
+			if sc.onClose != nil {
+				sc.onClose()
+			}
+		})
+		return sc.conn.Close()
+	}
+
 	sc.closeOnce.Do(func() {
+		if sc.onClose != nil {
+			sc.onClose()
+		}
+
+		// The write queue is never closed, since writers may still be
+		// sending to it; closing the closed channel stops them instead.
 		close(sc.closed)
-		close(sc.writeQueue)
+
+		// Wait for writes in flight to be delivered, by taking every slot,
+		// unless the connection was reset, abandoning them.
+		linger := time.NewTimer(closeLinger)
+		defer linger.Stop()
+		if sc.isReset() {
+			linger.Reset(0)
+		}
+	drain:
+		for range cap(sc.inflight) {
+			select {
+			case sc.inflight <- struct{}{}:
+			case <-linger.C:
+				break drain
+			}
+		}
+		close(sc.stopped)
+
+		sc.conn.SetWriteDeadline(time.Now().Add(closeLinger))
+		<-sc.flushed
 	})
 	return sc.conn.Close()
 }
 
+// drawReset reports whether the connection is reset at this point, drawn at
+// Config.ResetRate. The caller must hold sc.cfg.mu.
+func (sc *simulatedConn) drawReset() bool {
+	return sc.cfg.ResetRate > 0 && sc.rand.Float64() < sc.cfg.ResetRate
+}
+
+// resetConn resets the connection as if the peer sent a RST: data received
+// but not yet read is discarded, reads and writes fail with a connection
+// reset error, and the underlying connection is closed, abortively if it
+// supports SetLinger.
+func (sc *simulatedConn) resetConn() {
+	sc.mu.Lock()
+	if sc.reset {
+		sc.mu.Unlock()
+		return
+	}
+	sc.reset = true
+	sc.readBuf, sc.readOff = nil, 0
+	sc.readStateChanged()
+	sc.mu.Unlock()
+
+	if conn, ok := sc.conn.(interface{ SetLinger(sec int) error }); ok {
+		conn.SetLinger(0)
+	}
+	sc.Close()
+}
+
+// isReset reports whether the connection has been reset.
+func (sc *simulatedConn) isReset() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.reset
+}
+
+// resetError returns the error for a read or write on a reset connection,
+// matching the error returned for a real reset, so that errors.Is reports
+// syscall.ECONNRESET.
+func (sc *simulatedConn) resetError(op string) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    sc.conn.LocalAddr().Network(),
+		Source: sc.conn.LocalAddr(),
+		Addr:   sc.conn.RemoteAddr(),
+		Err:    os.NewSyscallError(op, syscall.ECONNRESET),
+	}
+}
+
+// IsPartitioned reports whether the remote address is currently partitioned,
+// implementing PartitionReporter.
+func (sc *simulatedConn) IsPartitioned() bool {
+	return sc.cfg.isPartitionedFrom(sc.sources, sc.conn.RemoteAddr().String())
+}
+
 // LocalAddr returns the local network address.
 func (sc *simulatedConn) LocalAddr() net.Addr {
 	return sc.conn.LocalAddr()
@@ -138,79 +776,219 @@ func (sc *simulatedConn) RemoteAddr() net.Addr {
 
 // SetDeadline sets the read and write deadlines.
 func (sc *simulatedConn) SetDeadline(t time.Time) error {
-	return sc.conn.SetDeadline(t)
+	if err := sc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return sc.SetWriteDeadline(t)
 }
 
-// SetReadDeadline sets the read deadline.
+// SetReadDeadline sets the read deadline. Data is received from the
+// underlying connection in the background, so the deadline applies to Read
+// rather than to the underlying connection.
 func (sc *simulatedConn) SetReadDeadline(t time.Time) error {
-	return sc.conn.SetReadDeadline(t)
+	if sc.writeOnly || sc.passthrough {
+		return sc.conn.SetReadDeadline(t)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.readDeadline = t
+	sc.readStateChanged()
+	return nil
 }
 
-// SetWriteDeadline sets the write deadline.
+// SetWriteDeadline sets the write deadline. Write fails with a timeout once
+// it has passed, including while blocked because too many writes are in
+// flight. It also applies to delivering delayed writes to the underlying
+// connection.
 func (sc *simulatedConn) SetWriteDeadline(t time.Time) error {
+	sc.writeMu.Lock()
+	sc.writeDeadline = t
+	sc.writeMu.Unlock()
 	return sc.conn.SetWriteDeadline(t)
 }
 
-// simulateLatency applies latency and bandwidth limitations.
-func (sc *simulatedConn) simulateLatency(n int) {
-	delay := sc.calculateLatency(n)
-	if delay > 0 {
-		time.Sleep(delay)
+// CloseWrite shuts down the writing side of the underlying connection, once
+// pending writes have been delivered to it, so the peer reads EOF after the
+// data already written. Subsequent writes fail. It returns an error wrapping
+// errors.ErrUnsupported if the underlying connection cannot be half closed.
+func (sc *simulatedConn) CloseWrite() error {
+	conn, ok := sc.conn.(HalfCloser)
+	if !ok {
+		return fmt.Errorf("%w: %T does not support CloseWrite", errors.ErrUnsupported, sc.conn)
+	}
+
+	sc.writeMu.Lock()
+	sc.writeShut = true
+	drained := sc.drainedLocked()
+	sc.writeMu.Unlock()
+
+	select {
+	case <-drained:
+		return conn.CloseWrite()
+	case <-sc.closed:
+		return net.ErrClosed
+	}
+}
+
+// Flush blocks until every write accepted so far has been written to the
+// underlying connection, implementing Flusher.
+func (sc *simulatedConn) Flush(ctx context.Context) error {
+	if sc.passthrough {
+		return nil
+	}
+	select {
+	case <-sc.closed:
+		return net.ErrClosed
+	default:
+	}
+
+	sc.writeMu.Lock()
+	drained := sc.drainedLocked()
+	sc.writeMu.Unlock()
+
+	select {
+	case <-drained:
+		return sc.getWriteErr()
+	case <-sc.closed:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// calculateLatency calculates the latency based on the network configuration.
-func (sc *simulatedConn) calculateLatency(n int) time.Duration {
-	latency := sc.cfg.Latency
-	if sc.cfg.Jitter > 0 {
-		jitter := time.Duration(sc.rand.Int63n(int64(sc.cfg.Jitter)))
-		latency += jitter
+// drainedLocked returns a channel closed once no writes are pending. The
+// caller must hold sc.writeMu.
+func (sc *simulatedConn) drainedLocked() <-chan struct{} {
+	if sc.pending == 0 {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+	if sc.drained == nil {
+		sc.drained = make(chan struct{})
 	}
-	if sc.cfg.Bandwidth > 0 && n > 0 {
-		transferTime := time.Duration(float64(n) / float64(sc.cfg.Bandwidth) * float64(time.Second))
-		latency += transferTime
+	return sc.drained
+}
+
+// CloseRead shuts down the reading side of the underlying connection. Data
+// already received but not yet read is still returned by Read, followed by
+// EOF. It returns an error wrapping errors.ErrUnsupported if the underlying
+// connection cannot be half closed.
+func (sc *simulatedConn) CloseRead() error {
+	conn, ok := sc.conn.(HalfCloser)
+	if !ok {
+		return fmt.Errorf("%w: %T does not support CloseRead", errors.ErrUnsupported, sc.conn)
+	}
+	return conn.CloseRead()
+}
+
+// SyscallConn returns a raw network connection for the underlying connection,
+// if it implements syscall.Conn. This allows socket options to be set on
+// connections dialed or accepted with simulated network conditions. Data read
+// or written through the raw connection bypasses the simulation.
+func (sc *simulatedConn) SyscallConn() (syscall.RawConn, error) {
+	conn, ok := sc.conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not implement syscall.Conn", errors.ErrUnsupported, sc.conn)
 	}
-	return latency
+	return conn.SyscallConn()
 }
 
-// simulateLoss determines if a packet should be dropped based on the loss rate.
-func (sc *simulatedConn) simulateLoss() bool {
-	return sc.cfg.LossRate > 0 && sc.rand.Float64() < sc.cfg.LossRate
+// delay returns the latency and bandwidth delay for n bytes travelling in the
+// given direction, recording it in the statistics.
+func (sc *simulatedConn) delay(cond DirectionConfig, dir direction, n int) time.Duration {
+	sc.cfg.mu.Lock()
+	latency := cond.latency(sc.rand)
+	sc.cfg.mu.Unlock()
+
+	delay := latency + sc.bucket(dir).take(cond, n)
+	sc.stats.delay(delay)
+	sc.cfg.onDelay(sc.conn.RemoteAddr(), n, delay)
+	return delay
 }
 
-// simulateReordering determines if a packet should be reordered based on the reorder rate.
-func (sc *simulatedConn) simulateReordering() bool {
-	return sc.cfg.ReorderRate > 0 && sc.rand.Float64() < sc.cfg.ReorderRate
+// retransmitDelay returns the delay of retransmitting a lost segment of n
+// bytes, modeled as the additional round trip needed to detect and resend it,
+// but no less than the minimum retransmission timeout.
+func (sc *simulatedConn) retransmitDelay(cond DirectionConfig, n int) time.Duration {
+	sc.cfg.mu.Lock()
+	latency := cond.latency(sc.rand)
+	sc.cfg.mu.Unlock()
+
+	delay := max(2*latency, minRetransmitTimeout)
+	sc.stats.delay(delay)
+	sc.cfg.onDelay(sc.conn.RemoteAddr(), n, delay)
+	return delay
 }
 
-// simulateDuplication determines if a packet should be duplicated based on the duplicate rate.
-func (sc *simulatedConn) simulateDuplication() bool {
-	return sc.cfg.DuplicateRate > 0 && sc.rand.Float64() < sc.cfg.DuplicateRate
+// Stats returns the runtime statistics collected for the connection.
+func (sc *simulatedConn) Stats() Stats {
+	return sc.stats.snapshot()
 }
 
-// enqueueWrite enqueues data to be written to the underlying connection.
-func (sc *simulatedConn) enqueueWrite(data []byte) {
+// bucket returns the bandwidth limiter for the given direction.
+func (sc *simulatedConn) bucket(dir direction) *bucket {
+	if dir == outbound {
+		return sc.outBucket
+	}
+	return sc.inBucket
+}
+
+// enqueueWrite enqueues data to be written to the underlying connection,
+// returning net.ErrClosed if the connection has stopped.
+func (sc *simulatedConn) enqueueWrite(data *buffer) error {
 	select {
 	case sc.writeQueue <- data:
-	case <-sc.closed:
+		return nil
+	case <-sc.stopped:
+		return net.ErrClosed
 	}
 }
 
-// processWriteQueue processes the write queue, writing data to the underlying connection.
+// processWriteQueue processes the write queue, writing data to the underlying
+// connection. Once the connection has stopped, data still in the queue is
+// flushed before it returns.
 func (sc *simulatedConn) processWriteQueue() {
+	defer close(sc.flushed)
 	for {
 		select {
-		case data, ok := <-sc.writeQueue:
-			if !ok {
-				return
+		case data := <-sc.writeQueue:
+			sc.writeQueued(data)
+		case <-sc.stopped:
+			for {
+				select {
+				case data := <-sc.writeQueue:
+					sc.writeQueued(data)
+				default:
+					return
+				}
 			}
-			// Write to the underlying connection
-			_, err := sc.conn.Write(data)
-			if err != nil {
-				// Handle error if necessary
-			}
-		case <-sc.closed:
-			return
 		}
 	}
 }
+
+// writeQueued writes data taken from the write queue to the underlying
+// connection, releasing it afterwards. Once a write fails, the data behind it
+// is discarded, since the stream is broken.
+func (sc *simulatedConn) writeQueued(data *buffer) {
+	defer sc.writeDone()
+	defer data.release()
+	if sc.getWriteErr() != nil {
+		return
+	}
+	n, err := sc.conn.Write(data.b)
+	sc.stats.bytesSent.Add(int64(n))
+	if err != nil {
+		sc.writeMu.Lock()
+		sc.writeErr = &Error{Err: fmt.Errorf("%w: %w", ErrSendFailed, err)}
+		sc.writeMu.Unlock()
+
+		// Wake any blocked Read to report the failure.
+		sc.mu.Lock()
+		sc.readStateChanged()
+		sc.mu.Unlock()
+
+		sc.cfg.onSendError(sc.conn.RemoteAddr(), len(data.b), err)
+	}
+}
@@ -1,12 +1,24 @@
 package simnet_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/bits"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
 	"time"
 
 	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
 )
 
 func ExampleConn() {
@@ -44,8 +56,11 @@ func ExampleConn() {
 		return
 	}
 
-	// Wait for the data to be received
-	time.Sleep(200 * time.Millisecond)
+	// Wait for the data to be sent
+	if err := conn.(simnet.Flusher).Flush(context.Background()); err != nil {
+		fmt.Printf("Flush error: %v\n", err)
+		return
+	}
 
 	// Read response
 	buf := make([]byte, 1024)
@@ -61,6 +76,7 @@ func ExampleConn() {
 
 	// Output:
 	// Server received: Hello, simnet!
+	// Received: Hello, simnet!
 }
 
 func startServer() {
@@ -93,3 +109,1164 @@ func startServer() {
 		}(conn)
 	}
 }
+
+func TestConnReadLoss(t *testing.T) {
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(
+		simnet.WithInbound(simnet.DirectionConfig{
+			Latency:  time.Millisecond,
+			LossRate: 0.5,
+		}),
+		simnet.WithSeed(42),
+	)
+
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	var sent bytes.Buffer
+	for i := range 100 {
+		msg := fmt.Sprintf("message-%03d\n", i)
+		sent.WriteString(msg)
+
+		_, err := conn.Write([]byte(msg))
+		must.NoError(t, err)
+	}
+
+	// Every message is still received despite the simulated loss.
+	received := make([]byte, sent.Len())
+	_, err = io.ReadFull(conn, received)
+	must.NoError(t, err)
+	must.Eq(t, sent.String(), string(received))
+
+	// The stream remains usable afterwards.
+	_, err = conn.Write([]byte("still alive"))
+	must.NoError(t, err)
+
+	buf := make([]byte, len("still alive"))
+	_, err = io.ReadFull(conn, buf)
+	must.NoError(t, err)
+	must.Eq(t, "still alive", string(buf))
+}
+
+func TestConnWriteLoss(t *testing.T) {
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(
+		simnet.WithOutbound(simnet.DirectionConfig{
+			LossRate: 0.5,
+		}),
+		simnet.WithSeed(42),
+	)
+
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	var sent bytes.Buffer
+	start := time.Now()
+	for i := range 10 {
+		msg := fmt.Sprintf("message-%03d\n", i)
+		sent.WriteString(msg)
+
+		_, err := conn.Write([]byte(msg))
+		must.NoError(t, err)
+	}
+
+	// Lost writes are retransmitted, so they take longer but no bytes are
+	// missing from the stream.
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Positive(t, stats.PacketsDropped)
+
+	received := make([]byte, sent.Len())
+	_, err = io.ReadFull(conn, received)
+	must.NoError(t, err)
+	must.Eq(t, sent.String(), string(received))
+	must.GreaterEq(t, 200*time.Millisecond, time.Since(start))
+}
+
+func TestConnConcurrentWriteAndClose(t *testing.T) {
+	for range 10 {
+		a, b := simnet.Pipe(simnet.NewConfig(
+			simnet.WithDuplicateRate(0.1),
+			simnet.WithReorderRate(0.1),
+		))
+		go io.Copy(io.Discard, b)
+
+		var (
+			wg     sync.WaitGroup
+			writes atomic.Int64
+		)
+		errs := make(chan error, 50)
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					_, err := a.Write([]byte("data"))
+					if err != nil {
+						errs <- err
+						return
+					}
+					writes.Add(1)
+				}
+			}()
+		}
+
+		// Close while the writers are still running.
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool {
+				return writes.Load() >= 100
+			}),
+			wait.Timeout(5*time.Second),
+			wait.Gap(time.Millisecond),
+		))
+		must.NoError(t, a.Close())
+		wg.Wait()
+		b.Close()
+		close(errs)
+
+		for err := range errs {
+			must.ErrorIs(t, err, net.ErrClosed)
+		}
+
+		// Writes after close fail rather than panic.
+		_, err := a.Write([]byte("data"))
+		must.ErrorIs(t, err, net.ErrClosed)
+	}
+}
+
+func TestConnCloseFlushesWrites(t *testing.T) {
+	a, b := simnet.Pipe(simnet.NewConfig())
+	t.Cleanup(func() {
+		b.Close()
+	})
+
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(b)
+		received <- data
+	}()
+
+	_, err := a.Write([]byte("hello"))
+	must.NoError(t, err)
+	must.NoError(t, a.Close())
+
+	select {
+	case data := <-received:
+		must.Eq(t, "hello", string(data))
+	case <-time.After(time.Second):
+		t.Fatal("expected queued data to be delivered on close")
+	}
+}
+
+func TestConnReorderKeepsStreamOrder(t *testing.T) {
+	const messages = 200
+
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithJitter(5*time.Millisecond),
+		simnet.WithReorderRate(0.5),
+		simnet.WithSeed(42),
+	)
+
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// Each message is framed with its length, as by a websocket or other
+	// message protocol, so a byte out of place corrupts every later frame.
+	go func() {
+		for i := range messages {
+			msg := fmt.Sprintf("message-%d", i)
+			frame := append([]byte{byte(len(msg))}, msg...)
+			if _, err := conn.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := range messages {
+		var size [1]byte
+		_, err := io.ReadFull(conn, size[:])
+		must.NoError(t, err)
+
+		msg := make([]byte, size[0])
+		_, err = io.ReadFull(conn, msg)
+		must.NoError(t, err)
+		must.Eq(t, fmt.Sprintf("message-%d", i), string(msg))
+	}
+	must.Positive(t, conn.(simnet.StatsProvider).Stats().PacketsReordered)
+}
+
+func TestConnShortReads(t *testing.T) {
+	const messages = 100
+
+	a, b := simnet.Pipe(simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithJitter(5*time.Millisecond),
+		simnet.WithReorderRate(0.5),
+		simnet.WithSeed(7),
+	))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	var want bytes.Buffer
+	for i := range messages {
+		fmt.Fprintf(&want, "m%d;", i)
+	}
+	go func() {
+		for i := range messages {
+			if _, err := fmt.Fprintf(a, "m%d;", i); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Read small writes into a large buffer. Each read returns only the
+	// bytes delivered, leaving the rest of the buffer untouched.
+	var got []byte
+	buf := make([]byte, 64*1024)
+	for len(got) < want.Len() {
+		for i := range buf {
+			buf[i] = 0xff
+		}
+		n, err := b.Read(buf)
+		must.NoError(t, err)
+		must.Positive(t, n)
+		must.LessEq(t, want.Len()-len(got), n)
+		must.Eq(t, -1, bytes.IndexByte(buf[:n], 0xff))
+		must.Eq(t, len(buf)-n, bytes.Count(buf[n:], []byte{0xff}))
+		got = append(got, buf[:n]...)
+	}
+	must.True(t, bytes.Equal(want.Bytes(), got))
+	must.Positive(t, a.(simnet.StatsProvider).Stats().PacketsReordered)
+}
+
+func TestConnConcurrentRand(t *testing.T) {
+	const (
+		conns    = 8
+		messages = 50
+	)
+
+	addr := startEchoServer(t)
+
+	// Every connection draws loss, duplication, reordering, and latency
+	// decisions for both directions at once, which the race detector flags
+	// if the random source is used without synchronization.
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithJitter(time.Millisecond),
+		simnet.WithLossRate(0.1),
+		simnet.WithDuplicateRate(0.1),
+		simnet.WithReorderRate(0.1),
+		simnet.WithSeed(42),
+	)
+	dialer := simnet.NewDialer(cfg)
+
+	received := make(chan string, conns)
+	for range conns {
+		conn, err := dialer.Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		go func() {
+			for range messages {
+				if _, err := conn.Write([]byte("ping")); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			buf := make([]byte, len("ping")*messages)
+			n, _ := io.ReadFull(conn, buf)
+			received <- string(buf[:n])
+		}()
+	}
+
+	for range conns {
+		must.Eq(t, strings.Repeat("ping", messages), <-received)
+	}
+}
+
+func TestConnReset(t *testing.T) {
+	// isReset reports whether err is a connection reset, as applications
+	// detect it.
+	isReset := func(err error) bool {
+		var opErr *net.OpError
+		return errors.Is(err, syscall.ECONNRESET) && errors.As(err, &opErr)
+	}
+
+	t.Run("mid-read", func(t *testing.T) {
+		const resetAfter = 100 * time.Millisecond
+
+		addr := startEchoServer(t)
+		conn, err := simnet.NewDialer(simnet.NewConfig(simnet.WithResetAfter(resetAfter))).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+
+		// The read waiting for more data fails when the connection is
+		// reset.
+		start := time.Now()
+		_, err = conn.Read(buf)
+		must.True(t, isReset(err))
+		must.GreaterEq(t, resetAfter/2, time.Since(start))
+
+		// The connection is unusable afterwards.
+		_, err = conn.Write([]byte("ping"))
+		must.True(t, isReset(err))
+		_, err = conn.Read(buf)
+		must.True(t, isReset(err))
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		addr := startEchoServer(t)
+		conn, err := simnet.NewDialer(simnet.NewConfig(simnet.WithResetRate(1))).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.Write([]byte("ping"))
+		must.True(t, isReset(err))
+		_, err = conn.Read(make([]byte, 4))
+		must.True(t, isReset(err))
+	})
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	const latency = 100 * time.Millisecond
+
+	a, b := simnet.Pipe(simnet.NewConfig(simnet.WithLatency(latency)))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	_, err := a.Write([]byte("ping"))
+	must.NoError(t, err)
+
+	// The data is still delayed when a deadline shorter than the latency
+	// passes.
+	buf := make([]byte, 16)
+	b.SetReadDeadline(time.Now().Add(latency / 2))
+	_, err = b.Read(buf)
+	must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	var netErr net.Error
+	must.True(t, errors.As(err, &netErr))
+	must.True(t, netErr.Timeout())
+
+	// A deadline longer than the latency leaves time for it to arrive.
+	b.SetReadDeadline(time.Now().Add(2 * latency))
+	n, err := b.Read(buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf[:n]))
+}
+
+func TestConnCloseAbandonsLatency(t *testing.T) {
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{
+		Latency: 5 * time.Second,
+	}))
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+
+	// Closing the connection unblocks a read waiting on simulated latency,
+	// discarding the data it holds.
+	time.AfterFunc(100*time.Millisecond, func() {
+		conn.Close()
+	})
+	start := time.Now()
+	n, err := conn.Read(make([]byte, 4))
+	must.ErrorIs(t, err, net.ErrClosed)
+	must.Zero(t, n)
+	must.Less(t, time.Second, time.Since(start))
+}
+
+func TestConnReadAfterClose(t *testing.T) {
+	const latency = 20 * time.Millisecond
+
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{
+		Latency:     latency,
+		ReorderRate: 1.0,
+	}), simnet.WithSeed(42))
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+
+	_, err = conn.Write([]byte("hello world"))
+	must.NoError(t, err)
+
+	// Once the reordered echo has arrived, closing the connection does not
+	// lose it.
+	time.Sleep(20 * latency)
+	must.NoError(t, conn.Close())
+
+	data, err := io.ReadAll(conn)
+	must.ErrorIs(t, err, net.ErrClosed)
+	must.Eq(t, "hello world", string(data))
+	must.Positive(t, conn.(simnet.StatsProvider).Stats().PacketsReordered)
+}
+
+func TestConnWriteDeadline(t *testing.T) {
+	const latency = 200 * time.Millisecond
+
+	a, b := simnet.Pipe(simnet.NewConfig(
+		simnet.WithLatency(latency),
+		simnet.WithQueueSize(1),
+	))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	go io.Copy(io.Discard, b)
+
+	// A write after the deadline has passed times out.
+	must.NoError(t, a.SetWriteDeadline(time.Now().Add(-time.Second)))
+	n, err := a.Write([]byte("ping"))
+	must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	must.Zero(t, n)
+	var netErr net.Error
+	must.True(t, errors.As(err, &netErr))
+	must.True(t, netErr.Timeout())
+
+	// Clearing the deadline allows writes again.
+	must.NoError(t, a.SetWriteDeadline(time.Time{}))
+	_, err = a.Write([]byte("ping"))
+	must.NoError(t, err)
+
+	// A write blocked behind a full queue times out at the deadline, rather
+	// than when the write ahead of it is delivered.
+	must.NoError(t, a.SetWriteDeadline(time.Now().Add(latency/4)))
+	start := time.Now()
+	_, err = a.Write([]byte("ping"))
+	must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	must.Less(t, latency, time.Since(start))
+}
+
+func TestConnSendError(t *testing.T) {
+	inner, peer := net.Pipe()
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	failed := make(chan error, 1)
+	conn := simnet.WrapConn(inner, simnet.NewConfig(
+		simnet.WithOnSendError(func(addr net.Addr, size int, err error) {
+			must.Eq(t, 4, size)
+			failed <- err
+		}),
+	))
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// Break writes on the underlying connection. The write is accepted
+	// before the data is written, so the failure is reported later.
+	must.NoError(t, inner.SetWriteDeadline(time.Now().Add(-time.Second)))
+	_, err := conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	must.ErrorIs(t, <-failed, os.ErrDeadlineExceeded)
+
+	// The stream is broken, so every later write fails.
+	for range 2 {
+		n, err := conn.Write([]byte("ping"))
+		must.ErrorIs(t, err, simnet.ErrSendFailed)
+		must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		must.Zero(t, n)
+	}
+
+	// Reads fail too, once nothing is left to read.
+	must.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 16))
+	must.ErrorIs(t, err, simnet.ErrSendFailed)
+}
+
+func TestConnWriteStalled(t *testing.T) {
+	const deadline = 100 * time.Millisecond
+
+	// Nothing reads from the peer, so writes to the underlying connection
+	// stall and the write queue fills up.
+	inner, peer := net.Pipe()
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	conn := simnet.WrapConn(inner, simnet.NewConfig(simnet.WithQueueSize(2)))
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	must.NoError(t, conn.SetWriteDeadline(time.Now().Add(deadline)))
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	// Write times out waiting for room rather than blocking forever.
+	select {
+	case err := <-errs:
+		must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		var netErr net.Error
+		must.True(t, errors.As(err, &netErr))
+		must.True(t, netErr.Timeout())
+		must.Between(t, deadline/2, time.Since(start), 2*time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked past its deadline")
+	}
+}
+
+func TestConnFlush(t *testing.T) {
+	const latency = 200 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	conn, err := simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(latency))).Dial("tcp", ln.Addr().String())
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	server, err := ln.Accept()
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	t.Run("delivered", func(t *testing.T) {
+		start := time.Now()
+		_, err := conn.Write([]byte("hello"))
+		must.NoError(t, err)
+		must.NoError(t, conn.(simnet.Flusher).Flush(context.Background()))
+		must.GreaterEq(t, latency, time.Since(start))
+
+		// The data has been sent, so it can be read well within the
+		// simulated latency.
+		server.SetReadDeadline(time.Now().Add(latency / 2))
+		buf := make([]byte, 16)
+		n, err := server.Read(buf)
+		must.NoError(t, err)
+		must.Eq(t, "hello", string(buf[:n]))
+	})
+
+	t.Run("nothing pending", func(t *testing.T) {
+		must.NoError(t, conn.(simnet.Flusher).Flush(context.Background()))
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		_, err := conn.Write([]byte("hello"))
+		must.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), latency/10)
+		defer cancel()
+		must.ErrorIs(t, conn.(simnet.Flusher).Flush(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		must.NoError(t, conn.Close())
+		must.ErrorIs(t, conn.(simnet.Flusher).Flush(context.Background()), net.ErrClosed)
+	})
+}
+
+func TestConnQueueSize(t *testing.T) {
+	const (
+		latency = 50 * time.Millisecond
+		writes  = 5
+	)
+
+	// writeTime returns how long it takes to write, without reading.
+	writeTime := func(t *testing.T, queueSize int) time.Duration {
+		a, b := simnet.Pipe(simnet.NewConfig(
+			simnet.WithLatency(latency),
+			simnet.WithQueueSize(queueSize),
+		))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+		go io.Copy(io.Discard, b)
+
+		start := time.Now()
+		for range writes {
+			_, err := a.Write([]byte("ping"))
+			must.NoError(t, err)
+		}
+		return time.Since(start)
+	}
+
+	// With room for every write, writes return without waiting for the
+	// latency.
+	must.Less(t, latency, writeTime(t, writes))
+
+	// With room for one, each write waits for the one before it to be
+	// delivered.
+	must.GreaterEq(t, (writes-1)*latency, writeTime(t, 1))
+}
+
+func TestConnDuplicateBandwidth(t *testing.T) {
+	const (
+		bandwidth = 20_000 // 20KBps
+		chunk     = 1_000
+		chunks    = 10
+	)
+
+	// transfer writes every chunk, returning how long the receiver takes
+	// to read everything delivered and the sender.
+	transfer := func(t *testing.T, duplicateRate float64) (time.Duration, net.Conn) {
+		a, b := simnet.Pipe(simnet.NewConfig(
+			simnet.WithBandwidth(bandwidth),
+			simnet.WithBurst(chunk),
+			simnet.WithDuplicateRate(duplicateRate),
+		))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		copies := 1
+		if duplicateRate == 1 {
+			copies = 2
+		}
+
+		start := time.Now()
+		go func() {
+			buf := make([]byte, chunk)
+			for range chunks {
+				a.Write(buf)
+			}
+		}()
+		_, err := io.CopyN(io.Discard, b, int64(copies*chunks*chunk))
+		must.NoError(t, err)
+		return time.Since(start), a
+	}
+
+	plain, _ := transfer(t, 0)
+	duplicated, conn := transfer(t, 1)
+
+	// Duplicates are sent over the same link, so they take as long to
+	// deliver as the data they copy.
+	must.Greater(t, plain*3/2, duplicated)
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			return conn.(simnet.StatsProvider).Stats().BytesSent == 2*chunks*chunk
+		}),
+		wait.Timeout(time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+}
+
+func TestConnSyscallConn(t *testing.T) {
+	t.Run("dialed connection", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		conn, err := simnet.NewDialer(simnet.NewConfig()).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		sc, ok := conn.(syscall.Conn)
+		must.True(t, ok)
+
+		raw, err := sc.SyscallConn()
+		must.NoError(t, err)
+
+		var called bool
+		must.NoError(t, raw.Control(func(fd uintptr) {
+			called = true
+		}))
+		must.True(t, called)
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		a, b := simnet.Pipe(simnet.NewConfig())
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		// In-memory pipes have no file descriptor.
+		_, err := a.(syscall.Conn).SyscallConn()
+		must.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	// The server reads until EOF, then replies with what it read.
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		data, _ := io.ReadAll(c)
+		c.Write(data)
+	}()
+
+	conn, err := simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(50*time.Millisecond))).Dial("tcp", ln.Addr().String())
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	hc, ok := conn.(simnet.HalfCloser)
+	must.True(t, ok)
+
+	// Data still held by the simulated latency reaches the server before
+	// it sees EOF.
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	must.NoError(t, hc.CloseWrite())
+
+	_, err = conn.Write([]byte("late"))
+	must.ErrorIs(t, err, net.ErrClosed)
+
+	// The read side stays open.
+	reply, err := io.ReadAll(conn)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(reply))
+}
+
+func TestConnCopy(t *testing.T) {
+	const (
+		chunk   = 32 << 10 // Bytes copied at once
+		chunks  = 4
+		latency = 50 * time.Millisecond
+	)
+
+	var (
+		mu    sync.Mutex
+		drops []int
+	)
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(latency),
+		simnet.WithLossRate(1),
+		simnet.WithOnDrop(func(addr net.Addr, size int) {
+			mu.Lock()
+			defer mu.Unlock()
+			drops = append(drops, size)
+		}),
+	)
+
+	a, b := simnet.Pipe(cfg)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), chunks*chunk/16)
+
+	// Hide the WriterTo of bytes.Reader, so that io.Copy uses ReadFrom.
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(a, struct{ io.Reader }{bytes.NewReader(data)})
+		a.Close()
+		copied <- err
+	}()
+
+	// io.Copy uses WriteTo on the reading end.
+	start := time.Now()
+	var got bytes.Buffer
+	n, err := io.Copy(&got, b)
+	must.NoError(t, err)
+	must.Eq(t, int64(len(data)), n)
+	must.Eq(t, data, got.Bytes())
+	must.GreaterEq(t, latency, time.Since(start))
+	must.NoError(t, <-copied)
+
+	// Conditions are applied to each chunk.
+	mu.Lock()
+	defer mu.Unlock()
+	must.Eq(t, []int{chunk, chunk, chunk, chunk}, drops)
+}
+
+func TestConnIsPartitioned(t *testing.T) {
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig()
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	pr, ok := conn.(simnet.PartitionReporter)
+	must.True(t, ok)
+	must.False(t, pr.IsPartitioned())
+	must.False(t, cfg.IsPartitioned(addr))
+
+	// Partitions changed at runtime are reported by the live connection.
+	cfg.AddPartition(addr)
+	must.True(t, pr.IsPartitioned())
+	must.True(t, cfg.IsPartitioned(addr))
+
+	cfg.RemovePartition(addr)
+	must.False(t, pr.IsPartitioned())
+	must.False(t, cfg.IsPartitioned(addr))
+
+	// Connected packet conns report their partition state too.
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	udp, err := simnet.DialUDP(cfg, nil, peer.LocalAddr().(*net.UDPAddr))
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		udp.Close()
+	})
+
+	pr, ok = udp.(simnet.PartitionReporter)
+	must.True(t, ok)
+	must.False(t, pr.IsPartitioned())
+	cfg.AddPartition("127.0.0.1")
+	must.True(t, pr.IsPartitioned())
+	cfg.HealPartition()
+	must.False(t, pr.IsPartitioned())
+}
+
+func TestConnDuplicateBuffers(t *testing.T) {
+	const (
+		writes = 100
+		size   = 1000
+	)
+
+	// Every write is delivered twice, each copy from the same buffer, while
+	// the writer reuses its own buffer for the next write.
+	a, b := simnet.Pipe(simnet.NewConfig(
+		simnet.WithDuplicateRate(1),
+		simnet.WithJitter(time.Millisecond),
+	))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	go func() {
+		buf := make([]byte, size)
+		for i := range writes {
+			for j := range buf {
+				buf[j] = byte(i)
+			}
+			if _, err := a.Write(buf); err != nil {
+				return
+			}
+		}
+		a.Close()
+	}()
+
+	got, err := io.ReadAll(b)
+	must.NoError(t, err)
+	must.Eq(t, 2*writes*size, len(got))
+
+	// A buffer reused while still referenced by a pending copy would show
+	// up as a copy holding the data of a later write.
+	for i := range writes {
+		for n := range 2 {
+			chunk := got[(2*i+n)*size:][:size]
+			must.True(t, bytes.Equal(bytes.Repeat([]byte{byte(i)}, size), chunk), must.Sprintf("write %d, copy %d", i, n))
+		}
+	}
+}
+
+func TestConnCorrupt(t *testing.T) {
+	const writes = 10
+
+	// Truncate every other write to its first byte.
+	var calls atomic.Int64
+	a, b := simnet.Pipe(simnet.NewConfig(simnet.WithCorruptFunc(func(data []byte) []byte {
+		if calls.Add(1)%2 == 0 {
+			return data[:1]
+		}
+		return data
+	})))
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	go func() {
+		for range writes {
+			a.Write([]byte("ping"))
+		}
+		a.Close()
+	}()
+
+	got, err := io.ReadAll(b)
+	must.NoError(t, err)
+	must.Eq(t, strings.Repeat("pingp", writes/2), string(got))
+}
+
+func TestConnBitErrorRate(t *testing.T) {
+	const (
+		size  = 1 << 20 // 1MB
+		chunk = 32 << 10
+		ber   = 1e-4
+	)
+
+	// received sends size zero bytes and returns what arrives.
+	received := func(t *testing.T) []byte {
+		a, b := simnet.Pipe(simnet.NewConfig(
+			simnet.WithBitErrorRate(ber),
+			simnet.WithSeed(42),
+		))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		go func() {
+			buf := make([]byte, chunk)
+			for sent := 0; sent < size; sent += chunk {
+				a.Write(buf)
+			}
+			a.Close()
+		}()
+
+		got, err := io.ReadAll(b)
+		must.NoError(t, err)
+		must.Eq(t, size, len(got))
+		return got
+	}
+
+	got := received(t)
+	var flipped int
+	for _, c := range got {
+		flipped += bits.OnesCount8(c)
+	}
+
+	// About 839 of the 8,388,608 bits are flipped, with a standard
+	// deviation of about 29.
+	must.Between(t, 750, flipped, 930)
+
+	// The same seed flips the same bits.
+	must.True(t, bytes.Equal(got, received(t)))
+}
+
+func TestWrapConn(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	c1, c2 := net.Pipe()
+	conn := simnet.WrapConn(c1, simnet.NewConfig(simnet.WithLatency(latency)))
+	t.Cleanup(func() {
+		conn.Close()
+		c2.Close()
+	})
+
+	// Conditions apply to data written on the wrapped end, and to data it
+	// reads from the other.
+	start := time.Now()
+	_, err := conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	_, err = io.ReadFull(c2, make([]byte, 4))
+	must.NoError(t, err)
+	must.Between(t, latency, time.Since(start), latency+500*time.Millisecond)
+
+	start = time.Now()
+	go c2.Write([]byte("pong"))
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	must.NoError(t, err)
+	must.Between(t, latency, time.Since(start), latency+500*time.Millisecond)
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, int64(4), stats.BytesSent)
+	must.Eq(t, int64(4), stats.BytesReceived)
+}
+
+func TestConnPassthrough(t *testing.T) {
+	cfg := simnet.Passthrough()
+
+	// Conditions set on a passthrough config have no effect on its conns.
+	cfg.SetLatency(time.Hour)
+	cfg.SetLossRate(1)
+
+	a, b := simnet.Pipe(cfg)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	go a.Write([]byte("ping"))
+
+	must.NoError(t, b.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(b, buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf))
+
+	stats := b.(simnet.StatsProvider).Stats()
+	must.Eq(t, 4, stats.BytesReceived)
+
+	must.NoError(t, a.Close())
+	_, err = a.Write([]byte("late"))
+	must.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func BenchmarkConnThroughput(b *testing.B) {
+	const (
+		size      = 10 << 20  // 10MB
+		chunk     = 32 << 10  // 32KB
+		bandwidth = 125 << 20 // About 1Gbps
+	)
+
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithBandwidth(bandwidth),
+	)
+
+	b.Run("write", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for range b.N {
+			a, peer := simnet.Pipe(cfg)
+			done := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, peer)
+				close(done)
+			}()
+
+			buf := make([]byte, chunk)
+			for sent := 0; sent < size; sent += chunk {
+				if _, err := a.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			a.Close()
+			<-done
+		}
+	})
+
+	b.Run("read", func(b *testing.B) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		must.NoError(b, err)
+		b.Cleanup(func() {
+			ln.Close()
+		})
+		go func() {
+			buf := make([]byte, chunk)
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				for sent := 0; sent < size; sent += chunk {
+					c.Write(buf)
+				}
+				c.Close()
+			}
+		}()
+
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for range b.N {
+			conn, err := simnet.NewDialer(cfg).Dial("tcp", ln.Addr().String())
+			must.NoError(b, err)
+
+			n, err := io.Copy(io.Discard, conn)
+			must.NoError(b, err)
+			must.Eq(b, size, n)
+			conn.Close()
+		}
+	})
+}
+
+func BenchmarkPassthrough(b *testing.B) {
+	const (
+		size  = 10 << 20 // 10MB
+		chunk = 32 << 10 // 32KB
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(b, err)
+	b.Cleanup(func() {
+		ln.Close()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	run := func(b *testing.B, dial func() (net.Conn, error)) {
+		b.SetBytes(size)
+		buf := make([]byte, chunk)
+		for range b.N {
+			conn, err := dial()
+			must.NoError(b, err)
+			for sent := 0; sent < size; sent += chunk {
+				if _, err := conn.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			conn.Close()
+		}
+	}
+
+	// The passthrough conn should perform close to the raw one.
+	b.Run("raw", func(b *testing.B) {
+		run(b, func() (net.Conn, error) {
+			return net.Dial("tcp", ln.Addr().String())
+		})
+	})
+
+	b.Run("passthrough", func(b *testing.B) {
+		dialer := simnet.NewDialer(simnet.Passthrough())
+		run(b, func() (net.Conn, error) {
+			return dialer.Dial("tcp", ln.Addr().String())
+		})
+	})
+
+	b.Run("simulated", func(b *testing.B) {
+		dialer := simnet.NewDialer(simnet.NewConfig())
+		run(b, func() (net.Conn, error) {
+			return dialer.Dial("tcp", ln.Addr().String())
+		})
+	})
+}
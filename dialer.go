@@ -3,8 +3,13 @@ package simnet
 import (
 	"context"
 	"errors"
-	"fmt"
+	"maps"
 	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/proxy"
 )
 
 var (
@@ -15,41 +20,205 @@ var (
 	ErrDialFailed = errors.New("simnet: dial failed")
 )
 
-// Dialer is a net.Dialer that simulates network conditions.
+// Dialer is a net.Dialer that simulates network conditions. It keeps track of
+// the connections it dials until they are closed, so that they can all be
+// closed at once with CloseAll.
 type Dialer struct {
-	dialer net.Dialer // Underlying dialer (can be customized)
+	dialer net.Dialer // Underlying dialer (see NewDialerWith)
 	config *Config    // Network simulation configuration
+	source string     // Address of the dialing node (optional)
+
+	// ProxyDialer dials connections through a proxy, such as one returned
+	// by proxy.SOCKS5, instead of the underlying dialer (optional). Network
+	// conditions are applied to the connection it returns, whose remote
+	// address is usually that of the proxy, so conditions for specific
+	// addresses should name the proxy. Partitions are still checked against
+	// the dialed address.
+	ProxyDialer proxy.ContextDialer
+
+	// route returns the network simulation configuration for dialing an
+	// address, or false if it is unreachable, overriding config (optional).
+	route func(address string) (*Config, bool)
+
+	mu    sync.Mutex
+	conns map[*simulatedConn]struct{} // Connections dialed and not yet closed
 }
 
-// NewDialer creates a new simulated Dialer with the given configuration.
+// NewDialer creates a new simulated Dialer with the given configuration. A
+// nil cfg applies no conditions.
 func NewDialer(cfg *Config) *Dialer {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
 	return &Dialer{
 		config: cfg,
 	}
 }
 
-// DialContext simulates dialing a network connection.
+// NewDialerWith creates a new simulated Dialer with the given configuration,
+// dialing with base, so that its Timeout, KeepAlive, LocalAddr, Control, and
+// other settings apply to the underlying connections. A nil cfg applies no
+// conditions.
+func NewDialerWith(cfg *Config, base net.Dialer) *Dialer {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	return &Dialer{
+		dialer: base,
+		config: cfg,
+	}
+}
+
+// NewDialerFrom creates a new simulated Dialer for the node at the source
+// address, so that partitions between groups of nodes created with
+// Config.PartitionGroups apply to its dials. A nil cfg applies no
+// conditions.
+func NewDialerFrom(cfg *Config, source string) *Dialer {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	return &Dialer{
+		config: cfg,
+		source: source,
+	}
+}
+
+// DialContext simulates dialing a network connection. The context bounds
+// the dial only: as with net.Dialer, once the connection is established,
+// ctx ending does not affect it or the simulated delays on it, so that
+// connections kept by a pool after the request that dialed them, as by
+// net/http, keep working. Closing the connection abandons any simulated
+// delays in progress.
 func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	if d.config.isPartitioned(address) {
-		return nil, fmt.Errorf("%w: unable to reach address: %s", ErrNetworkPartitioned, address)
+	return d.DialWithConfig(ctx, network, address, nil)
+}
+
+// DialWithConfig dials like DialContext, applying the fields of override
+// that are not the zero value on top of the dialer's configuration, for just
+// this connection. This allows one connection to be slower than the others
+// dialed by the same Dialer, for example by overriding Bandwidth. The merged
+// configuration is a snapshot, so later changes to either config do not
+// affect the connection. Partitions are checked against the dialer's
+// configuration, as for any other dial. A nil override is ignored.
+func (d *Dialer) DialWithConfig(ctx context.Context, network, address string, override *Config) (net.Conn, error) {
+	var sources []string
+	if d.source != "" {
+		sources = []string{d.source}
+	}
+	cfg := d.config
+	if d.route != nil {
+		var ok bool
+		if cfg, ok = d.route(address); !ok {
+			return nil, d.partitioned(ctx, d.config, network, address)
+		}
+	}
+	if cfg.isPartitionedFrom(sources, address) {
+		return nil, d.partitioned(ctx, cfg, network, address)
+	}
+
+	if override != nil {
+		cfg = cfg.merge(override)
+	}
+
+	latency, fail := cfg.connect()
+	if latency > 0 {
+		select {
+		case <-cfg.clock().After(latency):
+		case <-ctx.Done():
+			return nil, dialError(ctx.Err())
+		}
+	}
+	if fail {
+		return nil, refusedError(network, address)
 	}
 
-	conn, err := d.dialer.DialContext(ctx, network, address)
+	var conn net.Conn
+	var err error
+	if d.ProxyDialer != nil {
+		conn, err = d.ProxyDialer.DialContext(ctx, network, address)
+	} else {
+		conn, err = d.dialer.DialContext(ctx, network, address)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrDialFailed, err)
+		return nil, dialError(err)
+	}
+	sc := wrapConn(conn, cfg)
+	sc.sources = sources
+	d.track(sc)
+	return sc, nil
+}
+
+// partitioned returns the error for a dial to a partitioned address, first
+// waiting for the config's PartitionTimeout, if set, as for a dial to a host
+// that never answers. Dialers routing through a topology may have no config.
+func (d *Dialer) partitioned(ctx context.Context, cfg *Config, network, address string) error {
+	if cfg == nil {
+		return partitionedError(address)
+	}
+	cfg.mu.Lock()
+	timeout := cfg.PartitionTimeout
+	cfg.mu.Unlock()
+	if timeout <= 0 {
+		return partitionedError(address)
+	}
+	if d.dialer.Timeout > 0 {
+		timeout = min(timeout, d.dialer.Timeout)
+	}
+
+	select {
+	case <-cfg.clock().After(timeout):
+		return partitionTimeoutError(network, address)
+	case <-ctx.Done():
+		return dialError(ctx.Err())
+	}
+}
+
+// track records a dialed connection until it is closed.
+func (d *Dialer) track(sc *simulatedConn) {
+	sc.onClose = func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.conns, sc)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conns == nil {
+		d.conns = make(map[*simulatedConn]struct{})
+	}
+	d.conns[sc] = struct{}{}
+}
+
+// CloseAll closes every connection dialed by the dialer that has not yet been
+// closed, as if the network cable were pulled. Reads and writes on the
+// connections fail afterwards. The dialer can still dial new connections.
+func (d *Dialer) CloseAll() error {
+	d.mu.Lock()
+	conns := slices.Collect(maps.Keys(d.conns))
+	d.mu.Unlock()
+
+	var errs []error
+	for _, sc := range conns {
+		if err := sc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GRPCDialer returns a function for grpc.WithContextDialer that dials with
+// simulated network conditions. Addresses are dialed over TCP, except for
+// addresses with a "unix:" prefix, which are dialed as Unix domain sockets.
+func (d *Dialer) GRPCDialer() func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		if path, ok := strings.CutPrefix(address, "unix:"); ok {
+			return d.DialContext(ctx, "unix", path)
+		}
+		return d.DialContext(ctx, "tcp", address)
 	}
-	return wrapConn(conn, d.config), nil
 }
 
 // Dial simulates dialing without context.
 func (d *Dialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
-
-// Helper method to check if an address is partitioned.
-func (cfg *Config) isPartitioned(address string) bool {
-	cfg.mu.Lock()
-	defer cfg.mu.Unlock()
-	_, partitioned := cfg.PartitionedAddrs[address]
-	return partitioned
-}
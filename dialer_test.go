@@ -0,0 +1,454 @@
+package simnet_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestDialerContext(t *testing.T) {
+	// The context only bounds the dial, so ending it afterwards does not
+	// affect the connection, even while data is held by simulated latency.
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig(simnet.WithLatency(50 * time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := simnet.NewDialer(cfg).DialContext(ctx, "tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	cancel()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf))
+}
+
+func TestDialerCloseAll(t *testing.T) {
+	const conns = 4
+
+	addr := startEchoServer(t)
+	dialer := simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(time.Millisecond)))
+
+	// Reads block waiting for data until the connections are closed.
+	errs := make(chan error, conns)
+	for range conns {
+		conn, err := dialer.Dial("tcp", addr)
+		must.NoError(t, err)
+		go func() {
+			_, err := conn.Read(make([]byte, 4))
+			errs <- err
+		}()
+	}
+
+	// Connections closed before CloseAll are no longer tracked.
+	closed, err := dialer.Dial("tcp", addr)
+	must.NoError(t, err)
+	must.NoError(t, closed.Close())
+
+	must.NoError(t, dialer.CloseAll())
+	for range conns {
+		must.ErrorIs(t, <-errs, net.ErrClosed)
+	}
+	must.NoError(t, dialer.CloseAll())
+
+	// The dialer still dials new connections.
+	conn, err := dialer.Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf))
+}
+
+func TestDialerConnect(t *testing.T) {
+	t.Run("latency", func(t *testing.T) {
+		const latency = 100 * time.Millisecond
+
+		addr := startEchoServer(t)
+		dialer := simnet.NewDialer(simnet.NewConfig(simnet.WithConnectLatency(latency)))
+
+		start := time.Now()
+		conn, err := dialer.Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		must.GreaterEq(t, latency, time.Since(start))
+
+		// The connect latency does not apply to data.
+		start = time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		must.NoError(t, err)
+		must.Less(t, latency, time.Since(start))
+	})
+
+	t.Run("context expires", func(t *testing.T) {
+		addr := startEchoServer(t)
+		dialer := simnet.NewDialer(simnet.NewConfig(simnet.WithConnectLatency(5 * time.Second)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+
+		start := time.Now()
+		_, err := dialer.DialContext(ctx, "tcp", addr)
+		must.ErrorIs(t, err, context.DeadlineExceeded)
+		must.Less(t, time.Second, time.Since(start))
+	})
+
+	t.Run("failure rate", func(t *testing.T) {
+		const attempts = 500
+
+		addr := startEchoServer(t)
+
+		// failures dials repeatedly, returning which attempts failed.
+		failures := func() []int {
+			dialer := simnet.NewDialer(simnet.NewConfig(
+				simnet.WithConnectFailureRate(0.2),
+				simnet.WithSeed(42),
+			))
+			var failed []int
+			for i := range attempts {
+				conn, err := dialer.Dial("tcp", addr)
+				if err != nil {
+					must.ErrorIs(t, err, simnet.ErrDialFailed)
+					must.ErrorIs(t, err, syscall.ECONNREFUSED)
+					failed = append(failed, i)
+					continue
+				}
+				conn.Close()
+			}
+			return failed
+		}
+
+		failed := failures()
+		must.Between(t, 0.15, float64(len(failed))/attempts, 0.25)
+		must.Eq(t, failed, failures())
+	})
+}
+
+func TestDialerWithConfig(t *testing.T) {
+	const (
+		size      = 40_000
+		bandwidth = 400_000 // 400KBps
+		override  = 100_000 // 100KBps
+	)
+
+	addr := startEchoServer(t)
+	dialer := simnet.NewDialer(simnet.NewConfig(
+		simnet.WithBandwidth(bandwidth),
+		simnet.WithBurst(1000),
+	))
+
+	// echoTime returns how long it takes to echo size bytes on conn.
+	echoTime := func(t *testing.T, conn net.Conn) time.Duration {
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		go conn.Write(make([]byte, size))
+		_, err := io.ReadFull(conn, make([]byte, size))
+		must.NoError(t, err)
+		return time.Since(start)
+	}
+
+	fast, err := dialer.DialWithConfig(context.Background(), "tcp", addr, nil)
+	must.NoError(t, err)
+
+	// The override narrows the bandwidth for just its connection, keeping
+	// the burst of the dialer's config.
+	slow, err := dialer.DialWithConfig(context.Background(), "tcp", addr, simnet.NewConfig(simnet.WithBandwidth(override)))
+	must.NoError(t, err)
+
+	// Each way takes about 0.1s at the dialer's bandwidth, and 0.4s at the
+	// override's.
+	must.Between(t, 100*time.Millisecond, echoTime(t, fast), 300*time.Millisecond)
+	must.Between(t, 400*time.Millisecond, echoTime(t, slow), 1200*time.Millisecond)
+}
+
+// startSOCKS5Server starts a minimal SOCKS5 proxy without authentication,
+// returning its address and a count of the connections it proxied.
+func startSOCKS5Server(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	var proxied atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+
+				// Greeting: version, methods, and the methods offered.
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 0})
+
+				// Request: version, CONNECT, reserved, and an IPv4 address.
+				if _, err := io.ReadFull(c, buf[:10]); err != nil || buf[3] != 1 {
+					return
+				}
+				target := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(buf[8])<<8|int(buf[9])))
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				proxied.Add(1)
+
+				go io.Copy(upstream, c)
+				io.Copy(c, upstream)
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String(), &proxied
+}
+
+func TestNewDialerWith(t *testing.T) {
+	addr := startEchoServer(t)
+
+	var controlled atomic.Bool
+	d := simnet.NewDialerWith(simnet.NewConfig(), net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)},
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled.Store(true)
+			return nil
+		},
+	})
+
+	conn, err := d.Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// The underlying dialer's settings apply to the connection.
+	must.True(t, controlled.Load())
+	must.Eq(t, "127.0.0.2", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	must.NoError(t, err)
+}
+
+func TestDialerProxy(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	addr := startEchoServer(t)
+	proxyAddr, proxied := startSOCKS5Server(t)
+
+	socks, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+	must.NoError(t, err)
+
+	dialer := simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(latency)))
+	dialer.ProxyDialer = socks.(proxy.ContextDialer)
+
+	conn, err := dialer.Dial("tcp", addr)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	must.Eq(t, 1, proxied.Load())
+
+	// The echo through the proxy is delayed both ways.
+	start := time.Now()
+	_, err = conn.Write([]byte("ping"))
+	must.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf))
+	must.GreaterEq(t, 2*latency, time.Since(start))
+}
+
+func TestDialerPartitionGroups(t *testing.T) {
+	nodes := make([]string, 4)
+	for i := range nodes {
+		nodes[i] = startEchoServer(t)
+	}
+	groupA, groupB := nodes[:2], nodes[2:]
+
+	cfg := simnet.NewConfig()
+	cfg.PartitionGroups(groupA, groupB)
+
+	dial := func(from, to string) error {
+		conn, err := simnet.NewDialerFrom(cfg, from).Dial("tcp", to)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// Dials within a group succeed, while dials across groups fail.
+	must.NoError(t, dial(groupA[0], groupA[1]))
+	must.NoError(t, dial(groupB[1], groupB[0]))
+	must.ErrorIs(t, dial(groupA[0], groupB[0]), simnet.ErrNetworkPartitioned)
+	must.ErrorIs(t, dial(groupA[1], groupB[1]), simnet.ErrNetworkPartitioned)
+	must.ErrorIs(t, dial(groupB[0], groupA[0]), simnet.ErrNetworkPartitioned)
+	must.ErrorIs(t, dial(groupB[1], groupA[1]), simnet.ErrNetworkPartitioned)
+
+	// A dialer without a source is unaffected by group partitions.
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", groupB[0])
+	must.NoError(t, err)
+	must.NoError(t, conn.Close())
+
+	// Healing reconnects everything.
+	cfg.HealPartition()
+	for _, from := range nodes {
+		for _, to := range nodes {
+			must.NoError(t, dial(from, to))
+		}
+	}
+}
+
+func TestDialerFlappingPartition(t *testing.T) {
+	const (
+		down = 2 * time.Second
+		up   = time.Second
+	)
+
+	addr := startEchoServer(t)
+
+	clock := simnet.NewFakeClock()
+	cfg := simnet.NewConfig(simnet.WithClock(clock))
+	cfg.AddFlappingPartition(addr, down, up)
+
+	dial := func() error {
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// Dials fail while the partition is down and succeed while it is up,
+	// repeating on the schedule.
+	for range 3 {
+		must.ErrorIs(t, dial(), simnet.ErrNetworkPartitioned)
+		clock.Advance(down - 100*time.Millisecond)
+		must.ErrorIs(t, dial(), simnet.ErrNetworkPartitioned)
+		clock.Advance(100 * time.Millisecond)
+		must.NoError(t, dial())
+		clock.Advance(up - 100*time.Millisecond)
+		must.NoError(t, dial())
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	// Removing the partition ends the schedule.
+	cfg.RemovePartition(addr)
+	must.NoError(t, dial())
+}
+
+func TestDialerPartitionTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	cfg := simnet.NewConfig(simnet.WithPartitionTimeout(timeout))
+	cfg.AddPartition("127.0.0.1")
+
+	t.Run("times out", func(t *testing.T) {
+		// The dial blocks as if the host never answered, then fails with
+		// a timeout.
+		start := time.Now()
+		_, err := simnet.NewDialer(cfg).Dial("tcp", "127.0.0.1:9")
+		must.Between(t, timeout, time.Since(start), timeout+time.Second)
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+		var netErr net.Error
+		must.True(t, errors.As(err, &netErr))
+		must.True(t, netErr.Timeout())
+	})
+
+	t.Run("context done first", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := simnet.NewDialer(cfg).DialContext(ctx, "tcp", "127.0.0.1:9")
+		must.Less(t, timeout, time.Since(start))
+		must.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("dialer timeout first", func(t *testing.T) {
+		d := simnet.NewDialerWith(cfg, net.Dialer{Timeout: 10 * time.Millisecond})
+
+		start := time.Now()
+		_, err := d.Dial("tcp", "127.0.0.1:9")
+		must.Less(t, timeout, time.Since(start))
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+	})
+}
+
+func TestDialerGRPC(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	d := simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(latency)))
+	client, err := grpc.NewClient("passthrough:///"+ln.Addr().String(),
+		grpc.WithContextDialer(d.GRPCDialer()),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	// The request and the response are both delayed.
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(client).Check(ctx, &healthpb.HealthCheckRequest{})
+	must.NoError(t, err)
+	must.Eq(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	must.GreaterEq(t, 2*latency, time.Since(start))
+}
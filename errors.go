@@ -0,0 +1,68 @@
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Error is a simulated network failure. It implements net.Error, so that code
+// checking for timeouts handles it as it would a real network error, and
+// wraps the error describing the failure, so that errors.Is matches sentinel
+// errors such as ErrNetworkPartitioned.
+//
+// Partitions are permanent failures rather than timeouts, except for dials
+// timing out with Config.PartitionTimeout. Reads that pass their deadline
+// fail with os.ErrDeadlineExceeded, which is a timeout.
+type Error struct {
+	Err error // Error describing the failure
+}
+
+// Error returns the message of the wrapped error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the failure is a timeout, as when a dial fails
+// because its deadline passed.
+func (e *Error) Timeout() bool {
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// Temporary reports whether the failure is temporary, which only timeouts
+// are.
+//
+// Deprecated: Temporary errors are not well-defined, as for net.Error.
+func (e *Error) Temporary() bool {
+	return e.Timeout()
+}
+
+// partitionedError returns the error for an address that cannot be reached
+// because of a partition.
+func partitionedError(addr string) error {
+	return &Error{Err: fmt.Errorf("%w: unable to reach address: %s", ErrNetworkPartitioned, addr)}
+}
+
+// partitionTimeoutError returns the error for a dial to addr that timed out
+// because of a partition.
+func partitionTimeoutError(network, addr string) error {
+	return &Error{Err: fmt.Errorf("%w: dial %s %s: %w", ErrNetworkPartitioned, network, addr, os.ErrDeadlineExceeded)}
+}
+
+// refusedError returns the error for a simulated connection failure to addr.
+func refusedError(network, addr string) error {
+	return dialError(fmt.Errorf("dial %s %s: %w", network, addr, syscall.ECONNREFUSED))
+}
+
+// dialError returns the error for a dial that failed with err.
+func dialError(err error) error {
+	return &Error{Err: fmt.Errorf("%w: %w", ErrDialFailed, err)}
+}
@@ -0,0 +1,76 @@
+package simnet_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestError(t *testing.T) {
+	// netError asserts that err is a net.Error, returning it.
+	netError := func(t *testing.T, err error) net.Error {
+		t.Helper()
+		netErr, ok := err.(net.Error)
+		must.True(t, ok, must.Sprintf("%T is not a net.Error", err))
+		return netErr
+	}
+
+	t.Run("partitioned dial", func(t *testing.T) {
+		cfg := simnet.NewConfig()
+		cfg.AddPartition("127.0.0.1")
+
+		_, err := simnet.NewDialer(cfg).Dial("tcp", "127.0.0.1:1")
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+
+		// Partitions are permanent failures.
+		netErr := netError(t, err)
+		must.False(t, netErr.Timeout())
+		must.False(t, netErr.Temporary())
+	})
+
+	t.Run("partitioned write", func(t *testing.T) {
+		cfg := simnet.NewConfig()
+		cfg.AddPartition("127.0.0.2")
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1})
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+		must.False(t, netError(t, err).Timeout())
+	})
+
+	t.Run("failed dial", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		must.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		// The cause of the failure is kept.
+		_, err = simnet.NewDialer(simnet.NewConfig()).Dial("tcp", addr)
+		must.ErrorIs(t, err, simnet.ErrDialFailed)
+		must.ErrorIs(t, err, syscall.ECONNREFUSED)
+		must.False(t, netError(t, err).Timeout())
+
+		var simErr *simnet.Error
+		must.True(t, errors.As(err, &simErr))
+	})
+
+	t.Run("timed out dial", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		t.Cleanup(cancel)
+
+		_, err := simnet.NewDialer(simnet.NewConfig()).DialContext(ctx, "tcp", "127.0.0.1:1")
+		must.ErrorIs(t, err, simnet.ErrDialFailed)
+		must.True(t, netError(t, err).Timeout())
+	})
+}
@@ -2,6 +2,16 @@ module github.com/picatz/simnet
 
 go 1.23.1
 
-require github.com/shoenig/test v1.11.0
+require (
+	github.com/shoenig/test v1.11.0
+	golang.org/x/net v0.29.0
+	google.golang.org/grpc v1.68.0
+)
 
-require github.com/google/go-cmp v0.6.0 // indirect
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
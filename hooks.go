@@ -0,0 +1,111 @@
+package simnet
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// onDrop reports a lost packet to the OnDrop callback, the logger, and the
+// trace writer, if set, without holding cfg.mu. A packet written with a tag
+// is also reported to the OnDropTagged callback.
+func (cfg *Config) onDrop(addr net.Addr, size int, tag string) {
+	cfg.mu.Lock()
+	fn, tagged, logger, tracer := cfg.OnDrop, cfg.OnDropTagged, cfg.Logger, cfg.tracerLocked()
+	cfg.mu.Unlock()
+
+	tracer.decision("drop", addr, size, 0)
+
+	if logger != nil {
+		attrs := []slog.Attr{addrAttr(addr), slog.Int("size", size)}
+		if tag != "" {
+			attrs = append(attrs, slog.String("tag", tag))
+		}
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "simnet: packet dropped", attrs...)
+	}
+	if fn != nil {
+		fn(addr, size)
+	}
+	if tagged != nil && tag != "" {
+		tagged(addr, size, tag)
+	}
+}
+
+// onDuplicate reports a duplicated packet to the OnDuplicate callback, the
+// logger, and the trace writer, if set, without holding cfg.mu.
+func (cfg *Config) onDuplicate(addr net.Addr, size int) {
+	cfg.mu.Lock()
+	fn, logger, tracer := cfg.OnDuplicate, cfg.Logger, cfg.tracerLocked()
+	cfg.mu.Unlock()
+
+	tracer.decision("duplicate", addr, size, 0)
+
+	if logger != nil {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "simnet: packet duplicated",
+			addrAttr(addr), slog.Int("size", size))
+	}
+	if fn != nil {
+		fn(addr, size)
+	}
+}
+
+// onReorder reports a reordered packet to the OnReorder callback, the
+// logger, and the trace writer, if set, without holding cfg.mu.
+func (cfg *Config) onReorder(addr net.Addr, size int) {
+	cfg.mu.Lock()
+	fn, logger, tracer := cfg.OnReorder, cfg.Logger, cfg.tracerLocked()
+	cfg.mu.Unlock()
+
+	tracer.decision("reorder", addr, size, 0)
+
+	if logger != nil {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "simnet: packet reordered",
+			addrAttr(addr), slog.Int("size", size))
+	}
+	if fn != nil {
+		fn(addr, size)
+	}
+}
+
+// onDelay reports a delayed packet to the OnDelay callback, the logger, and
+// the trace writer, if set, without holding cfg.mu.
+func (cfg *Config) onDelay(addr net.Addr, size int, d time.Duration) {
+	cfg.mu.Lock()
+	fn, logger, tracer := cfg.OnDelay, cfg.Logger, cfg.tracerLocked()
+	cfg.mu.Unlock()
+
+	tracer.decision("delay", addr, size, d)
+
+	if logger != nil {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "simnet: packet delayed",
+			addrAttr(addr), slog.Int("size", size), slog.Duration("latency", d))
+	}
+	if fn != nil {
+		fn(addr, size, d)
+	}
+}
+
+// onSendError reports data the underlying connection failed to send to the
+// OnSendError callback and the logger, if set, without holding cfg.mu.
+func (cfg *Config) onSendError(addr net.Addr, size int, err error) {
+	cfg.mu.Lock()
+	fn, logger := cfg.OnSendError, cfg.Logger
+	cfg.mu.Unlock()
+
+	if logger != nil {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "simnet: send failed",
+			addrAttr(addr), slog.Int("size", size), slog.Any("error", err))
+	}
+	if fn != nil {
+		fn(addr, size, err)
+	}
+}
+
+// addrAttr returns a log attribute for the address of a packet.
+func addrAttr(addr net.Addr) slog.Attr {
+	if addr == nil {
+		return slog.String("addr", "")
+	}
+	return slog.String("addr", addr.String())
+}
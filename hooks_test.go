@@ -0,0 +1,223 @@
+package simnet_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+)
+
+func TestCallbacks(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	var (
+		mu     sync.Mutex
+		events = make(map[string]int)
+		addrs  = make(map[string]bool)
+	)
+	record := func(event string, addr net.Addr) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[event]++
+		addrs[addr.String()] = true
+	}
+
+	var cfg *simnet.Config
+	cfg = simnet.NewConfig(
+		simnet.WithLossRate(0.1),
+		simnet.WithDuplicateRate(0.1),
+		simnet.WithReorderRate(0.1),
+		simnet.WithSeed(42),
+		simnet.WithOnDrop(func(addr net.Addr, size int) {
+			record("drop", addr)
+		}),
+		simnet.WithOnDuplicate(func(addr net.Addr, size int) {
+			record("duplicate", addr)
+		}),
+		simnet.WithOnReorder(func(addr net.Addr, size int) {
+			record("reorder", addr)
+		}),
+		simnet.WithOnDelay(func(addr net.Addr, size int, d time.Duration) {
+			// Callbacks may use the config without deadlocking.
+			cfg.SetJitter(0)
+			record("delay", addr)
+		}),
+	)
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for range 200 {
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Positive(t, stats.PacketsDropped)
+	must.Positive(t, stats.PacketsDuplicated)
+	must.Positive(t, stats.PacketsReordered)
+
+	// Every delivered packet, including duplicates, is delayed once.
+	delivered := int(stats.PacketsSent - stats.PacketsDropped + stats.PacketsDuplicated)
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return events["delay"] == delivered
+		}),
+		wait.Timeout(time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	mu.Lock()
+	defer mu.Unlock()
+	must.Eq(t, int(stats.PacketsDropped), events["drop"])
+	must.Eq(t, int(stats.PacketsDuplicated), events["duplicate"])
+	must.Eq(t, int(stats.PacketsReordered), events["reorder"])
+	must.MapEq(t, map[string]bool{peer.LocalAddr().String(): true}, addrs)
+}
+
+func TestLogger(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithLossRate(0.2),
+		simnet.WithSeed(42),
+		simnet.WithLogger(logger),
+	)
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for range 50 {
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Positive(t, stats.PacketsDropped)
+
+	logs := buf.String()
+	must.Eq(t, int(stats.PacketsDropped), strings.Count(logs, "simnet: packet dropped"))
+	must.Eq(t, int(stats.PacketsSent-stats.PacketsDropped), strings.Count(logs, "simnet: packet delayed"))
+	must.StrContains(t, logs, "addr="+peer.LocalAddr().String())
+	must.StrContains(t, logs, "size=4")
+	must.StrContains(t, logs, "latency=1ms")
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestOnDropTagged(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// dialers return a connection using the config, for each kind of
+	// connection implementing TaggedWriter.
+	dialers := map[string]func(t *testing.T, cfg *simnet.Config) net.Conn{
+		"stream": func(t *testing.T, cfg *simnet.Config) net.Conn {
+			a, b := simnet.Pipe(cfg)
+			t.Cleanup(func() {
+				a.Close()
+				b.Close()
+			})
+			go io.Copy(io.Discard, b)
+			return a
+		},
+		"packet": func(t *testing.T, cfg *simnet.Config) net.Conn {
+			conn, err := simnet.DialUDP(cfg, nil, peer.LocalAddr().(*net.UDPAddr))
+			must.NoError(t, err)
+			t.Cleanup(func() {
+				conn.Close()
+			})
+			return conn
+		},
+	}
+
+	for name, dial := range dialers {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				tagged []string
+			)
+			cfg := simnet.NewConfig(
+				simnet.WithLossRate(0.5),
+				simnet.WithSeed(42),
+				simnet.WithOnDropTagged(func(addr net.Addr, size int, tag string) {
+					mu.Lock()
+					defer mu.Unlock()
+					tagged = append(tagged, tag)
+				}),
+			)
+			conn := dial(t, cfg)
+
+			// Untagged writes are not reported.
+			for range 10 {
+				_, err := conn.Write([]byte("untagged"))
+				must.NoError(t, err)
+			}
+			must.SliceEmpty(t, tagged)
+
+			var dropped []string
+			for i := range 10 {
+				tag := fmt.Sprintf("message-%d", i)
+				before := conn.(simnet.StatsProvider).Stats().PacketsDropped
+				_, err := conn.(simnet.TaggedWriter).WriteTagged([]byte("message"), tag)
+				must.NoError(t, err)
+				if conn.(simnet.StatsProvider).Stats().PacketsDropped > before {
+					dropped = append(dropped, tag)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			must.SliceNotEmpty(t, dropped)
+			must.Eq(t, dropped, tagged)
+		})
+	}
+}
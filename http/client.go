@@ -14,9 +14,16 @@ func NewClient(cfg *simnet.Config) *http.Client {
 	}
 }
 
+// WrapClient makes client send its requests over simulated connections,
+// keeping the settings of its transport, which must be an *http.Transport. A
+// client with a nil Transport wraps http.DefaultTransport, as it would use.
 func WrapClient(client *http.Client, cfg *simnet.Config) {
+	underlying := client.Transport
+	if underlying == nil {
+		underlying = http.DefaultTransport
+	}
 	client.Transport = &Transport{
-		Underlying: client.Transport.(*http.Transport),
+		Underlying: underlying.(*http.Transport),
 		Dialer:     simnet.NewDialer(cfg),
 	}
 }
@@ -1,6 +1,7 @@
 package httptest
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 
@@ -8,25 +9,55 @@ import (
 )
 
 // Server is a simulated HTTP server that applies network conditions.
+//
+// Conditions apply to each connection the server accepts, which makes its
+// random decisions independently of the others, as described by
+// simnet.NewListener.
 type Server struct {
 	srv *httptest.Server
+
+	responseThrottle int64 // Rate handlers write responses at, in bytes per second
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithResponseThrottle limits the rate at which handlers write response
+// bodies to bytesPerSec, modeling a slow server application rather than a
+// slow network. It applies in addition to the network conditions of the
+// config. Zero disables throttling.
+func WithResponseThrottle(bytesPerSec int64) ServerOption {
+	return func(s *Server) {
+		s.responseThrottle = bytesPerSec
+	}
 }
 
 // NewServer starts and returns a new simulated HTTP server.
-func NewServer(cfg *simnet.Config, handler http.Handler) *Server {
-	s := &Server{}
-	s.srv = httptest.NewUnstartedServer(handler)
-	s.wrapListener(cfg)
+func NewServer(cfg *simnet.Config, handler http.Handler, opts ...ServerOption) *Server {
+	s := newServer(cfg, handler, opts)
 	s.srv.Start()
 	return s
 }
 
 // NewTLSServer starts and returns a new simulated HTTPS server using TLS.
-func NewTLSServer(cfg *simnet.Config, handler http.Handler) *Server {
+func NewTLSServer(cfg *simnet.Config, handler http.Handler, opts ...ServerOption) *Server {
+	s := newServer(cfg, handler, opts)
+	s.srv.StartTLS()
+	return s
+}
+
+// newServer returns a new unstarted simulated server with the given options
+// applied.
+func newServer(cfg *simnet.Config, handler http.Handler, opts []ServerOption) *Server {
 	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.responseThrottle > 0 {
+		handler = throttleHandler(handler, s.responseThrottle)
+	}
 	s.srv = httptest.NewUnstartedServer(handler)
 	s.wrapListener(cfg)
-	s.srv.StartTLS()
 	return s
 }
 
@@ -50,3 +81,14 @@ func (s *Server) Client() *http.Client {
 func (s *Server) URL() string {
 	return s.srv.URL
 }
+
+// Addr returns the network address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.srv.Listener.Addr()
+}
+
+// Listener returns the simulated listener the server accepts connections
+// from.
+func (s *Server) Listener() net.Listener {
+	return s.srv.Listener
+}
@@ -1,11 +1,22 @@
 package httptest_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
 
 	"github.com/picatz/simnet"
+	simhttp "github.com/picatz/simnet/http"
 	"github.com/picatz/simnet/http/httptest"
+	"github.com/shoenig/test/must"
 )
 
 func ExampleServer() {
@@ -42,3 +53,142 @@ func ExampleServer() {
 	// Output:
 	// Response status: 200 OK
 }
+
+func TestServerAddr(t *testing.T) {
+	server := httptest.NewServer(simnet.NewConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, simnet!")
+	}))
+	t.Cleanup(server.Close)
+
+	must.Eq(t, server.Listener().Addr(), server.Addr())
+
+	cfg := simnet.NewConfig()
+	client := simhttp.NewClient(cfg)
+	t.Cleanup(client.CloseIdleConnections)
+
+	resp, err := client.Get(server.URL())
+	must.NoError(t, err)
+	must.NoError(t, resp.Body.Close())
+
+	// Partitioning the server's address makes it unreachable to new
+	// connections, which a new client must open.
+	cfg.AddPartition(server.Addr().String())
+	_, err = simhttp.NewClient(cfg).Get(server.URL())
+	must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+}
+
+func TestServerNilConfig(t *testing.T) {
+	server := httptest.NewServer(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+
+	client := simhttp.NewClient(nil)
+	t.Cleanup(client.CloseIdleConnections)
+
+	resp, err := client.Get(server.URL())
+	must.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	must.NoError(t, err)
+	must.NoError(t, resp.Body.Close())
+	must.Eq(t, "ok", string(body))
+}
+
+func TestServerResponseThrottle(t *testing.T) {
+	const (
+		size = 32 * 1024
+		rate = 128 * 1024
+	)
+
+	body := bytes.Repeat([]byte("x"), size)
+	server := httptest.NewServer(simnet.NewConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}), httptest.WithResponseThrottle(rate))
+	t.Cleanup(server.Close)
+
+	start := time.Now()
+	resp, err := server.Client().Get(server.URL())
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+
+	// The body arrives bit by bit rather than all at once.
+	first := make([]byte, size)
+	n, err := resp.Body.Read(first)
+	must.NoError(t, err)
+	must.Less(t, size, n)
+
+	rest, err := io.ReadAll(resp.Body)
+	must.NoError(t, err)
+	must.Eq(t, body, append(first[:n], rest...))
+
+	// 32 KiB at 128 KiB/s takes a quarter of a second.
+	elapsed := time.Since(start)
+	must.GreaterEq(t, 250*time.Millisecond, elapsed)
+	must.Less(t, 2*time.Second, elapsed)
+}
+
+func TestServerConcurrentClients(t *testing.T) {
+	const (
+		clients  = 8
+		requests = 4
+	)
+
+	// drops serves requests from concurrent clients, each on its own
+	// connection, returning the number of responses the server lost on each
+	// connection that lost any, in ascending order.
+	drops := func() []int {
+		var mu sync.Mutex
+		dropped := make(map[string]int)
+		cfg := simnet.NewConfig(
+			simnet.WithOutbound(simnet.DirectionConfig{LossRate: 0.2}),
+			simnet.WithSeed(42),
+			simnet.WithOnDrop(func(addr net.Addr, size int) {
+				mu.Lock()
+				defer mu.Unlock()
+				dropped[addr.String()]++
+			}),
+		)
+		server := httptest.NewServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "Hello, simnet!")
+		}))
+		defer server.Close()
+
+		var wg sync.WaitGroup
+		for range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client := &http.Client{Transport: &http.Transport{}}
+				defer client.CloseIdleConnections()
+				for range requests {
+					// Pausing for a random time varies how requests
+					// from different clients interleave.
+					time.Sleep(rand.N(10 * time.Millisecond))
+					resp, err := client.Get(server.URL())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Sorted(maps.Values(dropped))
+	}
+
+	// Each connection loses the same number of responses however the
+	// clients' requests interleave, so the losses are the same from run to
+	// run.
+	want := drops()
+	must.SliceNotEmpty(t, want)
+	for range 3 {
+		must.Eq(t, want, drops())
+	}
+}
@@ -0,0 +1,74 @@
+package httptest
+
+import (
+	"net/http"
+	"time"
+)
+
+// throttleTick is how often a throttled response writes a chunk of its body.
+const throttleTick = 10 * time.Millisecond
+
+// throttleHandler wraps a handler so that it writes its response at no more
+// than rate bytes per second.
+func throttleHandler(handler http.Handler, rate int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&throttledResponseWriter{
+			ResponseWriter: w,
+			req:            r,
+			rate:           rate,
+			chunk:          max(1, int(rate*int64(throttleTick)/int64(time.Second))),
+			start:          time.Now(),
+		}, r)
+	})
+}
+
+// throttledResponseWriter is an http.ResponseWriter writing its body in
+// small chunks, flushed to the client and paced to a fixed rate.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	req     *http.Request
+	rate    int64     // Bytes per second
+	chunk   int       // Bytes written per tick
+	start   time.Time // When the handler started writing
+	written int64     // Bytes written so far
+}
+
+// Write writes b in chunks, waiting after each until the rate allows the
+// bytes written so far. It stops early if the request is canceled.
+func (w *throttledResponseWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		size := min(len(b), w.chunk)
+		m, err := w.ResponseWriter.Write(b[:size])
+		n += m
+		w.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		b = b[size:]
+		w.Flush()
+
+		due := w.start.Add(time.Duration(w.written * int64(time.Second) / w.rate))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-timer.C:
+		case <-w.req.Context().Done():
+			timer.Stop()
+			return n, w.req.Context().Err()
+		}
+	}
+	return n, nil
+}
+
+// Flush sends any buffered data to the client.
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
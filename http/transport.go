@@ -2,26 +2,59 @@ package http
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/picatz/simnet"
 )
 
 // Transport is an http.RoundTripper that simulates network conditions.
+//
+// HTTP/2 is attempted over TLS connections, negotiated with ALPN, unless the
+// underlying transport disables it with a non-nil TLSNextProto map.
+//
+// The underlying transport is cloned and configured on first use, and the
+// clone is reused for every request so that connections are pooled. Changes
+// to Underlying or Dialer after the first request have no effect. A nil
+// Dialer dials with simnet.NewDialer(nil), applying no conditions.
 type Transport struct {
 	Underlying *http.Transport // Underlying transport (optional)
-	Dialer     *simnet.Dialer  // Simulated Dialer
+	Dialer     *simnet.Dialer  // Simulated Dialer (optional)
+
+	once      sync.Once
+	transport *http.Transport
 }
 
 // RoundTrip implements the RoundTripper interface.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	transport := t.Underlying
-	if transport == nil {
-		transport = http.DefaultTransport.(*http.Transport).Clone()
-	} else {
-		transport = transport.Clone()
-	}
+	return t.configured().RoundTrip(req)
+}
+
+// CloseIdleConnections closes any idle connections pooled by the transport.
+func (t *Transport) CloseIdleConnections() {
+	t.configured().CloseIdleConnections()
+}
+
+// configured returns the transport requests are sent with, cloning and
+// configuring it on first use.
+func (t *Transport) configured() *http.Transport {
+	t.once.Do(func() {
+		transport := t.Underlying
+		if transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		} else {
+			transport = transport.Clone()
+		}
 
-	transport.DialContext = t.Dialer.DialContext
+		// A custom dial function disables HTTP/2 unless it is explicitly
+		// attempted, and TLS is still layered on top of the simulated conn.
+		dialer := t.Dialer
+		if dialer == nil {
+			dialer = simnet.NewDialer(nil)
+		}
+		transport.DialContext = dialer.DialContext
+		transport.ForceAttemptHTTP2 = true
 
-	return transport.RoundTrip(req)
+		t.transport = transport
+	})
+	return t.transport
 }
@@ -1,11 +1,20 @@
 package http_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/picatz/simnet"
 	simhttp "github.com/picatz/simnet/http"
+	"github.com/shoenig/test/must"
 )
 
 func ExampleClient() {
@@ -37,3 +46,104 @@ func ExampleClient() {
 	// Output:
 	// Response status: 200 OK
 }
+
+func TestTransportHTTP2(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	client := &http.Client{
+		Transport: &simhttp.Transport{
+			Underlying: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+			Dialer: simnet.NewDialer(simnet.NewConfig(simnet.WithLatency(latency))),
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+	must.Eq(t, 2, resp.ProtoMajor)
+	must.GreaterEq(t, latency, time.Since(start))
+}
+
+// countingListener is a net.Listener counting the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestTransportReusesConns(t *testing.T) {
+	const requests = 20
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	ln := &countingListener{Listener: server.Listener}
+	server.Listener = ln
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := simhttp.NewClient(simnet.NewConfig(simnet.WithLatency(time.Millisecond)))
+	t.Cleanup(client.CloseIdleConnections)
+
+	for range requests {
+		resp, err := client.Get(server.URL)
+		must.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		must.NoError(t, err)
+		must.NoError(t, resp.Body.Close())
+		must.Eq(t, "ok", string(body))
+	}
+	must.Eq(t, 1, ln.accepted.Load())
+}
+
+func TestTransportDefaults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+
+	get := func(t *testing.T, client *http.Client) {
+		t.Helper()
+		t.Cleanup(client.CloseIdleConnections)
+		resp, err := client.Get(server.URL)
+		must.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		must.NoError(t, err)
+		must.NoError(t, resp.Body.Close())
+		must.Eq(t, "ok", string(body))
+	}
+
+	t.Run("nil dialer", func(t *testing.T) {
+		get(t, &http.Client{Transport: &simhttp.Transport{}})
+	})
+
+	t.Run("wrap client with nil transport", func(t *testing.T) {
+		client := &http.Client{}
+		simhttp.WrapClient(client, simnet.NewConfig(simnet.WithLatency(time.Millisecond)))
+		_, ok := client.Transport.(*simhttp.Transport)
+		must.True(t, ok)
+		get(t, client)
+	})
+}
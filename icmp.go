@@ -0,0 +1,66 @@
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/icmp"
+)
+
+// ICMPConn creates a simulated ICMP connection, for sending and receiving
+// ICMP messages such as echo requests with network conditions applied.
+//
+// The local address selects the kind of socket, as for icmp.ListenPacket:
+// an *net.IPAddr (or nil, for any IPv4 address) opens a privileged raw
+// socket, while an *net.UDPAddr opens an unprivileged datagram socket where
+// the system permits it. Messages are written to addresses of the same type.
+//
+// Partitioned addresses appear as total loss, as they would to ping: writes
+// to them succeed but are never answered, rather than failing with
+// ErrNetworkPartitioned.
+func ICMPConn(cfg *Config, laddr net.Addr) (net.PacketConn, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	network, address, err := icmpNetwork(laddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	spc := newSimulatedPacketConn(conn, cfg)
+	spc.dropPartitioned = true
+	return spc, nil
+}
+
+// icmpNetwork returns the network and address to listen on for ICMP
+// messages at the given local address.
+func icmpNetwork(laddr net.Addr) (network, address string, err error) {
+	var ip net.IP
+	switch addr := laddr.(type) {
+	case nil:
+		network = "ip4:icmp"
+	case *net.IPAddr:
+		network, ip = "ip4:icmp", addr.IP
+		if ip != nil && ip.To4() == nil {
+			network = "ip6:ipv6-icmp"
+		}
+	case *net.UDPAddr:
+		network, ip = "udp4", addr.IP
+		if ip != nil && ip.To4() == nil {
+			network = "udp6"
+		}
+	default:
+		return "", "", fmt.Errorf("%w: ICMP local address type %T", errors.ErrUnsupported, laddr)
+	}
+
+	if ip == nil {
+		return network, "", nil
+	}
+	return network, ip.String(), nil
+}
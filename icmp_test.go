@@ -0,0 +1,88 @@
+package simnet_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ping sends an echo request to addr and returns the round trip time of the
+// reply, or false if no reply arrives within the timeout.
+func ping(t *testing.T, conn net.PacketConn, addr net.Addr, seq int, timeout time.Duration) (time.Duration, bool) {
+	t.Helper()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("simnet")},
+	}
+	b, err := msg.Marshal(nil)
+	must.NoError(t, err)
+
+	// Replies are read in the background, since the raw socket also sees
+	// other ICMP traffic, such as the requests themselves on loopback.
+	replies := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply, err := icmp.ParseMessage(1, buf[:n])
+			if err != nil || reply.Type != ipv4.ICMPTypeEchoReply || from.String() != addr.String() {
+				continue
+			}
+			if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == seq {
+				close(replies)
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	_, err = conn.WriteTo(b, addr)
+	must.NoError(t, err)
+
+	select {
+	case <-replies:
+		return time.Since(start), true
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
+
+func TestICMPConn(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	reachable := &net.IPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	partitioned := &net.IPAddr{IP: net.IPv4(127, 0, 0, 2)}
+
+	cfg := simnet.NewConfig(simnet.WithLatency(latency))
+	cfg.AddPartition(partitioned.String())
+
+	conn, err := simnet.ICMPConn(cfg, reachable)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("raw ICMP sockets are not permitted:", err)
+	}
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// The echo request and its reply are both delayed.
+	rtt, ok := ping(t, conn, reachable, 1, time.Second)
+	must.True(t, ok)
+	must.Between(t, 2*latency, rtt, 2*latency+200*time.Millisecond)
+
+	// Partitioned addresses never answer.
+	_, ok = ping(t, conn, partitioned, 2, 5*latency)
+	must.False(t, ok)
+	must.Positive(t, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+}
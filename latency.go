@@ -0,0 +1,91 @@
+package simnet
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// LatencyDistribution samples latencies from a probability distribution,
+// allowing more realistic latency than a base latency with uniform jitter.
+type LatencyDistribution interface {
+	// Sample returns a latency drawn from the distribution.
+	Sample(rand *rand.Rand) time.Duration
+}
+
+// normalLatency is a LatencyDistribution with normally distributed latency.
+type normalLatency struct {
+	mean   time.Duration
+	stddev time.Duration
+}
+
+// NewNormalLatency returns a LatencyDistribution that samples latency from a
+// normal distribution with the given mean and standard deviation. Negative
+// samples are clamped to zero.
+func NewNormalLatency(mean, stddev time.Duration) LatencyDistribution {
+	return &normalLatency{
+		mean:   mean,
+		stddev: stddev,
+	}
+}
+
+// Sample returns a latency drawn from the normal distribution.
+func (d *normalLatency) Sample(rand *rand.Rand) time.Duration {
+	latency := time.Duration(rand.NormFloat64()*float64(d.stddev)) + d.mean
+	return max(latency, 0)
+}
+
+// paretoLatency is a LatencyDistribution with Pareto distributed latency.
+type paretoLatency struct {
+	min   time.Duration
+	alpha float64
+}
+
+// NewParetoLatency returns a LatencyDistribution that samples latency from a
+// Pareto distribution with the given minimum latency and shape alpha. Smaller
+// values of alpha produce longer tails. It panics if alpha is not positive.
+func NewParetoLatency(min time.Duration, alpha float64) LatencyDistribution {
+	if alpha <= 0 || math.IsNaN(alpha) {
+		panic("simnet: pareto alpha must be positive")
+	}
+	return &paretoLatency{
+		min:   min,
+		alpha: alpha,
+	}
+}
+
+// Sample returns a latency drawn from the Pareto distribution.
+func (d *paretoLatency) Sample(rand *rand.Rand) time.Duration {
+	// Inverse transform sampling, using 1-u to avoid dividing by zero.
+	u := 1 - rand.Float64()
+	latency := float64(d.min) / math.Pow(u, 1/d.alpha)
+
+	// Heavy tails can exceed the range of a time.Duration.
+	if latency >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(latency)
+}
+
+// uniformLatency is a LatencyDistribution with uniformly distributed latency.
+type uniformLatency struct {
+	min time.Duration
+	max time.Duration
+}
+
+// NewUniformLatency returns a LatencyDistribution that samples latency
+// uniformly from the range [min, max).
+func NewUniformLatency(min, max time.Duration) LatencyDistribution {
+	return &uniformLatency{
+		min: min,
+		max: max,
+	}
+}
+
+// Sample returns a latency drawn from the uniform distribution.
+func (d *uniformLatency) Sample(rand *rand.Rand) time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+	return d.min + time.Duration(rand.Int63n(int64(d.max-d.min)))
+}
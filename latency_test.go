@@ -0,0 +1,234 @@
+package simnet_test
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// meanLatency returns the mean of n samples drawn from dist.
+func meanLatency(dist simnet.LatencyDistribution, n int) time.Duration {
+	r := rand.New(rand.NewSource(42))
+
+	var total time.Duration
+	for range n {
+		total += dist.Sample(r)
+	}
+	return total / time.Duration(n)
+}
+
+func TestLatencyDistribution(t *testing.T) {
+	const samples = 10_000
+
+	t.Run("normal", func(t *testing.T) {
+		dist := simnet.NewNormalLatency(50*time.Millisecond, 10*time.Millisecond)
+		must.Between(t, 49*time.Millisecond, meanLatency(dist, samples), 51*time.Millisecond)
+	})
+
+	t.Run("normal is never negative", func(t *testing.T) {
+		dist := simnet.NewNormalLatency(time.Millisecond, 100*time.Millisecond)
+		r := rand.New(rand.NewSource(42))
+		for range samples {
+			must.NonNegative(t, dist.Sample(r))
+		}
+	})
+
+	t.Run("pareto", func(t *testing.T) {
+		dist := simnet.NewParetoLatency(10*time.Millisecond, 3)
+		r := rand.New(rand.NewSource(42))
+		for range samples {
+			must.GreaterEq(t, 10*time.Millisecond, dist.Sample(r))
+		}
+
+		// The mean of a Pareto distribution is alpha*min/(alpha-1).
+		must.Between(t, 14*time.Millisecond, meanLatency(dist, samples), 16*time.Millisecond)
+	})
+
+	t.Run("pareto heavy tail does not overflow", func(t *testing.T) {
+		dist := simnet.NewParetoLatency(10*time.Millisecond, 0.1)
+		r := rand.New(rand.NewSource(42))
+		for range samples {
+			must.GreaterEq(t, 10*time.Millisecond, dist.Sample(r))
+		}
+	})
+
+	t.Run("pareto rejects non-positive alpha", func(t *testing.T) {
+		for _, alpha := range []float64{0, -1} {
+			func() {
+				defer func() {
+					must.NotNil(t, recover())
+				}()
+				simnet.NewParetoLatency(10*time.Millisecond, alpha)
+			}()
+		}
+	})
+
+	t.Run("uniform", func(t *testing.T) {
+		dist := simnet.NewUniformLatency(10*time.Millisecond, 20*time.Millisecond)
+		r := rand.New(rand.NewSource(42))
+		for range samples {
+			must.Between(t, 10*time.Millisecond, dist.Sample(r), 20*time.Millisecond)
+		}
+		must.Between(t, 14*time.Millisecond, meanLatency(dist, samples), 16*time.Millisecond)
+	})
+
+	t.Run("overrides latency and jitter", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(time.Second),
+			simnet.WithJitter(time.Second),
+			simnet.WithLatencyDistribution(simnet.NewUniformLatency(10*time.Millisecond, 20*time.Millisecond)),
+		)
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+		must.Between(t, 10*time.Millisecond, time.Since(start), 500*time.Millisecond)
+	})
+}
+
+func TestSymmetricJitter(t *testing.T) {
+	const (
+		packets = 2000
+		latency = 10 * time.Millisecond
+		jitter  = 10 * time.Millisecond
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// delays writes packets and returns the latency applied to each. In
+	// deterministic mode writes return without waiting for the latency.
+	delays := func(t *testing.T, opts ...simnet.Option) []time.Duration {
+		var mu sync.Mutex
+		var delays []time.Duration
+		cfg := simnet.NewConfig(append([]simnet.Option{
+			simnet.WithLatency(latency),
+			simnet.WithJitter(jitter),
+			simnet.WithDeterministic(true),
+			simnet.WithSeed(42),
+			simnet.WithOnDelay(func(addr net.Addr, size int, d time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				delays = append(delays, d)
+			}),
+		}, opts...)...)
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		for range packets {
+			_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return delays
+	}
+
+	mean := func(delays []time.Duration) time.Duration {
+		var total time.Duration
+		for _, d := range delays {
+			total += d
+		}
+		return total / time.Duration(len(delays))
+	}
+
+	// By default jitter only adds latency.
+	oneSided := delays(t)
+	must.Between(t, 14*time.Millisecond, mean(oneSided), 16*time.Millisecond)
+	must.GreaterEq(t, latency, slices.Min(oneSided))
+
+	// Symmetric jitter varies it both ways, keeping the mean latency.
+	symmetric := delays(t, simnet.WithSymmetricJitter(true))
+	must.Between(t, 9*time.Millisecond, mean(symmetric), 11*time.Millisecond)
+	must.Less(t, latency, slices.Min(symmetric))
+	must.GreaterEq(t, latency-jitter/2, slices.Min(symmetric))
+
+	t.Run("latency is never negative", func(t *testing.T) {
+		clamped := delays(t, simnet.WithSymmetricJitter(true), simnet.WithJitter(100*latency))
+		must.Zero(t, slices.Min(clamped))
+	})
+}
+
+func TestLatencySpikes(t *testing.T) {
+	const (
+		packets      = 2000
+		latency      = 10 * time.Millisecond
+		spikeRate    = 0.1
+		spikeLatency = 100 * time.Millisecond
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	var mu sync.Mutex
+	var delays []time.Duration
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(latency),
+		simnet.WithLatencySpikes(spikeRate, spikeLatency),
+		simnet.WithDeterministic(true),
+		simnet.WithSeed(42),
+		simnet.WithOnDelay(func(addr net.Addr, size int, d time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			delays = append(delays, d)
+		}),
+	)
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for range packets {
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	must.Len(t, packets, delays)
+
+	// About SpikeRate of the packets are spiked, and the rest keep the base
+	// latency.
+	var spiked int
+	for _, d := range delays {
+		if d > latency {
+			must.Eq(t, latency+spikeLatency, d)
+			spiked++
+		} else {
+			must.Eq(t, latency, d)
+		}
+	}
+	must.Between(t, 160, spiked, 240)
+}
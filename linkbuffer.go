@@ -0,0 +1,53 @@
+package simnet
+
+import "sync"
+
+// linkBuffer bounds the bytes in flight on a link in one direction, as the
+// buffer of a router or modem does. Bytes are reserved when a packet is sent
+// and released once it is delivered.
+type linkBuffer struct {
+	mu    sync.Mutex
+	used  int           // Bytes in flight
+	freed chan struct{} // Closed and replaced when bytes are released
+}
+
+// reserve reserves n bytes in a buffer of the given size, reporting whether it
+// did. A full buffer fails the reservation, unless wait is set, in which case
+// it waits for room until stop is closed. A packet larger than the buffer is
+// let through when the buffer is empty, so that it is not held forever.
+func (lb *linkBuffer) reserve(n, size int, wait bool, stop <-chan struct{}) bool {
+	for {
+		lb.mu.Lock()
+		if lb.used == 0 || lb.used+n <= size {
+			lb.used += n
+			lb.mu.Unlock()
+			return true
+		}
+		if !wait {
+			lb.mu.Unlock()
+			return false
+		}
+		if lb.freed == nil {
+			lb.freed = make(chan struct{})
+		}
+		freed := lb.freed
+		lb.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// release releases n reserved bytes, waking senders waiting for room.
+func (lb *linkBuffer) release(n int) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.used -= n
+	if lb.freed != nil {
+		close(lb.freed)
+		lb.freed = nil
+	}
+}
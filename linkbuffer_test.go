@@ -0,0 +1,77 @@
+package simnet_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestLinkBuffer(t *testing.T) {
+	const (
+		packets = 20
+		size    = 500
+		latency = 50 * time.Millisecond
+	)
+
+	// send writes packets through a link buffer holding four of them,
+	// returning how many arrive, how long the last took, and the config.
+	send := func(t *testing.T, opts ...simnet.Option) (int, time.Duration, *simnet.Config) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		cfg := simnet.NewConfig(append(opts,
+			simnet.WithLatency(latency),
+			simnet.WithInOrderDelivery(true),
+			simnet.WithLinkBuffer(4*size),
+		)...)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		for range packets {
+			_, err := conn.WriteTo(make([]byte, size), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		var (
+			received int
+			last     time.Duration
+		)
+		buf := make([]byte, size)
+		for {
+			peer.SetReadDeadline(time.Now().Add(4 * latency))
+			if _, err := peer.Read(buf); err != nil {
+				break
+			}
+			received++
+			last = time.Since(start)
+		}
+		return received, last, cfg
+	}
+
+	t.Run("queue", func(t *testing.T) {
+		// Packets wait for room, so the last one queues behind four
+		// buffers' worth of packets ahead of it.
+		received, last, cfg := send(t)
+		must.Eq(t, packets, received)
+		must.GreaterEq(t, packets/4*latency-latency/2, last)
+		must.Eq(t, 0, cfg.Stats().PacketsDropped)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		// Packets beyond the buffer are dropped rather than delayed.
+		received, last, cfg := send(t, simnet.WithLinkBufferDrop(true))
+		must.Eq(t, 4, received)
+		must.Less(t, 2*latency, last)
+		must.Eq(t, packets-4, cfg.Stats().PacketsDropped)
+	})
+}
@@ -1,9 +1,11 @@
 package simnet
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 )
 
 // ErrFailedToAccept is returned when a connection cannot be accepted.
@@ -13,29 +15,179 @@ var ErrFailedToAccept = errors.New("simnet: failed to accept connection")
 type Listener struct {
 	ln  net.Listener
 	cfg *Config
+
+	mu       sync.Mutex
+	live     int           // Accepted connections not yet closed, or slots reserved for them
+	released chan struct{} // Closed and replaced when a connection is closed
+	done     chan struct{} // Closed by Close
+	doneOnce sync.Once
+
+	acceptMu  sync.Mutex
+	accepting bool              // Whether an Accept for AcceptContext is waiting
+	accepted  chan acceptResult // Results of Accept for AcceptContext
+}
+
+// acceptResult is the result of an Accept made for AcceptContext.
+type acceptResult struct {
+	conn net.Conn
+	err  error
 }
 
 // NewListener wraps an existing net.Listener with simulated network conditions.
+//
+// Accepted connections share the config, so changes to it apply to all of
+// them, but each makes its random decisions with its own generator, derived
+// from the config's seed in the order connections are accepted. Concurrent
+// connections therefore do not perturb one another's loss, duplication, or
+// reordering. A nil cfg applies no conditions.
 func NewListener(ln net.Listener, cfg *Config) net.Listener {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
 	return &Listener{
-		ln:  ln,
-		cfg: cfg,
+		ln:       ln,
+		cfg:      cfg,
+		released: make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 }
 
 // Accept waits for and returns the next connection to the listener.
+// Connections from partitioned addresses, or from addresses partitioned from
+// the listener by Config.PartitionGroups, are closed and never returned, as
+// if they had not arrived. With Config.MaxConns set, Accept waits for an
+// accepted connection to be closed while the limit is reached, or closes
+// connections arriving in the meantime with Config.RejectOverMaxConns.
 func (l *Listener) Accept() (net.Conn, error) {
-	conn, err := l.ln.Accept()
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrFailedToAccept, err)
+	sources := sourceAddrs(l.ln.Addr())
+	for {
+		maxConns, reject := l.cfg.maxConns()
+		reserved := false
+		if maxConns > 0 && !reject {
+			// Reserve a slot before accepting, leaving connections
+			// queued by the underlying listener until one is free.
+			if err := l.reserve(); err != nil {
+				return nil, err
+			}
+			reserved = true
+		}
+
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if reserved {
+				l.release()
+			}
+			return nil, fmt.Errorf("%w: %s", ErrFailedToAccept, err)
+		}
+		if l.cfg.isPartitionedFrom(sources, conn.RemoteAddr().String()) {
+			if reserved {
+				l.release()
+			}
+			conn.Close()
+			continue
+		}
+		if !reserved && !l.tryReserve(maxConns) {
+			conn.Close()
+			continue
+		}
+		// Wrap the connection with simulated network conditions.
+		sc := wrapConn(conn, l.cfg)
+		sc.sources = sources
+		sc.onClose = l.release
+		return sc, nil
+	}
+}
+
+// tryReserve takes a slot for a connection if fewer than maxConns are open,
+// or maxConns is unlimited, reporting whether it did.
+func (l *Listener) tryReserve(maxConns int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxConns > 0 && l.live >= maxConns {
+		return false
+	}
+	l.live++
+	return true
+}
+
+// reserve takes a slot for a connection, waiting for one to be released
+// while Config.MaxConns connections are open, or until the listener is
+// closed.
+func (l *Listener) reserve() error {
+	for {
+		maxConns, _ := l.cfg.maxConns()
+
+		l.mu.Lock()
+		if maxConns <= 0 || l.live < maxConns {
+			l.live++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-l.done:
+			return fmt.Errorf("%w: %s", ErrFailedToAccept, net.ErrClosed)
+		}
+	}
+}
+
+// release frees the slot of a closed connection, waking an Accept waiting
+// for one.
+func (l *Listener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.live--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// AcceptContext is like Accept, but returns the context's error if the
+// context is done before a connection arrives. The underlying Accept keeps
+// waiting in the background, and a connection it accepts after the context is
+// done is returned by the next call to AcceptContext rather than being lost.
+func (l *Listener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.acceptMu.Lock()
+	if l.accepted == nil {
+		l.accepted = make(chan acceptResult, 1)
+	}
+	// Only one Accept waits at a time, and only while no result is waiting
+	// to be taken, so its result never blocks on the channel. It hands over
+	// its result and clears accepting together, so that a caller taking the
+	// result can always start the next Accept.
+	if !l.accepting && len(l.accepted) == 0 {
+		l.accepting = true
+		go func() {
+			conn, err := l.Accept()
+			l.acceptMu.Lock()
+			l.accepted <- acceptResult{conn, err}
+			l.accepting = false
+			l.acceptMu.Unlock()
+		}()
+	}
+	accepted := l.accepted
+	l.acceptMu.Unlock()
+
+	select {
+	case r := <-accepted:
+		return r.conn, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	// Wrap the connection with simulated network conditions.
-	return wrapConn(conn, l.cfg), nil
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (l *Listener) Close() error {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
 	return l.ln.Close()
 }
 
@@ -0,0 +1,280 @@
+package simnet_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// startSimulatedEchoServer starts a TCP server behind a simulated listener
+// that echoes everything it reads back to the client, returning its address.
+func startSimulatedEchoServer(t *testing.T, cfg *simnet.Config) string {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+
+	ln := simnet.NewListener(inner, cfg)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// echoFrom dials addr from the given local IP and returns the result of an
+// echo round trip.
+func echoFrom(addr, ip string) error {
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func TestListenerPartition(t *testing.T) {
+	cfg := simnet.NewConfig()
+	cfg.AddPartition("127.0.0.2")
+	addr := startSimulatedEchoServer(t, cfg)
+
+	// Connections from partitioned clients are closed without being
+	// accepted, while other clients connect as usual.
+	must.Error(t, echoFrom(addr, "127.0.0.2"))
+	must.NoError(t, echoFrom(addr, "127.0.0.1"))
+
+	cfg.RemovePartition("127.0.0.2")
+	must.NoError(t, echoFrom(addr, "127.0.0.2"))
+}
+
+func TestListenerNilConfig(t *testing.T) {
+	addr := startSimulatedEchoServer(t, nil)
+
+	// Without a config, connections are accepted with no conditions.
+	must.NoError(t, echoFrom(addr, "127.0.0.1"))
+}
+
+func TestListenerPartitionGroups(t *testing.T) {
+	cfg := simnet.NewConfig()
+	cfg.PartitionGroups([]string{"127.0.0.1"}, []string{"127.0.0.2"})
+	addr := startSimulatedEchoServer(t, cfg)
+
+	// Connections from the other group are closed without being accepted.
+	must.Error(t, echoFrom(addr, "127.0.0.2"))
+	must.NoError(t, echoFrom(addr, "127.0.0.3"))
+
+	cfg.HealPartition()
+	must.NoError(t, echoFrom(addr, "127.0.0.2"))
+}
+
+func TestListenerAcceptContext(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	ln := simnet.NewListener(inner, simnet.NewConfig()).(*simnet.Listener)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		conn, err := ln.AcceptContext(ctx)
+		must.ErrorIs(t, err, context.Canceled)
+		must.Nil(t, conn)
+		must.Less(t, time.Second, time.Since(start))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+
+		_, err := ln.AcceptContext(ctx)
+		must.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	// The Accept left waiting by the canceled calls hands its connection to
+	// the next call, rather than dropping it.
+	t.Run("accepted", func(t *testing.T) {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			client.Close()
+		})
+
+		conn, err := ln.AcceptContext(context.Background())
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		must.Eq(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	})
+}
+
+func TestListenerAcceptContextLoop(t *testing.T) {
+	const (
+		dialers = 4
+		dials   = 50
+	)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	ln := simnet.NewListener(inner, simnet.NewConfig()).(*simnet.Listener)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	clients := make(chan net.Conn, dialers*dials)
+	t.Cleanup(func() {
+		close(clients)
+		for conn := range clients {
+			conn.Close()
+		}
+	})
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	for range dialers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range dials {
+				conn, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				clients <- conn
+			}
+		}()
+	}
+
+	// Each call takes the result of the Accept started for it, or for an
+	// earlier call, and must start the next one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	for range dialers * dials {
+		conn, err := ln.AcceptContext(ctx)
+		must.NoError(t, err)
+		conn.Close()
+	}
+}
+
+func TestListenerMaxConns(t *testing.T) {
+	const maxConns = 2
+
+	// listen starts a listener accepting connections in the background,
+	// returning it and the accepted connections.
+	listen := func(t *testing.T, opts ...simnet.Option) (net.Listener, <-chan net.Conn) {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		must.NoError(t, err)
+		ln := simnet.NewListener(inner, simnet.NewConfig(append(opts, simnet.WithMaxConns(maxConns))...))
+		t.Cleanup(func() {
+			ln.Close()
+		})
+
+		accepted := make(chan net.Conn, maxConns+1)
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+		return ln, accepted
+	}
+
+	// dial connects a client to the listener.
+	dial := func(t *testing.T, ln net.Listener) net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		return conn
+	}
+
+	t.Run("queued", func(t *testing.T) {
+		ln, accepted := listen(t)
+
+		var conns []net.Conn
+		for range maxConns {
+			dial(t, ln)
+			conns = append(conns, <-accepted)
+		}
+
+		// The next connection waits in the accept queue until one of the
+		// accepted connections is closed.
+		dial(t, ln)
+		select {
+		case <-accepted:
+			t.Fatal("expected connection beyond the limit to be queued")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		must.NoError(t, conns[0].Close())
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(time.Second):
+			t.Fatal("expected queued connection to be accepted")
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		ln, accepted := listen(t, simnet.WithRejectOverMaxConns(true))
+
+		var conns []net.Conn
+		for range maxConns {
+			dial(t, ln)
+			conns = append(conns, <-accepted)
+		}
+
+		// The next connection is closed rather than accepted.
+		client := dial(t, ln)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := client.Read(make([]byte, 1))
+		must.Error(t, err)
+		var netErr net.Error
+		must.False(t, errors.As(err, &netErr) && netErr.Timeout())
+		must.Eq(t, 0, len(accepted))
+
+		// Once a connection is closed, new ones are accepted again.
+		must.NoError(t, conns[0].Close())
+		dial(t, ln)
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(time.Second):
+			t.Fatal("expected connection to be accepted below the limit")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package simnet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// measurePayloadSize is the size of the payload echoed by MeasureLatency.
+const measurePayloadSize = 64
+
+// errEchoMismatch is returned by MeasureLatency when the peer echoes back
+// something other than the payload sent.
+var errEchoMismatch = errors.New("simnet: echoed payload does not match")
+
+// LatencyReport summarizes round-trip latencies measured by MeasureLatency.
+type LatencyReport struct {
+	Samples int           // Round trips measured
+	P50     time.Duration // Median round trip
+	P90     time.Duration // 90th percentile round trip
+	P99     time.Duration // 99th percentile round trip
+	Max     time.Duration // Slowest round trip
+}
+
+// MeasureLatency sends a payload on conn and waits for the peer to echo it
+// back, one round trip at a time, returning percentiles of the round-trip
+// latencies of the samples. It allows tests to assert latency objectives
+// against configured conditions without collecting a histogram themselves.
+//
+// It works with stream connections and with connected packet conns, such as
+// those returned by DialUDP, as long as the peer echoes what it receives. On
+// a packet conn, a lost datagram blocks the measurement until the conn's read
+// deadline, if one is set, failing it.
+func MeasureLatency(conn io.ReadWriter, samples int) (LatencyReport, error) {
+	if samples <= 0 {
+		return LatencyReport{}, fmt.Errorf("simnet: measuring latency: samples must be positive, got %d", samples)
+	}
+
+	payload := make([]byte, measurePayloadSize)
+	echo := make([]byte, measurePayloadSize)
+	rtts := make([]time.Duration, 0, samples)
+	for i := range samples {
+		// Number each payload, so that a stray echo is not mistaken for the
+		// current one.
+		for j := range payload {
+			payload[j] = byte(i + j)
+		}
+
+		start := time.Now()
+		if _, err := conn.Write(payload); err != nil {
+			return LatencyReport{}, fmt.Errorf("simnet: measuring latency: %w", err)
+		}
+		if _, err := io.ReadFull(conn, echo); err != nil {
+			return LatencyReport{}, fmt.Errorf("simnet: measuring latency: %w", err)
+		}
+		rtts = append(rtts, time.Since(start))
+
+		if !bytes.Equal(payload, echo) {
+			return LatencyReport{}, errEchoMismatch
+		}
+	}
+
+	slices.Sort(rtts)
+	return LatencyReport{
+		Samples: samples,
+		P50:     percentile(rtts, 0.50),
+		P90:     percentile(rtts, 0.90),
+		P99:     percentile(rtts, 0.99),
+		Max:     rtts[len(rtts)-1],
+	}, nil
+}
+
+// percentile returns the p-th percentile of sorted durations, using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
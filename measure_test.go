@@ -0,0 +1,77 @@
+package simnet_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestMeasureLatency(t *testing.T) {
+	const (
+		samples    = 100
+		minLatency = 5 * time.Millisecond
+		maxLatency = 15 * time.Millisecond
+		slack      = 5 * time.Millisecond // Time taken by the echo over loopback
+	)
+
+	// Only writes are delayed, so each round trip takes a latency drawn
+	// uniformly from [minLatency, maxLatency).
+	cfg := func() *simnet.Config {
+		return simnet.NewConfig(simnet.WithOutbound(simnet.DirectionConfig{
+			LatencyDist: simnet.NewUniformLatency(minLatency, maxLatency),
+		}))
+	}
+
+	check := func(t *testing.T, report simnet.LatencyReport) {
+		must.Eq(t, samples, report.Samples)
+		must.Between(t, 8*time.Millisecond, report.P50, 12*time.Millisecond+slack)
+		must.Between(t, 12*time.Millisecond, report.P90, maxLatency+slack)
+		must.Between(t, report.P90, report.P99, maxLatency+slack)
+		must.GreaterEq(t, report.P99, report.Max)
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		conn, err := simnet.NewDialer(cfg()).Dial("tcp", startEchoServer(t))
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		report, err := simnet.MeasureLatency(conn, samples)
+		must.NoError(t, err)
+		check(t, report)
+	})
+
+	t.Run("packet", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+		go echoUDP(peer)
+
+		conn, err := simnet.DialUDP(cfg(), nil, peer.LocalAddr().(*net.UDPAddr))
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		report, err := simnet.MeasureLatency(conn, samples)
+		must.NoError(t, err)
+		check(t, report)
+	})
+
+	t.Run("no samples", func(t *testing.T) {
+		a, b := simnet.Pipe(simnet.NewConfig())
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		_, err := simnet.MeasureLatency(a, 0)
+		must.Error(t, err)
+	})
+}
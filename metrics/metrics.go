@@ -0,0 +1,46 @@
+// Package metrics exports the statistics of simulated network conditions as
+// expvar variables, so that they can be scraped from /debug/vars, or by a
+// Prometheus exporter for expvar, while long-running tests are underway. It is
+// a separate package since importing expvar registers its HTTP handler.
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/picatz/simnet"
+)
+
+// Var returns a variable reporting the statistics summed over every
+// connection created from cfg, as returned by Config.Stats, read each time the
+// variable is. The statistics are reported as an object of counters named
+// following Prometheus conventions:
+//
+//	packets_sent_total        packets, or writes on a stream, sent
+//	packets_dropped_total     packets lost to simulated loss
+//	packets_duplicated_total  duplicate copies delivered
+//	packets_reordered_total   packets reordered
+//	packets_overflowed_total  packets dropped by a full read queue
+//	bytes_sent_total          bytes written to underlying connections
+//	bytes_received_total      bytes returned to readers
+//	latency_seconds_total     simulated delay applied
+func Var(cfg *simnet.Config) expvar.Var {
+	return expvar.Func(func() any {
+		stats := cfg.Stats()
+		return map[string]any{
+			"packets_sent_total":       stats.PacketsSent,
+			"packets_dropped_total":    stats.PacketsDropped,
+			"packets_duplicated_total": stats.PacketsDuplicated,
+			"packets_reordered_total":  stats.PacketsReordered,
+			"packets_overflowed_total": stats.PacketsOverflowed,
+			"bytes_sent_total":         stats.BytesSent,
+			"bytes_received_total":     stats.BytesReceived,
+			"latency_seconds_total":    stats.TotalLatency.Seconds(),
+		}
+	})
+}
+
+// Publish publishes the variable returned by Var under name. Like
+// expvar.Publish, it panics if a variable is already published under name.
+func Publish(name string, cfg *simnet.Config) {
+	expvar.Publish(name, Var(cfg))
+}
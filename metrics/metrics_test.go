@@ -0,0 +1,82 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/picatz/simnet/metrics"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+)
+
+// runs counts runs of TestPublish.
+var runs atomic.Int32
+
+func TestPublish(t *testing.T) {
+	const datagrams = 10
+
+	cfg := simnet.NewConfig(
+		simnet.WithDuplicateRate(1),
+		simnet.WithLatency(10*time.Millisecond),
+	)
+	// Publishing panics if the name is taken, so it is unique to each run
+	// of the test.
+	name := fmt.Sprintf("simnet_%d", runs.Add(1))
+	metrics.Publish(name, cfg)
+
+	// read returns the published metrics, as they would be scraped.
+	read := func() map[string]float64 {
+		var values map[string]float64
+		must.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &values))
+		return values
+	}
+
+	// A stream write is duplicated, delivering it twice.
+	a, b := simnet.Pipe(cfg)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	_, err := a.Write([]byte("ping"))
+	must.NoError(t, err)
+	_, err = io.ReadFull(b, make([]byte, 8))
+	must.NoError(t, err)
+
+	// Datagrams on a packet conn are counted with the stream.
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	for range datagrams {
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			return read()["bytes_sent_total"] == 8+2*datagrams*4
+		}),
+		wait.Timeout(time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	values := read()
+	must.Eq(t, 1+datagrams, values["packets_sent_total"])
+	must.Eq(t, 1+datagrams, values["packets_duplicated_total"])
+	must.Eq(t, 0, values["packets_dropped_total"])
+	must.Eq(t, 8, values["bytes_received_total"])
+	must.GreaterEq(t, float64(1+datagrams)*0.010, values["latency_seconds_total"])
+}
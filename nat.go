@@ -0,0 +1,83 @@
+package simnet
+
+import (
+	"net"
+	"time"
+)
+
+// sendConn returns the connection outgoing packets are sent through. With
+// Config.NATRebind set, it stands in for the external address a NAT maps the
+// conn to: once the interval has passed since the mapping was made, packets
+// are sent from a new socket on the same host, so peers see a new source
+// port, and packets sent to the old one are no longer received.
+func (spc *simulatedPacketConn) sendConn() net.PacketConn {
+	interval := spc.cfg.natRebind()
+
+	spc.natMu.Lock()
+	defer spc.natMu.Unlock()
+
+	current := spc.conn
+	if spc.nat != nil {
+		current = spc.nat
+	}
+	now := spc.clock.Now()
+	if interval <= 0 || now.Sub(spc.natBound) < interval {
+		return current
+	}
+	select {
+	case <-spc.closed:
+		return current
+	default:
+	}
+
+	conn, err := rebind(spc.conn.LocalAddr())
+	if err != nil {
+		// Keep the mapping rather than lose the packet, as for a conn
+		// that is not on a network a NAT can remap.
+		return current
+	}
+	if spc.nat != nil {
+		spc.nat.Close()
+	}
+	spc.nat, spc.natBound = conn, now
+	go spc.readLoop(conn)
+	return conn
+}
+
+// isMapped reports whether packets received on conn reach the packet conn,
+// which they only do on its current NAT mapping.
+func (spc *simulatedPacketConn) isMapped(conn net.PacketConn) bool {
+	spc.natMu.Lock()
+	defer spc.natMu.Unlock()
+	if spc.nat == nil {
+		return conn == spc.conn
+	}
+	return conn == spc.nat
+}
+
+// closeNAT closes the socket of the current NAT mapping, if any.
+func (spc *simulatedPacketConn) closeNAT() {
+	spc.natMu.Lock()
+	defer spc.natMu.Unlock()
+	if spc.nat != nil {
+		spc.nat.Close()
+	}
+}
+
+// rebind returns a new socket on the host of addr, with a port chosen by the
+// system, for a new NAT mapping.
+func rebind(addr net.Addr) (net.PacketConn, error) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenPacket(addr.Network(), net.JoinHostPort(host, "0"))
+}
+
+// natRebind returns the interval after which packet conns rebind to a new
+// source port, with zero meaning never.
+func (cfg *Config) natRebind() time.Duration {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.NATRebind
+}
@@ -0,0 +1,73 @@
+package simnet_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestNATRebind(t *testing.T) {
+	const interval = 200 * time.Millisecond
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	conn := simnet.UDPConnT(t, simnet.NewConfig(simnet.WithNATRebind(interval)), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	local := conn.LocalAddr().String()
+
+	// send writes a packet to the peer, returning the address it arrives
+	// from.
+	send := func(t *testing.T) *net.UDPAddr {
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		_, addr, err := peer.ReadFromUDP(make([]byte, 16))
+		must.NoError(t, err)
+		return addr
+	}
+
+	// receive returns the payload of the next packet the conn receives.
+	receive := func(t *testing.T) string {
+		buf := make([]byte, 16)
+		n, _, err := conn.ReadFrom(buf)
+		must.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	// Until the interval passes, packets are sent from the conn's own
+	// address, and replies to it are received.
+	first := send(t)
+	must.Eq(t, local, first.String())
+	must.Eq(t, first.String(), send(t).String())
+	_, err = peer.WriteToUDP([]byte("reply"), first)
+	must.NoError(t, err)
+	must.Eq(t, "reply", receive(t))
+
+	// After the interval, packets come from a new port on the same host.
+	time.Sleep(interval)
+	second := send(t)
+	must.NotEq(t, first.Port, second.Port)
+	must.True(t, first.IP.Equal(second.IP))
+	must.Eq(t, second.String(), send(t).String())
+
+	// Replies to the old mapping are no longer received.
+	_, err = peer.WriteToUDP([]byte("stale"), first)
+	must.NoError(t, err)
+	_, err = peer.WriteToUDP([]byte("fresh"), second)
+	must.NoError(t, err)
+	must.Eq(t, "fresh", receive(t))
+
+	// The conn itself keeps its local address.
+	must.Eq(t, local, conn.LocalAddr().String())
+
+	// Each interval brings a new mapping.
+	time.Sleep(interval)
+	must.NotEq(t, second.Port, send(t).Port)
+}
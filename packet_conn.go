@@ -1,79 +1,333 @@
 package simnet
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"os"
+	"sync"
+	"syscall"
 	"time"
 )
 
+var (
+	// ErrPacketDropped is returned by WriteTo when the packet is lost, if
+	// Config.WriteErrorOnLoss is set.
+	ErrPacketDropped = errors.New("simnet: packet dropped")
+
+	// ErrDatagramTruncated is returned by ReadFrom, along with the part of
+	// the datagram that fit, when the buffer is too small for the datagram,
+	// if Config.StrictDatagramTruncation is set.
+	ErrDatagramTruncated = errors.New("simnet: datagram truncated")
+
+	// ErrSendFailed is returned, wrapping the error it failed with, after
+	// the underlying connection fails to send data that a write already
+	// accepted. A packet conn returns it once, from the next WriteTo or
+	// ReadFrom; a stream conn returns it from every later Write, and from
+	// Read once the data received is read, since the stream is broken.
+	ErrSendFailed = errors.New("simnet: send failed")
+)
+
+// Drainer is implemented by the simulated packet conns returned by this
+// package, allowing packets still being delayed to be delivered before the
+// connection is closed.
+type Drainer interface {
+	// CloseWithDrain stops accepting packets and waits until every packet
+	// still being delayed has been delivered, or dropped if nothing will
+	// read it, before closing the connection. If ctx is done first, the
+	// remaining packets are dropped and the context's error is returned.
+	CloseWithDrain(ctx context.Context) error
+}
+
 // simulatedPacketConn is a net.PacketConn that simulates network conditions
 // such as latency, loss, duplication, and reordering.
 type simulatedPacketConn struct {
 	conn       net.PacketConn
 	cfg        *Config
 	localAddr  net.Addr
+	sources    []string // Addresses the conn may be reached at, for partition groups
 	remoteAddr net.Addr
 	closed     chan struct{}
 	readQueue  chan packet
 	writeQueue chan packet
 	rand       *rand.Rand
+	inBucket   *bucket    // Bandwidth limiter for incoming packets
+	outBucket  *bucket    // Bandwidth limiter for outgoing packets
+	inLink     linkBuffer // Incoming bytes in flight (see WithLinkBuffer)
+	outLink    linkBuffer // Outgoing bytes in flight (see WithLinkBuffer)
+	inHold     holdBuffer // Incoming packets held for bounded reordering
+	outHold    holdBuffer // Outgoing packets held for bounded reordering
+	clock      Clock      // Source of time for simulated delays
+	inSched    *scheduler // Delivers incoming packets in deterministic or in-order mode
+	outSched   *scheduler // Delivers outgoing packets in deterministic or in-order mode
+	inOrder    bool       // Packets are delivered in the order sent (see WithInOrderDelivery)
+	stats      stats      // Runtime statistics
+
+	// dropPartitioned drops packets written to partitioned addresses
+	// rather than failing the write, so that they appear lost.
+	dropPartitioned bool
+
+	mu        sync.Mutex
+	pending   int           // Packets accepted but not yet delivered or dropped
+	draining  chan struct{} // Closed when CloseWithDrain stops accepting packets
+	drained   chan struct{} // Closed once pending reaches zero while draining
+	closeOnce sync.Once
+
+	natMu    sync.Mutex
+	nat      net.PacketConn // Socket of the current NAT mapping, if rebound (see Config.NATRebind)
+	natBound time.Time      // When the current NAT mapping was made
+
+	readDeadline time.Time     // Deadline for ReadFrom
+	readChanged  chan struct{} // Closed when the read deadline or sendErr changes
+	sendErr      error         // Unreported failure to send a packet, if any
 }
 
 // packet represents a UDP packet, including the data and the address
 // it was sent from or to (depending on whether it is incoming or outgoing).
 type packet struct {
-	data []byte
-	addr net.Addr
+	data    []byte
+	addr    net.Addr
+	index   int    // Index among the packets written on the conn, for scripted events
+	linked  bool   // Whether the packet holds bytes in the link buffer
+	retries int    // Times the packet has been retransmitted after loss
+	tag     string // Tag identifying the packet in callbacks (optional)
 }
 
 // newSimulatedPacketConn creates a new simulatedPacketConn with the given
 // underlying connection and network configuration.
-func newSimulatedPacketConn(conn net.PacketConn, cfg *Config, rand *rand.Rand) *simulatedPacketConn {
+func newSimulatedPacketConn(conn net.PacketConn, cfg *Config) *simulatedPacketConn {
 	spc := &simulatedPacketConn{
-		conn:       conn,
-		cfg:        cfg,
-		closed:     make(chan struct{}),
-		readQueue:  make(chan packet, 100),
-		writeQueue: make(chan packet, 100),
-		rand:       rand,
+		conn:        conn,
+		cfg:         cfg,
+		closed:      make(chan struct{}),
+		draining:    make(chan struct{}),
+		readChanged: make(chan struct{}),
+		readQueue:   make(chan packet, cfg.queueSize()),
+		writeQueue:  make(chan packet, cfg.queueSize()),
+		rand:        cfg.deriveRand(),
+		sources:     sourceAddrs(conn.LocalAddr()),
+		inBucket:    newBucket(cfg, inbound),
+		outBucket:   newBucket(cfg, outbound),
+		clock:       cfg.clock(),
+	}
+	spc.natBound = spc.clock.Now()
+	spc.stats.link(cfg.totalStats())
+	spc.inOrder = cfg.isInOrderDelivery()
+	if deterministic := cfg.isDeterministic(); deterministic || spc.inOrder {
+		spc.inSched = newScheduler(spc.clock, spc.closed, deterministic)
+		spc.outSched = newScheduler(spc.clock, spc.closed, deterministic)
 	}
 
 	// Start the read and write loops in separate goroutines.
-	go spc.readLoop()
+	go spc.readLoop(conn)
 	go spc.writeLoop()
 
 	return spc
 }
 
-// ReadFrom reads a packet from the connection, applying network conditions.
+// ReadFrom reads a packet from the connection, applying inbound network
+// conditions. A packet whose simulated delay ends after the read deadline is
+// not received in time, so the read fails with os.ErrDeadlineExceeded.
 func (spc *simulatedPacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
-	select {
-	case pkt := <-spc.readQueue:
-		n = copy(p, pkt.data)
-		addr = pkt.addr
-		return n, addr, nil
-	case <-spc.closed:
-		return 0, nil, net.ErrClosed
+	for {
+		if err := spc.takeSendErr(); err != nil {
+			return 0, nil, err
+		}
+
+		select {
+		case pkt := <-spc.readQueue:
+			return spc.receive(p, pkt)
+		default:
+		}
+
+		spc.mu.Lock()
+		deadline := spc.readDeadline
+		changed := spc.readChanged
+		spc.mu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case pkt := <-spc.readQueue:
+			if timer != nil {
+				timer.Stop()
+			}
+			return spc.receive(p, pkt)
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-spc.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, nil, net.ErrClosed
+		}
 	}
 }
 
-// WriteTo writes a packet to the connection, applying network conditions.
+// receive copies a packet taken from the read queue into p. As with a real
+// socket, the part of the packet that does not fit is discarded.
+func (spc *simulatedPacketConn) receive(p []byte, pkt packet) (int, net.Addr, error) {
+	n := copy(p, pkt.data)
+	spc.stats.bytesReceived.Add(int64(n))
+
+	if n < len(pkt.data) {
+		spc.cfg.mu.Lock()
+		strict := spc.cfg.StrictDatagramTruncation
+		spc.cfg.mu.Unlock()
+		if strict {
+			return n, pkt.addr, fmt.Errorf("%w: read %d of %d bytes", ErrDatagramTruncated, n, len(pkt.data))
+		}
+	}
+	return n, pkt.addr, nil
+}
+
+// WriteTo writes a packet to the connection, applying outbound network conditions.
 func (spc *simulatedPacketConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
-	if spc.cfg.isPartitioned(addr.String()) {
-		return 0, fmt.Errorf("%w: unable to reach address: %s", ErrNetworkPartitioned, addr)
+	return spc.writeTo(p, addr, "")
+}
+
+// writeTo writes a packet to addr, applying outbound network conditions, with
+// the tag, if any, identifying the packet in callbacks. A packet is sent by
+// the underlying connection after its simulated delay, so a failure to send
+// it is returned by a later call, as a socket reports asynchronous errors.
+func (spc *simulatedPacketConn) writeTo(p []byte, addr net.Addr, tag string) (n int, err error) {
+	if err := spc.takeSendErr(); err != nil {
+		return 0, err
 	}
 
-	spc.enqueuePacket(packet{data: append([]byte(nil), p...), addr: addr})
+	if spc.cfg.isPartitionedFrom(spc.sources, addr.String()) {
+		// Sending to a group does not fail when its members are unreachable,
+		// so datagrams to a partitioned multicast or broadcast address are
+		// dropped, as they are on the wire, rather than returning an error.
+		if !spc.dropPartitioned && !isGroupAddr(addr) {
+			return 0, partitionedError(addr.String())
+		}
+		spc.stats.packetsSent.Add(1)
+		spc.stats.packetsDropped.Add(1)
+		spc.cfg.onDrop(addr, len(p), tag)
+		return len(p), nil
+	}
+
+	if !spc.accept() {
+		return 0, net.ErrClosed
+	}
+	defer spc.done()
+
+	spc.cfg.mu.Lock()
+	tooBig := spc.cfg.DontFragment && spc.cfg.MTU > 0 && len(p) > spc.cfg.MTU
+	spc.cfg.mu.Unlock()
+	if tooBig {
+		return 0, &net.OpError{
+			Op:     "write",
+			Net:    spc.LocalAddr().Network(),
+			Source: spc.LocalAddr(),
+			Addr:   addr,
+			Err:    os.NewSyscallError("sendto", syscall.EMSGSIZE),
+		}
+	}
+
+	index := int(spc.stats.packetsSent.Add(1) - 1)
+	data := spc.cfg.corrupt(append([]byte(nil), p...))
+	if lost := spc.enqueuePacket(packet{data: data, addr: addr, index: index, tag: tag}, outbound); lost {
+		spc.cfg.mu.Lock()
+		fail := spc.cfg.WriteErrorOnLoss
+		spc.cfg.mu.Unlock()
+		if fail {
+			return 0, ErrPacketDropped
+		}
+	}
 	return len(p), nil
 }
 
-// Close closes the connection.
+// Close closes the connection. Packets still being delayed are dropped.
 func (spc *simulatedPacketConn) Close() error {
-	close(spc.closed)
+	spc.closeOnce.Do(func() {
+		close(spc.closed)
+		spc.closeNAT()
+	})
 	return spc.conn.Close()
 }
 
+// CloseWithDrain stops accepting packets, waits for the packets still being
+// delayed to be delivered, and then closes the connection. Incoming packets
+// are dropped rather than delivered if the read queue is full, since nothing
+// may read them. If ctx is done first, the remaining packets are dropped and
+// the context's error is returned.
+func (spc *simulatedPacketConn) CloseWithDrain(ctx context.Context) error {
+	spc.mu.Lock()
+	select {
+	case <-spc.draining:
+	default:
+		close(spc.draining)
+	}
+	drained := make(chan struct{})
+	if spc.pending == 0 {
+		close(drained)
+	} else {
+		spc.drained = drained
+	}
+	spc.mu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closeErr := spc.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// accept records a packet entering the connection, reporting false if the
+// connection is draining and no longer accepts packets.
+func (spc *simulatedPacketConn) accept() bool {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+	select {
+	case <-spc.draining:
+		return false
+	default:
+	}
+	spc.pending++
+	return true
+}
+
+// begin records a packet being delivered, on behalf of a packet already
+// accepted, so that draining waits for it.
+func (spc *simulatedPacketConn) begin() {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+	spc.pending++
+}
+
+// done records that a packet has been delivered or dropped.
+func (spc *simulatedPacketConn) done() {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+	spc.pending--
+	if spc.pending == 0 && spc.drained != nil {
+		close(spc.drained)
+		spc.drained = nil
+	}
+}
+
 // LocalAddr returns the local network address.
 func (spc *simulatedPacketConn) LocalAddr() net.Addr {
 	return spc.conn.LocalAddr()
@@ -81,12 +335,24 @@ func (spc *simulatedPacketConn) LocalAddr() net.Addr {
 
 // SetDeadline sets the read and write deadlines.
 func (spc *simulatedPacketConn) SetDeadline(t time.Time) error {
-	return spc.conn.SetDeadline(t)
+	if err := spc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return spc.SetWriteDeadline(t)
 }
 
-// SetReadDeadline sets the read deadline.
+// SetReadDeadline sets the read deadline. Packets are received from the
+// underlying connection in the background, so the deadline applies to
+// ReadFrom rather than to the underlying connection.
 func (spc *simulatedPacketConn) SetReadDeadline(t time.Time) error {
-	return spc.conn.SetReadDeadline(t)
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+	spc.readDeadline = t
+
+	// Wake any blocked ReadFrom to wait for the new deadline.
+	close(spc.readChanged)
+	spc.readChanged = make(chan struct{})
+	return nil
 }
 
 // SetWriteDeadline sets the write deadline.
@@ -94,22 +360,29 @@ func (spc *simulatedPacketConn) SetWriteDeadline(t time.Time) error {
 	return spc.conn.SetWriteDeadline(t)
 }
 
-// readLoop reads packets from the underlying connection and enqueues them
-// to be processed with network conditions applied.
-func (spc *simulatedPacketConn) readLoop() {
+// readLoop reads packets from conn, the underlying connection or the socket
+// of a NAT mapping, and enqueues them to be processed with network
+// conditions applied.
+func (spc *simulatedPacketConn) readLoop(conn net.PacketConn) {
+	buf := getReadBuffer(spc.cfg.readBufferSize())
+	defer putReadBuffer(buf)
 	for {
 		select {
 		case <-spc.closed:
 			return
 		default:
-			buf := make([]byte, 65535) // Maximum UDP packet size (64 KiB)
-			n, addr, err := spc.conn.ReadFrom(buf)
-			if err != nil {
+			n, addr, err := conn.ReadFrom(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return // Closed out from under the simulation
+			}
+			if err != nil || !spc.isMapped(conn) {
 				continue
 			}
 
+			// Copy the data out of the read buffer, since the packet may
+			// be delivered asynchronously after the buffer is reused.
 			pkt := packet{
-				data: buf[:n],
+				data: spc.cfg.corrupt(append([]byte(nil), buf[:n]...)),
 				addr: addr,
 			}
 			spc.processIncomingPacket(pkt)
@@ -117,6 +390,31 @@ func (spc *simulatedPacketConn) readLoop() {
 	}
 }
 
+// readBufferPool holds read buffers of the default size, so that opening and
+// closing many packet conns does not allocate a large buffer for each.
+var readBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, defaultReadBufferSize)
+		return &buf
+	},
+}
+
+// getReadBuffer returns a read buffer of the given size, from the pool if it
+// is the default size.
+func getReadBuffer(size int) []byte {
+	if size != defaultReadBufferSize {
+		return make([]byte, size)
+	}
+	return *readBufferPool.Get().(*[]byte)
+}
+
+// putReadBuffer returns a read buffer to the pool once it is no longer used.
+func putReadBuffer(buf []byte) {
+	if len(buf) == defaultReadBufferSize {
+		readBufferPool.Put(&buf)
+	}
+}
+
 // writeLoop writes packets to the underlying connection with network conditions applied.
 func (spc *simulatedPacketConn) writeLoop() {
 	for {
@@ -125,86 +423,382 @@ func (spc *simulatedPacketConn) writeLoop() {
 			return
 		case pkt := <-spc.writeQueue:
 			spc.processOutgoingPacket(pkt)
+			spc.done()
 		}
 	}
 }
 
-// enqueuePacket enqueues a packet to be processed with network conditions applied.
-func (spc *simulatedPacketConn) enqueuePacket(pkt packet) {
+// enqueuePacket enqueues a packet to be processed with the network conditions
+// for the given direction applied, reporting whether the packet was lost for
+// good rather than retransmitted.
+func (spc *simulatedPacketConn) enqueuePacket(pkt packet, dir direction) (lost bool) {
+	cond := spc.cfg.conditions(dir, pkt.addr)
+
 	spc.cfg.mu.Lock()
-	defer spc.cfg.mu.Unlock()
+	// Scripted events apply to packets as they are first written.
+	var script scriptedOutcome
+	if dir == outbound && pkt.retries == 0 {
+		script = spc.cfg.scriptLocked(pkt.index)
+	}
+	// A fragmented datagram is lost if any of its fragments are, so loss is
+	// drawn for every fragment.
+	loss := false
+	for range fragments(spc.cfg.MTU, len(pkt.data)) {
+		loss = cond.loss(spc.rand) || loss
+	}
+	loss = loss || script.drop
+	if loss {
+		spc.stats.packetsDropped.Add(1)
+	}
+	var duplicates int
+	if !loss {
+		duplicates = spc.stats.duplicates(cond, spc.rand)
+		if script.duplicates > 0 {
+			duplicates += script.duplicates
+			spc.stats.packetsDuplicated.Add(int64(script.duplicates))
+		}
+	}
+	var reorder bool
+	switch {
+	case loss:
+	case cond.Reorder != nil:
+		if reorder = cond.Reorder.hold(spc.rand) || script.reorder; reorder {
+			spc.stats.packetsReordered.Add(1)
+		}
+	default:
+		reorder = spc.stats.reorder(cond, spc.rand)
+		if !reorder && script.reorder {
+			reorder = true
+			spc.stats.packetsReordered.Add(1)
+		}
+	}
+	// Simulate bit errors in a packet that is delivered.
+	if !loss {
+		cond.flipBits(pkt.data, spc.rand)
+	}
+	duplicateDelays := make([]time.Duration, duplicates)
+	for i := range duplicateDelays {
+		duplicateDelays[i] = cond.duplicateDelay(spc.rand)
+	}
+	spc.cfg.mu.Unlock()
 
 	// Simulate loss
-	if spc.simulateLoss() {
-		return // Drop the packet
+	if loss {
+		spc.cfg.onDrop(pkt.addr, len(pkt.data), pkt.tag)
+		return !spc.retransmit(pkt, dir) // Drop the packet
+	}
+
+	// Simulate the link buffer, which holds the packet and its duplicates
+	// until they are delivered.
+	if size, drop := spc.cfg.linkBuffer(); size > 0 {
+		lb := &spc.inLink
+		if dir == outbound {
+			lb = &spc.outLink
+		}
+		if !lb.reserve(len(pkt.data)*(1+len(duplicateDelays)), size, !drop, spc.closed) {
+			if !drop {
+				return false // Closed while waiting for room
+			}
+			spc.stats.packetsDropped.Add(1)
+			spc.cfg.onDrop(pkt.addr, len(pkt.data), pkt.tag)
+			return !spc.retransmit(pkt, dir)
+		}
+		pkt.linked = true
+	}
+
+	// Simulate duplication. Duplicates with an extra delay are delivered
+	// in the background, so that they trail the original.
+	for _, extra := range duplicateDelays {
+		spc.cfg.onDuplicate(pkt.addr, len(pkt.data))
+		spc.begin()
+		switch {
+		case extra == 0:
+			spc.deliverPacket(cond, pkt, dir)
+		case spc.scheduler(dir) != nil:
+			spc.deliverPacketAfter(cond, pkt, dir, extra)
+		default:
+			go spc.deliverPacketAfter(cond, pkt, dir, extra)
+		}
+	}
+
+	if reorder {
+		spc.cfg.onReorder(pkt.addr, len(pkt.data))
 	}
 
-	// Simulate duplication
-	if spc.simulateDuplication() {
-		spc.deliverPacket(pkt)
+	// Simulate bounded reordering
+	if cond.Reorder != nil && script.delay == 0 {
+		spc.deliverInOrder(cond, pkt, dir, reorder)
+		return false
 	}
 
-	// Simulate reordering
-	if spc.simulateReordering() {
+	// Simulate reordering and scripted delays
+	if reorder || script.delay > 0 {
+		// Hold the packet back by an additional delay, so that later
+		// packets may overtake it.
+		extra := script.delay
+		if reorder {
+			extra += spc.simulateLatency(cond, dir, 0)
+		}
+		spc.begin()
+		if spc.scheduler(dir) != nil {
+			spc.deliverPacketAfter(cond, pkt, dir, extra)
+		} else {
+			go spc.deliverPacketAfter(cond, pkt, dir, extra)
+		}
+	} else {
+		spc.begin()
+		spc.deliverPacket(cond, pkt, dir)
+	}
+	return false
+}
+
+// retransmit enqueues a lost packet again after the retransmission timeout,
+// if Config.MaxRetransmits allows, reporting whether it will be.
+func (spc *simulatedPacketConn) retransmit(pkt packet, dir direction) bool {
+	spc.cfg.mu.Lock()
+	maxRetransmits, timeout := spc.cfg.MaxRetransmits, spc.cfg.RetransmitTimeout
+	spc.cfg.mu.Unlock()
+	if pkt.retries >= maxRetransmits {
+		return false
+	}
+
+	pkt.retries++
+	spc.begin()
+	go func() {
+		defer spc.done()
+		select {
+		case <-spc.clock.After(timeout):
+			spc.enqueuePacket(pkt, dir)
+		case <-spc.closed:
+		}
+	}()
+	return true
+}
+
+// deliverInOrder delivers a packet through the hold buffer for the given
+// direction. A held packet is delivered once Gap later packets have been
+// delivered, or after the reorder timeout if traffic stops.
+func (spc *simulatedPacketConn) deliverInOrder(cond DirectionConfig, pkt packet, dir direction, hold bool) {
+	hb := &spc.inHold
+	if dir == outbound {
+		hb = &spc.outHold
+	}
+
+	if hold {
+		spc.begin()
+		h := hb.hold(pkt, cond.Reorder.Gap)
 		go func() {
-			time.Sleep(spc.simulateLatency(len(pkt.data)))
-			spc.deliverPacket(pkt)
+			select {
+			case <-spc.clock.After(cond.Reorder.timeout()):
+				if hb.release(h) {
+					spc.deliverPacket(cond, pkt, dir)
+				}
+			case <-spc.closed:
+				if hb.release(h) {
+					spc.done()
+				}
+			}
 		}()
-	} else {
-		spc.deliverPacket(pkt)
+		return
+	}
+
+	spc.begin()
+	spc.deliverPacket(cond, pkt, dir)
+	for _, due := range hb.pass() {
+		spc.deliverPacket(cond, due, dir)
+	}
+}
+
+// fragments returns the number of fragments a datagram of n bytes is split
+// into on a link with the given MTU.
+func fragments(mtu, n int) int {
+	if mtu <= 0 || n <= mtu {
+		return 1
+	}
+	return (n + mtu - 1) / mtu
+}
+
+// deliverPacket delivers a packet after applying network conditions, to the
+// read queue for inbound packets or the write queue for outbound packets.
+// In in-order mode it is delivered no earlier than the packets delivered
+// before it. The caller must have recorded the delivery with begin.
+func (spc *simulatedPacketConn) deliverPacket(cond DirectionConfig, pkt packet, dir direction) {
+	if !spc.inOrder {
+		spc.deliverPacketAfter(cond, pkt, dir, 0)
+		return
 	}
+
+	delay := spc.simulateLatency(cond, dir, len(pkt.data))
+	spc.cfg.onDelay(pkt.addr, len(pkt.data), delay)
+	spc.scheduler(dir).scheduleInOrder(delay, func() {
+		spc.queuePacket(pkt, dir)
+	})
 }
 
-// deliverPacket delivers a packet to the read queue after applying network conditions.
-func (spc *simulatedPacketConn) deliverPacket(pkt packet) {
-	time.Sleep(spc.simulateLatency(len(pkt.data)))
+// deliverPacketAfter delivers a packet like deliverPacket, after an
+// additional delay, without regard for the order of other packets. In
+// deterministic or in-order mode the delay is applied by the direction's
+// scheduler rather than by sleeping. Closing the connection abandons the
+// delay, so delivery goroutines do not outlive it.
+func (spc *simulatedPacketConn) deliverPacketAfter(cond DirectionConfig, pkt packet, dir direction, extra time.Duration) {
+	delay := extra + spc.simulateLatency(cond, dir, len(pkt.data))
+	spc.cfg.onDelay(pkt.addr, len(pkt.data), delay)
+	if sched := spc.scheduler(dir); sched != nil {
+		sched.schedule(delay, func() {
+			spc.queuePacket(pkt, dir)
+		})
+		return
+	}
+
+	select {
+	case <-spc.clock.After(delay):
+	case <-spc.closed:
+		spc.done()
+		return
+	}
+	spc.queuePacket(pkt, dir)
+}
+
+// scheduler returns the scheduler for the given direction, or nil if
+// delivery is neither deterministic nor in order.
+func (spc *simulatedPacketConn) scheduler(dir direction) *scheduler {
+	if dir == outbound {
+		return spc.outSched
+	}
+	return spc.inSched
+}
+
+// queuePacket hands a delivered packet to the read queue for inbound packets
+// or the write queue for outbound packets.
+func (spc *simulatedPacketConn) queuePacket(pkt packet, dir direction) {
+	if pkt.linked {
+		if dir == outbound {
+			spc.outLink.release(len(pkt.data))
+		} else {
+			spc.inLink.release(len(pkt.data))
+		}
+	}
+
+	if dir == outbound {
+		// The packet is done once the write loop has sent it.
+		select {
+		case spc.writeQueue <- pkt:
+		case <-spc.closed:
+			spc.done()
+		}
+		return
+	}
+
+	defer spc.done()
+
+	spc.cfg.mu.Lock()
+	tailDrop := spc.cfg.TailDrop
+	spc.cfg.mu.Unlock()
+	if tailDrop {
+		select {
+		case spc.readQueue <- pkt:
+		default:
+			spc.stats.packetsOverflowed.Add(1)
+		}
+		return
+	}
+
 	select {
 	case spc.readQueue <- pkt:
+	case <-spc.draining:
+		// Drop the packet rather than wait for a reader that may never
+		// come, unless there is room for it.
+		select {
+		case spc.readQueue <- pkt:
+		default:
+		}
 	case <-spc.closed:
 	}
 }
 
 // processIncomingPacket processes an incoming packet with network conditions applied.
+// Packets from partitioned addresses are dropped, since partitions apply in
+// both directions.
 func (spc *simulatedPacketConn) processIncomingPacket(pkt packet) {
-	spc.enqueuePacket(pkt)
+	if spc.cfg.isPartitionedFrom(spc.sources, pkt.addr.String()) {
+		return
+	}
+	if !spc.accept() {
+		return
+	}
+	defer spc.done()
+	spc.enqueuePacket(pkt, inbound)
 }
 
-// processOutgoingPacket processes an outgoing packet with network conditions applied.
+// processOutgoingPacket sends an outgoing packet that has already had network
+// conditions applied.
 func (spc *simulatedPacketConn) processOutgoingPacket(pkt packet) {
 	// Simulate sending the packet
-	_, err := spc.conn.WriteTo(pkt.data, pkt.addr)
+	n, err := spc.sendConn().WriteTo(pkt.data, pkt.addr)
+	spc.stats.bytesSent.Add(int64(n))
 	if err != nil {
-		// Handle error?
+		spc.mu.Lock()
+		spc.sendErr = &Error{Err: fmt.Errorf("%w: %w", ErrSendFailed, err)}
+		// Wake any blocked ReadFrom to report the error.
+		close(spc.readChanged)
+		spc.readChanged = make(chan struct{})
+		spc.mu.Unlock()
+
+		spc.cfg.onSendError(pkt.addr, len(pkt.data), err)
 	}
 }
 
-// simulateLatency simulates network latency based on the configuration.
-func (spc *simulatedPacketConn) simulateLatency(n int) time.Duration {
-	latency := spc.cfg.Latency
-	if spc.cfg.Jitter > 0 {
-		jitter := time.Duration(spc.rand.Int63n(int64(spc.cfg.Jitter)))
-		latency += jitter
-	}
-	if spc.cfg.Bandwidth > 0 && n > 0 {
-		transferTime := time.Duration(float64(n) / float64(spc.cfg.Bandwidth) * float64(time.Second))
-		latency += transferTime
-	}
-	return latency
+// takeSendErr returns the last failure to send a packet, if it has not been
+// reported yet, and clears it.
+func (spc *simulatedPacketConn) takeSendErr() error {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+	err := spc.sendErr
+	spc.sendErr = nil
+	return err
 }
 
-// simulateLoss determines if a packet should be dropped based on the loss rate.
-func (spc *simulatedPacketConn) simulateLoss() bool {
-	return spc.cfg.LossRate > 0 && spc.rand.Float64() < spc.cfg.LossRate
+// simulateLatency simulates network latency and bandwidth limitations for a
+// datagram of n bytes travelling in the given direction. A datagram larger
+// than the MTU is sent as fragments, one after another, each taking its own
+// share of the bandwidth, and it arrives once its last fragment does.
+func (spc *simulatedPacketConn) simulateLatency(cond DirectionConfig, dir direction, n int) time.Duration {
+	spc.cfg.mu.Lock()
+	latency := cond.latency(spc.rand)
+	mtu := spc.cfg.MTU
+	spc.cfg.mu.Unlock()
+
+	b := spc.inBucket
+	if dir == outbound {
+		b = spc.outBucket
+	}
+	var wait time.Duration
+	for i := range fragments(mtu, n) {
+		size := n
+		if mtu > 0 {
+			size = min(mtu, n-i*mtu)
+		}
+		wait = b.take(cond, size)
+	}
+	delay := latency + wait
+	spc.stats.delay(delay)
+	return delay
 }
 
-// simulateReordering determines if a packet should be reordered based on the reorder rate.
-func (spc *simulatedPacketConn) simulateReordering() bool {
-	return spc.cfg.ReorderRate > 0 && spc.rand.Float64() < spc.cfg.ReorderRate
+// Stats returns the runtime statistics collected for the connection.
+func (spc *simulatedPacketConn) Stats() Stats {
+	return spc.stats.snapshot()
 }
 
-// simulateDuplication determines if a packet should be duplicated based on the duplicate rate.
-func (spc *simulatedPacketConn) simulateDuplication() bool {
-	return spc.cfg.DuplicateRate > 0 && spc.rand.Float64() < spc.cfg.DuplicateRate
+// WrapPacketConn wraps an existing packet conn with simulated network
+// conditions, for packet conns obtained other than through UDPConn, such as
+// from a library. The returned conn takes ownership of conn, and implements
+// StatsProvider. A nil cfg applies no conditions.
+func WrapPacketConn(conn net.PacketConn, cfg *Config) net.PacketConn {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	return newSimulatedPacketConn(conn, cfg)
 }
 
 // UDPConn creates a simulated UDP connection.
@@ -218,7 +812,100 @@ func UDPConn(cfg *Config, laddr, raddr *net.UDPAddr) (net.PacketConn, error) {
 		return nil, err
 	}
 
-	rand := cfg.randSource()
-	spc := newSimulatedPacketConn(conn, cfg, rand)
+	spc := newSimulatedPacketConn(conn, cfg)
 	return spc, nil
 }
+
+// DialUDP creates a simulated UDP connection connected to raddr, like
+// net.DialUDP. Network conditions apply to each datagram written or read, so
+// unlike a stream a lost datagram is never delivered. Dialing a partitioned
+// address fails with ErrNetworkPartitioned.
+//
+// The returned conn also implements net.PacketConn, although writes are
+// always sent to raddr.
+func DialUDP(cfg *Config, laddr, raddr *net.UDPAddr) (net.Conn, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	if cfg.isPartitionedFrom(nil, raddr.String()) {
+		return nil, partitionedError(raddr.String())
+	}
+
+	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		return nil, dialError(err)
+	}
+
+	return &udpConn{
+		simulatedPacketConn: newSimulatedPacketConn(connectedUDPConn{conn}, cfg),
+		raddr:               raddr,
+	}, nil
+}
+
+// udpConn is a simulated packet conn connected to a remote address. It
+// implements both net.Conn and net.PacketConn, so that it can be used where
+// either is expected, such as by a resolver exchanging messages with a DNS
+// server.
+type udpConn struct {
+	*simulatedPacketConn
+	raddr *net.UDPAddr
+}
+
+// Read reads a datagram from the remote address, waiting until the read
+// deadline.
+func (c *udpConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// Write writes a datagram to the remote address.
+func (c *udpConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.raddr)
+}
+
+// WriteTagged writes a datagram to the remote address like Write, reporting
+// the tag to the OnDropTagged callback if the datagram is lost.
+func (c *udpConn) WriteTagged(b []byte, tag string) (int, error) {
+	return c.writeTo(b, c.raddr, tag)
+}
+
+// RemoteAddr returns the remote network address.
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// IsPartitioned reports whether the remote address is currently partitioned,
+// implementing PartitionReporter.
+func (c *udpConn) IsPartitioned() bool {
+	return c.cfg.isPartitionedFrom(c.sources, c.raddr.String())
+}
+
+// connectedUDPConn adapts a connected UDP socket, which cannot be written to
+// with WriteTo, to the packet conn the simulation writes to.
+type connectedUDPConn struct {
+	*net.UDPConn
+}
+
+// WriteTo writes a datagram to the connected address, ignoring addr.
+func (c connectedUDPConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+// isGroupAddr reports whether addr is a multicast address or the limited
+// broadcast address, which datagrams are sent to a group of hosts through.
+func isGroupAddr(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		ip = net.ParseIP(host)
+	}
+	return ip.IsMulticast() || ip.Equal(net.IPv4bcast)
+}
@@ -1,13 +1,24 @@
 package simnet_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/picatz/simnet"
 	"github.com/shoenig/test/must"
 	"github.com/shoenig/test/portal"
+	"github.com/shoenig/test/wait"
 )
 
 func ExampleUDPConn() {
@@ -31,10 +42,19 @@ func ExampleUDPConn() {
 		Port: 8081,
 	}
 
+	// Start a peer that echoes datagrams back to the sender
+	peer, err := net.ListenUDP("udp", remoteAddr)
+	if err != nil {
+		panic(err)
+	}
+	defer peer.Close()
+	go echoUDP(peer)
+
 	conn, err := simnet.UDPConn(cfg, localAddr, remoteAddr)
 	if err != nil {
 		panic(err)
 	}
+	defer conn.Close()
 
 	_, err = conn.WriteTo([]byte("Hello, simnet!"), remoteAddr)
 	if err != nil {
@@ -53,25 +73,50 @@ func ExampleUDPConn() {
 	// Hello, simnet! 127.0.0.1:8081
 }
 
+// echoUDP echoes datagrams received on conn back to their sender until
+// conn is closed.
+func echoUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
+
 func TestUDPConn(t *testing.T) {
-	checkConnBascis := func(t *testing.T, conn net.PacketConn) {
-		_, err := conn.WriteTo([]byte("Hello, simnet!"), &net.UDPAddr{
-			IP:   net.IPv4(127, 0, 0, 1),
-			Port: 8081,
-		})
-		must.NoError(t, err)
+	checkConnBascis := func(t *testing.T, conn net.PacketConn, remoteAddr *net.UDPAddr) {
+		// Retransmit until the echo arrives, since simulated loss applies
+		// to both the request and the response.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				_, err := conn.WriteTo([]byte("Hello, simnet!"), remoteAddr)
+				if err != nil {
+					return
+				}
+				select {
+				case <-done:
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+		}()
 
 		buf := make([]byte, 1024)
 		n, addr, err := conn.ReadFrom(buf)
 		must.NoError(t, err)
 		must.Eq(t, n, 14)
-		must.Eq(t, addr.String(), "127.0.0.1:8081")
+		must.Eq(t, addr.String(), remoteAddr.String())
 	}
 
 	tests := []struct {
 		name  string
 		cfg   *simnet.Config
-		check func(*testing.T, net.PacketConn)
+		check func(*testing.T, net.PacketConn, *net.UDPAddr)
 	}{
 		{
 			name:  "no network config",
@@ -163,6 +208,13 @@ func TestUDPConn(t *testing.T) {
 				Port: ports[1],
 			}
 
+			peer, err := net.ListenUDP("udp", remoteAddr)
+			must.NoError(t, err)
+			t.Cleanup(func() {
+				peer.Close()
+			})
+			go echoUDP(peer)
+
 			conn, err := simnet.UDPConn(test.cfg, localAddr, remoteAddr)
 			must.NoError(t, err)
 			t.Cleanup(func() {
@@ -170,7 +222,1093 @@ func TestUDPConn(t *testing.T) {
 				must.NoError(t, err)
 			})
 
-			test.check(t, conn)
+			test.check(t, conn, remoteAddr)
+		})
+	}
+}
+
+func TestUDPConnConcurrentPayloads(t *testing.T) {
+	const packets = 200
+
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Millisecond),
+		simnet.WithJitter(5*time.Millisecond),
+		simnet.WithLossRate(0.1),
+		simnet.WithReorderRate(0.3),
+		simnet.WithDuplicateRate(0.1),
+		simnet.WithSeed(42),
+	)
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// Each payload is filled with its own index, and its length is derived
+	// from the index, so any corruption or mixing is detectable.
+	go func() {
+		for i := range packets {
+			payload := bytes.Repeat([]byte{byte(i)}, 100+i)
+			peer.WriteTo(payload, conn.LocalAddr())
+		}
+	}()
+
+	received := make(chan []byte)
+	go func() {
+		defer close(received)
+		for {
+			buf := make([]byte, 65535)
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- buf[:n]
+		}
+	}()
+
+	var count int
+	timeout := time.NewTimer(time.Second)
+	for done := false; !done; {
+		select {
+		case payload := <-received:
+			count++
+			must.Eq(t, 100+int(payload[0]), len(payload))
+			must.Eq(t, bytes.Repeat(payload[:1], len(payload)), payload)
+			timeout.Reset(time.Second)
+		case <-timeout.C:
+			done = true
+		}
+	}
+
+	must.NoError(t, conn.Close())
+	for range received {
+	}
+
+	must.Positive(t, count)
+}
+
+func TestUDPConnPartitionedReads(t *testing.T) {
+	partitioned, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		partitioned.Close()
+	})
+
+	reachable, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		reachable.Close()
+	})
+
+	cfg := simnet.NewConfig()
+	cfg.AddPartition(partitioned.LocalAddr().String())
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// The partitioned peer sends first, so its datagram would be read
+	// first if it were not dropped.
+	_, err = partitioned.WriteTo([]byte("partitioned"), conn.LocalAddr())
+	must.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = reachable.WriteTo([]byte("reachable"), conn.LocalAddr())
+	must.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	n, addr, err := conn.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, "reachable", string(buf[:n]))
+	must.Eq(t, reachable.LocalAddr().String(), addr.String())
+}
+
+func TestUDPConnPartitionGroups(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// The conn is bound to the unspecified address, so it is reachable at
+	// the loopback address on its port.
+	cfg := simnet.NewConfig()
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4zero}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	self := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	cfg.PartitionGroups([]string{self}, []string{peer.LocalAddr().String()})
+
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+
+	// Datagrams from the other group are dropped.
+	_, err = peer.WriteTo([]byte("partitioned"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	must.NoError(t, err)
+
+	read := make(chan struct{})
+	go func() {
+		conn.ReadFrom(make([]byte, 1024))
+		close(read)
+	}()
+
+	select {
+	case <-read:
+		t.Fatal("expected datagram from the other group to be dropped")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cfg.HealPartition()
+
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.NoError(t, err)
+}
+
+func TestUDPConnPartitionedGroup(t *testing.T) {
+	var dropped atomic.Int32
+	cfg := simnet.NewConfig(simnet.WithOnDrop(func(addr net.Addr, size int) {
+		dropped.Add(1)
+	}))
+	cfg.AddPartition("224.0.0.1")
+	cfg.AddPartition("255.255.255.255")
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// Datagrams sent to a partitioned group are dropped rather than failing
+	// the write, as no member is reachable.
+	for _, ip := range []net.IP{net.IPv4(224, 0, 0, 1), net.IPv4bcast} {
+		n, err := conn.WriteTo([]byte("ping"), &net.UDPAddr{IP: ip, Port: 9999})
+		must.NoError(t, err)
+		must.Eq(t, 4, n)
+	}
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, int64(2), stats.PacketsSent)
+	must.Eq(t, int64(2), stats.PacketsDropped)
+	must.Eq(t, int32(2), dropped.Load())
+}
+
+func TestWrapPacketConn(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	go echoUDP(peer)
+
+	inner, err := net.ListenPacket("udp", "127.0.0.1:0")
+	must.NoError(t, err)
+	conn := simnet.WrapPacketConn(inner, simnet.NewConfig(simnet.WithLatency(latency)))
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// The datagram is delayed on its way out and on its way back.
+	start := time.Now()
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.NoError(t, err)
+	buf := make([]byte, 1024)
+	n, addr, err := conn.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf[:n]))
+	must.Eq(t, peer.LocalAddr().String(), addr.String())
+	must.Between(t, 2*latency, time.Since(start), 2*latency+500*time.Millisecond)
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, int64(1), stats.PacketsSent)
+}
+
+func TestUDPConnSendError(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	failed := make(chan error, 2)
+	inner, err := net.ListenPacket("udp", "127.0.0.1:0")
+	must.NoError(t, err)
+	conn := simnet.WrapPacketConn(inner, simnet.NewConfig(
+		simnet.WithOnSendError(func(addr net.Addr, size int, err error) {
+			must.Eq(t, peer.LocalAddr().String(), addr.String())
+			must.Eq(t, 4, size)
+			failed <- err
+		}),
+	))
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// Close the socket out from under the simulation. The write is accepted
+	// before the packet is sent, so the failure is reported later.
+	must.NoError(t, inner.Close())
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.NoError(t, err)
+	must.ErrorIs(t, <-failed, net.ErrClosed)
+
+	// A blocked read reports the failure.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadFrom(make([]byte, 16))
+	must.ErrorIs(t, err, simnet.ErrSendFailed)
+	must.ErrorIs(t, err, net.ErrClosed)
+	var simErr *simnet.Error
+	must.True(t, errors.As(err, &simErr))
+
+	// The failure is reported once, by whichever call comes next.
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.NoError(t, err)
+	must.ErrorIs(t, <-failed, net.ErrClosed)
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.ErrorIs(t, err, simnet.ErrSendFailed)
+}
+
+func TestUDPConnInOrderDelivery(t *testing.T) {
+	const packets = 50
+
+	// send writes numbered packets with heavy jitter, returning the order
+	// they are received in.
+	send := func(t *testing.T, opts ...simnet.Option) []int {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		cfg := simnet.NewConfig(append(opts,
+			simnet.WithLatency(time.Millisecond),
+			simnet.WithJitter(50*time.Millisecond),
+			simnet.WithSeed(1),
+		)...)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		for i := range packets {
+			_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+		order := make([]int, 0, packets)
+		buf := make([]byte, 16)
+		for range packets {
+			n, err := peer.Read(buf)
+			must.NoError(t, err)
+			i, err := strconv.Atoi(string(buf[:n]))
+			must.NoError(t, err)
+			order = append(order, i)
+		}
+		return order
+	}
+
+	// Scheduled independently, jitter lets later packets overtake earlier
+	// ones even without reordering.
+	must.False(t, slices.IsSorted(send(t, simnet.WithDeterministic(true))))
+
+	// In order, each packet waits for those sent ahead of it.
+	must.True(t, slices.IsSorted(send(t, simnet.WithInOrderDelivery(true))))
+	must.True(t, slices.IsSorted(send(t, simnet.WithInOrderDelivery(true), simnet.WithDeterministic(true))))
+}
+
+func TestUDPConnMTU(t *testing.T) {
+	const (
+		datagrams = 1000
+		lossRate  = 0.1
+	)
+
+	// dropRate sends large datagrams and returns the fraction dropped.
+	dropRate := func(t *testing.T, opts ...simnet.Option) float64 {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
 		})
+
+		cfg := simnet.NewConfig(append(opts, simnet.WithLossRate(lossRate), simnet.WithSeed(42))...)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		payload := make([]byte, 9000)
+		for range datagrams {
+			_, err := conn.WriteTo(payload, peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		stats := conn.(simnet.StatsProvider).Stats()
+		return float64(stats.PacketsDropped) / float64(stats.PacketsSent)
 	}
+
+	// Without an MTU, loss applies to the datagram as a whole.
+	must.Between(t, 0.07, dropRate(t), 0.13)
+
+	// Over a 1500-byte MTU the datagram is split into 6 fragments, so it
+	// is dropped with probability 1-(1-0.1)^6, or about 0.47.
+	must.Between(t, 0.42, dropRate(t, simnet.WithMTU(1500)), 0.52)
+}
+
+func TestUDPConnMTUBandwidth(t *testing.T) {
+	const bandwidth = 100_000
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// transmit sends a datagram of the given size on a new connection,
+	// returning the delay it was given.
+	transmit := func(t *testing.T, size int) time.Duration {
+		delays := make(chan time.Duration, 1)
+		cfg := simnet.NewConfig(
+			simnet.WithBandwidth(bandwidth),
+			simnet.WithBurst(1500),
+			simnet.WithMTU(1500),
+			simnet.WithOnDelay(func(addr net.Addr, size int, d time.Duration) {
+				delays <- d
+			}),
+		)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.WriteTo(make([]byte, size), peer.LocalAddr())
+		must.NoError(t, err)
+		return <-delays
+	}
+
+	// A datagram within the burst is sent at once.
+	must.Eq(t, 0, transmit(t, 100))
+
+	// The 6 fragments of an 8000-byte datagram are sent one after another,
+	// the first from the burst and the other 6500 bytes at 100KB/s.
+	must.Between(t, 60*time.Millisecond, transmit(t, 8000), 65*time.Millisecond)
+}
+
+func TestUDPConnDontFragment(t *testing.T) {
+	const mtu = 1500
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(simnet.WithMTU(mtu), simnet.WithDontFragment(true))
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// A datagram larger than the MTU is rejected rather than fragmented.
+	n, err := conn.WriteTo(make([]byte, mtu+1), peer.LocalAddr())
+	must.ErrorIs(t, err, syscall.EMSGSIZE)
+	must.Zero(t, n)
+	var opErr *net.OpError
+	must.True(t, errors.As(err, &opErr))
+	must.Eq(t, "write", opErr.Op)
+
+	// One that fits the MTU is sent.
+	n, err = conn.WriteTo(make([]byte, mtu), peer.LocalAddr())
+	must.NoError(t, err)
+	must.Eq(t, mtu, n)
+
+	must.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 2*mtu)
+	n, _, err = peer.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, mtu, n)
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, 1, stats.PacketsSent)
+}
+
+func TestUDPConnReadBufferSize(t *testing.T) {
+	const size = 9000
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(simnet.WithReadBufferSize(size))
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	must.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	// A datagram of the configured size is read whole.
+	payload := bytes.Repeat([]byte("jumbo"), size/5)
+	_, err = peer.WriteTo(payload, conn.LocalAddr())
+	must.NoError(t, err)
+
+	buf := make([]byte, 2*size)
+	n, _, err := conn.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, payload, buf[:n])
+
+	// A larger one is truncated to the configured size.
+	_, err = peer.WriteTo(append(payload, '!'), conn.LocalAddr())
+	must.NoError(t, err)
+
+	n, _, err = conn.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, payload, buf[:n])
+}
+
+func TestUDPConnCorrupt(t *testing.T) {
+	const (
+		datagrams = 100
+		every     = 4 // Corrupt one datagram in every 4
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// Flip the first byte of every fourth datagram.
+	var calls atomic.Int64
+	cfg := simnet.NewConfig(simnet.WithCorruptFunc(func(data []byte) []byte {
+		if calls.Add(1)%every == 0 {
+			data[0] ^= 0xff
+		}
+		return data
+	}))
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	payload := []byte("ping")
+	for range datagrams {
+		_, err := conn.WriteTo(payload, peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	// The caller's buffer is left alone, while the datagrams delivered
+	// are corrupted at the configured rate.
+	must.Eq(t, "ping", string(payload))
+
+	must.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	var corrupted int
+	buf := make([]byte, 16)
+	for range datagrams {
+		n, _, err := peer.ReadFrom(buf)
+		must.NoError(t, err)
+		switch string(buf[:n]) {
+		case "ping":
+		case "\x8fing":
+			corrupted++
+		default:
+			t.Fatalf("unexpected datagram %q", buf[:n])
+		}
+	}
+	must.Eq(t, datagrams/every, corrupted)
+}
+
+func TestUDPConnWriteErrorOnLoss(t *testing.T) {
+	const (
+		datagrams = 1000
+		lossRate  = 0.2
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// writeErrors sends datagrams and returns how many writes failed and
+	// how many packets were dropped.
+	writeErrors := func(t *testing.T, opts ...simnet.Option) (int, int) {
+		cfg := simnet.NewConfig(append(opts, simnet.WithLossRate(lossRate), simnet.WithSeed(42))...)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		var failed int
+		for range datagrams {
+			n, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+			if err != nil {
+				must.ErrorIs(t, err, simnet.ErrPacketDropped)
+				must.Zero(t, n)
+				failed++
+			}
+		}
+		return failed, int(conn.(simnet.StatsProvider).Stats().PacketsDropped)
+	}
+
+	// Lost packets are dropped silently by default.
+	failed, dropped := writeErrors(t)
+	must.Zero(t, failed)
+	must.Positive(t, dropped)
+
+	// Otherwise every lost packet fails its write.
+	failed, dropped = writeErrors(t, simnet.WithWriteErrorOnLoss(true))
+	must.Eq(t, dropped, failed)
+	must.Between(t, 170, failed, 230)
+}
+
+func TestUDPConnReadDeadline(t *testing.T) {
+	const latency = 100 * time.Millisecond
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{Latency: latency}))
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	_, err = peer.WriteTo([]byte("ping"), conn.LocalAddr())
+	must.NoError(t, err)
+
+	// The packet is still delayed when a deadline shorter than the latency
+	// passes.
+	buf := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(latency / 2))
+	_, _, err = conn.ReadFrom(buf)
+	must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	var netErr net.Error
+	must.True(t, errors.As(err, &netErr))
+	must.True(t, netErr.Timeout())
+
+	// A deadline longer than the latency leaves time for it to arrive.
+	conn.SetReadDeadline(time.Now().Add(2 * latency))
+	n, _, err := conn.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, "ping", string(buf[:n]))
+
+	t.Run("no traffic", func(t *testing.T) {
+		const timeout = 50 * time.Millisecond
+
+		conn, err := simnet.UDPConn(simnet.NewConfig(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		// A read with nothing arriving is unblocked by its deadline.
+		start := time.Now()
+		conn.SetReadDeadline(start.Add(timeout))
+		_, _, err = conn.ReadFrom(make([]byte, 16))
+		must.Between(t, timeout, time.Since(start), timeout+time.Second)
+		must.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		var netErr net.Error
+		must.True(t, errors.As(err, &netErr))
+		must.True(t, netErr.Timeout())
+	})
+}
+
+func TestDialUDP(t *testing.T) {
+	const (
+		datagrams = 1000
+		lossRate  = 0.2
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	raddr := peer.LocalAddr().(*net.UDPAddr)
+
+	t.Run("read and write", func(t *testing.T) {
+		conn, err := simnet.DialUDP(simnet.NewConfig(), nil, raddr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		must.Eq(t, raddr.String(), conn.RemoteAddr().String())
+
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := peer.ReadFrom(buf)
+		must.NoError(t, err)
+		must.Eq(t, "ping", string(buf[:n]))
+
+		_, err = peer.WriteTo([]byte("pong"), addr)
+		must.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err = conn.Read(buf)
+		must.NoError(t, err)
+		must.Eq(t, "pong", string(buf[:n]))
+	})
+
+	t.Run("datagram loss", func(t *testing.T) {
+		cfg := simnet.NewConfig(simnet.WithLossRate(lossRate), simnet.WithSeed(42))
+		conn, err := simnet.DialUDP(cfg, nil, raddr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		// The peer reads concurrently, so that its socket buffer does not
+		// overflow, until no datagram arrives for a while.
+		received := make(chan int)
+		go func() {
+			n := 0
+			buf := make([]byte, 16)
+			for {
+				peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				if _, _, err := peer.ReadFrom(buf); err != nil {
+					received <- n
+					return
+				}
+				n++
+			}
+		}()
+
+		// Each datagram is delivered whole or not at all. Writes are paced
+		// so that the peer keeps up.
+		for i := range datagrams {
+			_, err := conn.Write([]byte(strconv.Itoa(i)))
+			must.NoError(t, err)
+			if i%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		stats := conn.(simnet.StatsProvider).Stats()
+		must.Between(t, 170, stats.PacketsDropped, 230)
+		must.Eq(t, datagrams-int(stats.PacketsDropped), <-received)
+	})
+
+	t.Run("partitioned address", func(t *testing.T) {
+		cfg := simnet.NewConfig()
+		cfg.AddPartition(raddr.String())
+
+		_, err := simnet.DialUDP(cfg, nil, raddr)
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+	})
+}
+
+func TestUDPConnTruncation(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// receive sends a 2000-byte datagram followed by a short one, and reads
+	// both into a 100-byte buffer.
+	receive := func(t *testing.T, opts ...simnet.Option) (first, second []byte, err error) {
+		conn, err := simnet.UDPConn(simnet.NewConfig(opts...), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		_, err = peer.WriteTo(bytes.Repeat([]byte("a"), 2000), conn.LocalAddr())
+		must.NoError(t, err)
+		_, err = peer.WriteTo([]byte("next"), conn.LocalAddr())
+		must.NoError(t, err)
+
+		buf := make([]byte, 100)
+		n, _, err := conn.ReadFrom(buf)
+		first = append(first, buf[:n]...)
+
+		n, _, secondErr := conn.ReadFrom(buf)
+		must.NoError(t, secondErr)
+		return first, buf[:n], err
+	}
+
+	// The rest of a datagram that does not fit is discarded, rather than
+	// returned by the next read.
+	first, second, err := receive(t)
+	must.NoError(t, err)
+	must.Eq(t, bytes.Repeat([]byte("a"), 100), first)
+	must.Eq(t, "next", string(second))
+
+	first, second, err = receive(t, simnet.WithStrictDatagramTruncation(true))
+	must.ErrorIs(t, err, simnet.ErrDatagramTruncated)
+	must.Eq(t, bytes.Repeat([]byte("a"), 100), first)
+	must.Eq(t, "next", string(second))
+}
+
+func TestUDPConnTailDrop(t *testing.T) {
+	const (
+		packets   = 200
+		queueSize = 4
+	)
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	baseline := runtime.NumGoroutine()
+
+	// Every packet is reordered, so each is delivered by its own goroutine,
+	// and nothing reads them.
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(10*time.Millisecond),
+		simnet.WithReorderRate(1.0),
+		simnet.WithQueueSize(queueSize),
+		simnet.WithTailDrop(true),
+	)
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for i := range packets {
+		_, err := peer.WriteTo([]byte(strconv.Itoa(i)), conn.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	// Packets that do not fit in the queue are dropped, rather than their
+	// goroutines waiting for room.
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			return conn.(simnet.StatsProvider).Stats().PacketsOverflowed == packets-queueSize
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			// The conn's own read and write loops remain.
+			return runtime.NumGoroutine() <= baseline+2
+		}),
+		wait.Timeout(time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	// The queued packets can still be read.
+	buf := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for range queueSize {
+		_, _, err := conn.ReadFrom(buf)
+		must.NoError(t, err)
+	}
+}
+
+func TestUDPConnMaxDuplicates(t *testing.T) {
+	const packets = 10
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(
+		simnet.WithDuplicateRate(1.0),
+		simnet.WithMaxDuplicates(4),
+		simnet.WithSeed(42),
+	)
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for i := range packets {
+		_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	copies := make(map[string]int)
+	buf := make([]byte, 16)
+	for {
+		peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := peer.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		copies[string(buf[:n])]++
+	}
+
+	// Each packet arrives with between one and four duplicates, the same
+	// number every time for the seed.
+	total := 0
+	for i := range packets {
+		must.Between(t, 2, copies[strconv.Itoa(i)], 5)
+		total += copies[strconv.Itoa(i)]
+	}
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, int64(total-packets), stats.PacketsDuplicated)
+	must.Eq(t, 34, total)
+}
+
+func TestUDPConnDuplicateDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(
+		simnet.WithDuplicateRate(1.0),
+		simnet.WithDuplicateDelay(delay),
+	)
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+	must.NoError(t, err)
+
+	buf := make([]byte, 16)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = peer.ReadFrom(buf)
+	must.NoError(t, err)
+	original := time.Now()
+
+	// The duplicate trails the original by at least half the delay.
+	_, _, err = peer.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Between(t, delay/2, time.Since(original), delay+100*time.Millisecond)
+}
+
+func TestUDPConnAutoRetransmit(t *testing.T) {
+	const (
+		packets  = 400
+		lossRate = 0.5
+	)
+
+	// deliveryRate sends packets over a lossy link and returns the fraction
+	// the peer receives.
+	deliveryRate := func(t *testing.T, maxRetries int) float64 {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		cfg := simnet.NewConfig(
+			simnet.WithLossRate(lossRate),
+			simnet.WithAutoRetransmit(maxRetries, 10*time.Millisecond),
+			simnet.WithSeed(42),
+		)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		// The peer reads concurrently, until no packet arrives for a
+		// while after the retransmissions.
+		received := make(chan int)
+		go func() {
+			n := 0
+			buf := make([]byte, 16)
+			for {
+				peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				if _, _, err := peer.ReadFrom(buf); err != nil {
+					received <- n
+					return
+				}
+				n++
+			}
+		}()
+
+		for i := range packets {
+			_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+			must.NoError(t, err)
+			if i%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		return float64(<-received) / packets
+	}
+
+	// Without retransmission, only the packets that survive the first try
+	// are delivered.
+	must.Between(t, 0.45, deliveryRate(t, 0), 0.55)
+
+	// With three retransmissions, a packet is lost only if all four tries
+	// are, with probability 0.5^4.
+	must.Between(t, 0.9, deliveryRate(t, 3), 1)
+}
+
+func TestUDPConnCloseWithDrain(t *testing.T) {
+	const packets = 10
+
+	t.Run("delivers delayed packets", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		baseline := runtime.NumGoroutine()
+
+		// Reordered packets are delayed in the background, so the writes
+		// return while they are still in flight.
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(100*time.Millisecond),
+			simnet.WithReorderRate(1.0),
+		)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+
+		for i := range packets {
+			_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+		must.NoError(t, conn.(simnet.Drainer).CloseWithDrain(ctx))
+
+		// Every packet was sent before the connection closed.
+		buf := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		for range packets {
+			_, _, err := peer.ReadFrom(buf)
+			must.NoError(t, err)
+		}
+
+		_, err = conn.WriteTo([]byte("late"), peer.LocalAddr())
+		must.ErrorIs(t, err, net.ErrClosed)
+
+		// No delivery goroutines are left behind.
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool {
+				return runtime.NumGoroutine() <= baseline
+			}),
+			wait.Timeout(time.Second),
+			wait.Gap(10*time.Millisecond),
+		))
+	})
+
+	t.Run("gives up when the context is done", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(5*time.Second),
+			simnet.WithReorderRate(1.0),
+		)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+
+		_, err = conn.WriteTo([]byte("slow"), peer.LocalAddr())
+		must.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+		must.ErrorIs(t, conn.(simnet.Drainer).CloseWithDrain(ctx), context.DeadlineExceeded)
+	})
+}
+
+func TestUDPConnCloseStopsDelivery(t *testing.T) {
+	const packets = 100
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	baseline := runtime.NumGoroutine()
+
+	// Every packet is reordered, so each is delayed by its own goroutine.
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(5*time.Second),
+		simnet.WithReorderRate(1.0),
+	)
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+
+	for i := range packets {
+		_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+		must.NoError(t, err)
+	}
+	must.Greater(t, baseline+packets/2, runtime.NumGoroutine())
+
+	// Closing abandons the delayed packets long before their latency.
+	must.NoError(t, conn.Close())
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			return runtime.NumGoroutine() <= baseline
+		}),
+		wait.Timeout(time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+}
+
+func BenchmarkUDPConnReadBufferSize(b *testing.B) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(b, err)
+	b.Cleanup(func() {
+		peer.Close()
+	})
+
+	// Each iteration opens a packet conn, receives a small datagram, and
+	// closes it, so that the read buffer dominates the bytes allocated.
+	run := func(b *testing.B, opts ...simnet.Option) {
+		cfg := simnet.NewConfig(opts...)
+		buf := make([]byte, 64)
+		b.ReportAllocs()
+		for range b.N {
+			conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+			must.NoError(b, err)
+			_, err = peer.WriteTo([]byte("ping"), conn.LocalAddr())
+			must.NoError(b, err)
+			_, _, err = conn.ReadFrom(buf)
+			must.NoError(b, err)
+			conn.Close()
+		}
+	}
+
+	b.Run("default", func(b *testing.B) {
+		run(b)
+	})
+
+	b.Run("small", func(b *testing.B) {
+		run(b, simnet.WithReadBufferSize(2048))
+	})
 }
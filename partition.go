@@ -0,0 +1,249 @@
+package simnet
+
+import (
+	"iter"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// partitionSet holds the entries of Config.PartitionedAddrs, parsed once so
+// that matching an address does not re-parse every entry.
+type partitionSet struct {
+	exact map[string]bool // "host:port" addresses and bare hosts
+	ips   []net.IP        // Bare IP addresses, matching any port
+	nets  []*net.IPNet    // CIDR ranges
+}
+
+// parsePartitions parses partition entries. Entries may be a full
+// "host:port" address, matched exactly; a bare host or IP address, matching
+// any port on that host; or a CIDR range such as "10.0.0.0/24", matching any
+// address in the subnet.
+func parsePartitions(entries iter.Seq[string]) *partitionSet {
+	ps := &partitionSet{
+		exact: make(map[string]bool),
+	}
+	for entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			ps.ips = append(ps.ips, ip)
+			continue
+		}
+		if _, subnet, err := net.ParseCIDR(entry); err == nil {
+			ps.nets = append(ps.nets, subnet)
+			continue
+		}
+		ps.exact[entry] = true
+	}
+	return ps
+}
+
+// match reports whether an address matches any partition entry.
+func (ps *partitionSet) match(address string) bool {
+	if ps.exact[address] {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if ps.exact[host] {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range ps.ips {
+		if entry.Equal(ip) {
+			return true
+		}
+	}
+	for _, subnet := range ps.nets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether any of the addresses matches a partition entry.
+func (ps *partitionSet) matchAny(addresses []string) bool {
+	for _, address := range addresses {
+		if ps.match(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionGroup records two groups of addresses that cannot reach each other.
+type partitionGroup struct {
+	a *partitionSet
+	b *partitionSet
+}
+
+// PartitionGroups partitions two groups of addresses from each other, so that
+// every address in groupA is unreachable from groupB and vice versa, while
+// addresses within each group can still reach each other. Group members may
+// be given in any form accepted by PartitionedAddrs.
+//
+// The source of traffic must be known to apply a group partition, so it
+// applies to packet conns, to Dialers created with NewDialerFrom, and to
+// connections accepted by a Listener. Accepted connections are identified by
+// their remote address, usually an ephemeral port, so group members should be
+// hosts or CIDR ranges for a partition to apply to them.
+func (cfg *Config) PartitionGroups(groupA, groupB []string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.partitionGroups = append(cfg.partitionGroups, partitionGroup{
+		a: parsePartitions(slices.Values(groupA)),
+		b: parsePartitions(slices.Values(groupB)),
+	})
+}
+
+// flappingPartition records an address that is partitioned intermittently,
+// for down out of every down+up, starting when it was added.
+type flappingPartition struct {
+	address string
+	set     *partitionSet
+	start   time.Time
+	down    time.Duration
+	up      time.Duration
+}
+
+// partitioned reports whether the partition is down at now. A partition
+// that is never up is always down, and one that is never down is always up.
+func (fp flappingPartition) partitioned(now time.Time) bool {
+	if fp.down <= 0 {
+		return false
+	}
+	if fp.up <= 0 {
+		return true
+	}
+	elapsed := now.Sub(fp.start) % (fp.down + fp.up)
+	return elapsed < fp.down
+}
+
+// AddFlappingPartition partitions an address intermittently, as a flaky link
+// would: it is unreachable for down, then reachable for up, repeating from
+// the time it is added. The address may be given in any form accepted by
+// PartitionedAddrs. Whether it is reachable is evaluated on each dial and
+// write, using the config's clock. RemovePartition and HealPartition remove
+// it.
+func (cfg *Config) AddFlappingPartition(address string, down, up time.Duration) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.flapping = append(cfg.flapping, flappingPartition{
+		address: address,
+		set:     parsePartitions(slices.Values([]string{address})),
+		start:   cfg.clockLocked().Now(),
+		down:    down,
+		up:      up,
+	})
+}
+
+// HealPartition removes all partitions, including partitioned addresses,
+// partitioned groups, and flapping partitions.
+func (cfg *Config) HealPartition() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.PartitionedAddrs = make(map[string]bool)
+	cfg.partitions = nil
+	cfg.partitionGroups = nil
+	cfg.flapping = nil
+}
+
+// PartitionReporter is implemented by the stream connections returned by this
+// package, and by connected packet conns returned by DialUDP, reporting
+// whether the remote address is currently partitioned, so that tests can
+// assert the state of a live connection after partitions change. Group
+// partitions apply when the local end of the connection is known, as for
+// connections dialed by a Dialer created with NewDialerFrom or accepted by a
+// Listener.
+type PartitionReporter interface {
+	IsPartitioned() bool
+}
+
+// IsPartitioned reports whether an address is currently unreachable because
+// it matches a partitioned address, or a flapping partition that is down.
+// Group partitions, which depend on the source of the traffic, are not
+// considered.
+func (cfg *Config) IsPartitioned(address string) bool {
+	return cfg.isPartitionedFrom(nil, address)
+}
+
+// isPartitionedFrom checks if an address is partitioned, or is unreachable
+// from a node because of a partitioned group. The node is identified by
+// sources, the addresses it may be reached at; with no sources, only
+// partitioned addresses are considered.
+func (cfg *Config) isPartitionedFrom(sources []string, address string) bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.matchPartition(address) {
+		return true
+	}
+	if len(cfg.flapping) > 0 {
+		now := cfg.clockLocked().Now()
+		for _, fp := range cfg.flapping {
+			if fp.set.match(address) && fp.partitioned(now) {
+				return true
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return false
+	}
+
+	for _, group := range cfg.partitionGroups {
+		if group.a.matchAny(sources) && group.b.match(address) {
+			return true
+		}
+		if group.b.matchAny(sources) && group.a.match(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAddrs returns the addresses a node bound to addr may be reached at,
+// for matching it against partition groups. A node bound to an unspecified
+// IP, such as "0.0.0.0" or "::", is reachable at each local IP address on the
+// same port.
+func sourceAddrs(addr net.Addr) []string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []string{addr.String()}
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+		return []string{addr.String()}
+	}
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if ifaceAddrs, err := net.InterfaceAddrs(); err == nil {
+		for _, ifaceAddr := range ifaceAddrs {
+			if ipNet, ok := ifaceAddr.(*net.IPNet); ok {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs
+}
+
+// matchPartition reports whether an address matches any partition entry,
+// parsing the entries if they changed since the last match. The caller must
+// hold cfg.mu.
+func (cfg *Config) matchPartition(address string) bool {
+	if cfg.partitions == nil {
+		cfg.partitions = parsePartitions(maps.Keys(cfg.PartitionedAddrs))
+	}
+	return cfg.partitions.match(address)
+}
@@ -0,0 +1,104 @@
+package simnet
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestMatchPartition(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		addr    string
+		match   bool
+	}{
+		{
+			name:    "exact host and port",
+			entries: []string{"10.0.0.5:9000"},
+			addr:    "10.0.0.5:9000",
+			match:   true,
+		},
+		{
+			name:    "exact host with different port",
+			entries: []string{"10.0.0.5:9000"},
+			addr:    "10.0.0.5:9001",
+			match:   false,
+		},
+		{
+			name:    "bare IP matches any port",
+			entries: []string{"192.168.1.5"},
+			addr:    "192.168.1.5:443",
+			match:   true,
+		},
+		{
+			name:    "bare IP does not match other hosts",
+			entries: []string{"192.168.1.5"},
+			addr:    "192.168.1.6:443",
+			match:   false,
+		},
+		{
+			name:    "bare IPv6 matches any port",
+			entries: []string{"::1"},
+			addr:    "[::1]:8080",
+			match:   true,
+		},
+		{
+			name:    "bare hostname matches any port",
+			entries: []string{"example.com"},
+			addr:    "example.com:443",
+			match:   true,
+		},
+		{
+			name:    "CIDR matches address in subnet",
+			entries: []string{"10.0.0.0/24"},
+			addr:    "10.0.0.42:9000",
+			match:   true,
+		},
+		{
+			name:    "CIDR does not match address outside subnet",
+			entries: []string{"10.0.0.0/24"},
+			addr:    "10.0.1.42:9000",
+			match:   false,
+		},
+		{
+			name:    "CIDR matches address without port",
+			entries: []string{"10.0.0.0/8"},
+			addr:    "10.1.2.3",
+			match:   true,
+		},
+		{
+			name:    "CIDR does not match hostname",
+			entries: []string{"10.0.0.0/8"},
+			addr:    "example.com:443",
+			match:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			for _, entry := range test.entries {
+				cfg.AddPartition(entry)
+			}
+			must.Eq(t, test.match, cfg.IsPartitioned(test.addr))
+		})
+	}
+}
+
+func TestRemovePartition(t *testing.T) {
+	cfg := NewConfig(WithPartitionedAddrs(map[string]bool{
+		"10.0.0.0/24": true,
+		"192.168.1.5": true,
+	}))
+	must.True(t, cfg.IsPartitioned("10.0.0.42:9000"))
+	must.True(t, cfg.IsPartitioned("192.168.1.5:443"))
+
+	// Removing an entry after it has been matched takes effect.
+	cfg.RemovePartition("10.0.0.0/24")
+	must.False(t, cfg.IsPartitioned("10.0.0.42:9000"))
+	must.True(t, cfg.IsPartitioned("192.168.1.5:443"))
+
+	cfg.AddPartition("10.0.0.42")
+	must.True(t, cfg.IsPartitioned("10.0.0.42:9000"))
+}
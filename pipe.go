@@ -0,0 +1,23 @@
+package simnet
+
+import "net"
+
+// Pipe creates an in-memory, full duplex network connection with simulated
+// network conditions, like net.Pipe. No sockets are used, making it suitable
+// for hermetic tests.
+//
+// Conditions are applied from the perspective of the first endpoint: data it
+// writes experiences outbound conditions, and data it receives from the
+// second endpoint experiences inbound conditions, so traffic in each
+// direction is simulated exactly once. Unlike net.Pipe, writes on either
+// endpoint return once the data is queued rather than when the peer reads
+// it. Both endpoints implement StatsProvider, counting the data they write.
+// Closing either endpoint unblocks pending operations on the other.
+func Pipe(cfg *Config) (net.Conn, net.Conn) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	c1, c2 := net.Pipe()
+	return wrapPipeEnd(c1, cfg, outbound), wrapPipeEnd(c2, cfg, inbound)
+}
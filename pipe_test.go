@@ -0,0 +1,163 @@
+package simnet_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestPipe(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		const latency = 50 * time.Millisecond
+
+		a, b := simnet.Pipe(simnet.NewConfig(simnet.WithLatency(latency)))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		go func() {
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(b, buf); err != nil {
+				return
+			}
+			b.Write([]byte("pong"))
+		}()
+
+		start := time.Now()
+		_, err := a.Write([]byte("ping"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(a, buf)
+		must.NoError(t, err)
+		must.Eq(t, "pong", string(buf))
+
+		// Latency applies once in each direction.
+		must.Between(t, 2*latency, time.Since(start), 2*latency+200*time.Millisecond)
+	})
+
+	t.Run("duplication", func(t *testing.T) {
+		a, b := simnet.Pipe(simnet.NewConfig(simnet.WithOutbound(simnet.DirectionConfig{
+			DuplicateRate: 1.0,
+		})))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		_, err := a.Write([]byte("dup"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 6)
+		_, err = io.ReadFull(b, buf)
+		must.NoError(t, err)
+		must.Eq(t, "dupdup", string(buf))
+	})
+
+	t.Run("loss", func(t *testing.T) {
+		a, b := simnet.Pipe(simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{
+			LossRate: 1.0,
+		})))
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		// Lost segments are retransmitted, delaying rather than losing data.
+		start := time.Now()
+		_, err := b.Write([]byte("lost"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(a, buf)
+		must.NoError(t, err)
+		must.Eq(t, "lost", string(buf))
+		must.GreaterEq(t, 200*time.Millisecond, time.Since(start))
+
+		stats := b.(simnet.StatsProvider).Stats()
+		must.Eq(t, 1, stats.PacketsSent)
+		must.Eq(t, 1, stats.PacketsDropped)
+	})
+
+	t.Run("reordering", func(t *testing.T) {
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(100*time.Millisecond),
+			simnet.WithReorderRate(1.0),
+		)
+		a, b := simnet.Pipe(cfg)
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		// The first write arrives late. A stream delivers bytes in order,
+		// so the later write is held up behind it rather than overtaking it.
+		start := time.Now()
+		_, err := a.Write([]byte("1"))
+		must.NoError(t, err)
+
+		cfg.SetReorderRate(0)
+		cfg.SetLatency(0)
+
+		_, err = a.Write([]byte("2"))
+		must.NoError(t, err)
+
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(b, buf)
+		must.NoError(t, err)
+		must.Eq(t, "12", string(buf))
+		must.GreaterEq(t, 200*time.Millisecond, time.Since(start))
+		must.Eq(t, 1, a.(simnet.StatsProvider).Stats().PacketsReordered)
+	})
+
+	t.Run("nil config", func(t *testing.T) {
+		a, b := simnet.Pipe(nil)
+		t.Cleanup(func() {
+			a.Close()
+			b.Close()
+		})
+
+		go a.Write([]byte("hello"))
+
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(b, buf)
+		must.NoError(t, err)
+		must.Eq(t, "hello", string(buf))
+	})
+
+	t.Run("close unblocks the other end", func(t *testing.T) {
+		a, b := simnet.Pipe(simnet.NewConfig())
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := a.Read(make([]byte, 1))
+			errs <- err
+		}()
+
+		must.NoError(t, b.Close())
+		select {
+		case err := <-errs:
+			must.ErrorIs(t, err, io.EOF)
+		case <-time.After(time.Second):
+			t.Fatal("expected read to unblock")
+		}
+
+		a, b = simnet.Pipe(simnet.NewConfig())
+		go func() {
+			_, err := b.Read(make([]byte, 1))
+			errs <- err
+		}()
+
+		must.NoError(t, a.Close())
+		select {
+		case err := <-errs:
+			must.ErrorIs(t, err, io.EOF)
+		case <-time.After(time.Second):
+			t.Fatal("expected read to unblock")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package simnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool, so that a
+// single large write does not keep a large buffer alive.
+const maxPooledBuffer = 64 << 10
+
+// bufferPool holds the bytes of buffers for data in flight on stream
+// connections. Packet conns copy each datagram instead, since their packets
+// may be held, duplicated, and retransmitted along many paths.
+//
+// Only the bytes are pooled, not the buffers holding them, so that a buffer
+// released once too often is caught rather than corrupting a buffer that
+// reuses its bytes.
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// buffer is a byte buffer holding data in flight on a stream connection. The
+// same data may be scheduled for delivery more than once, as a write and its
+// duplicates are, so the buffer is reference counted: each scheduled delivery
+// retains it, and its bytes return to the pool once the last reference is
+// released.
+type buffer struct {
+	b    []byte
+	refs atomic.Int32
+	pool *[]byte // Holds b while it is in the pool
+}
+
+// newBuffer returns a buffer of n bytes from the pool, with one reference.
+// Its contents are unspecified.
+func newBuffer(n int) *buffer {
+	pool := bufferPool.Get().(*[]byte)
+	b := *pool
+	if cap(b) < n {
+		b = make([]byte, n)
+	}
+	buf := &buffer{b: b[:n], pool: pool}
+	buf.refs.Store(1)
+	return buf
+}
+
+// copyBuffer returns a buffer from the pool holding a copy of p, with one
+// reference.
+func copyBuffer(p []byte) *buffer {
+	buf := newBuffer(len(p))
+	copy(buf.b, p)
+	return buf
+}
+
+// retain adds a reference to the buffer, which must already be referenced.
+func (buf *buffer) retain() {
+	buf.refs.Add(1)
+}
+
+// release removes a reference to the buffer, returning its bytes to the pool
+// once none remain. The buffer must not be used by the caller afterwards.
+// Since a released buffer is never handed out again, releasing it once more
+// than it was retained always panics.
+func (buf *buffer) release() {
+	refs := buf.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("simnet: buffer released more times than it was retained")
+	}
+	b, pool := buf.b, buf.pool
+	buf.b, buf.pool = nil, nil
+	if cap(b) <= maxPooledBuffer {
+		*pool = b[:0]
+		bufferPool.Put(pool)
+	}
+}
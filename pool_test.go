@@ -0,0 +1,70 @@
+package simnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestBuffer(t *testing.T) {
+	t.Run("reference counted", func(t *testing.T) {
+		buf := copyBuffer([]byte("ping"))
+		must.Eq(t, "ping", string(buf.b))
+
+		// The buffer stays referenced until every reference is released,
+		// then gives up its bytes.
+		buf.retain()
+		buf.release()
+		must.Eq(t, 1, buf.refs.Load())
+		must.Eq(t, "ping", string(buf.b))
+		buf.release()
+		must.Eq(t, 0, buf.refs.Load())
+		must.Nil(t, buf.b)
+	})
+
+	t.Run("released too often", func(t *testing.T) {
+		// The buffer is built by hand, so that whatever else is using the
+		// pool cannot affect it.
+		buf := &buffer{b: make([]byte, 4), pool: new([]byte)}
+		buf.refs.Store(1)
+		buf.release()
+
+		// Releasing it again is a bug, which panics rather than letting
+		// its bytes be handed out twice, even once they have been reused.
+		copyBuffer([]byte("pong")).release()
+		defer func() {
+			must.NotNil(t, recover())
+		}()
+		buf.release()
+	})
+}
+
+// bufferSink keeps benchmarked buffers from being optimized away.
+var bufferSink []byte
+
+func BenchmarkBuffer(b *testing.B) {
+	data := make([]byte, 32<<10)
+
+	// A write with one duplicate, holding the data in a pooled buffer.
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			buf := copyBuffer(data)
+			buf.retain()
+			buf.release()
+			buf.release()
+		}
+	})
+
+	// The same write copying the data into a new slice, as before the
+	// buffers were pooled.
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			bufferSink = bytes.Clone(data)
+		}
+	})
+}
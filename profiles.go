@@ -0,0 +1,81 @@
+package simnet
+
+import "time"
+
+// Profile3G returns options simulating a typical 3G mobile connection:
+// 100ms latency with up to 50ms of jitter, 200 KBps (~1.6 Mbps) of
+// bandwidth, and 1% packet loss.
+//
+//	cfg := simnet.NewConfig(simnet.Profile3G()...)
+func Profile3G() []Option {
+	return []Option{
+		WithLatency(100 * time.Millisecond),
+		WithJitter(50 * time.Millisecond),
+		WithBandwidth(200 * 1024),
+		WithLossRate(0.01),
+	}
+}
+
+// Profile4G returns options simulating a typical 4G/LTE mobile connection:
+// 50ms latency with up to 20ms of jitter, 1.5 MBps (~12 Mbps) of bandwidth,
+// and 0.1% packet loss.
+func Profile4G() []Option {
+	return []Option{
+		WithLatency(50 * time.Millisecond),
+		WithJitter(20 * time.Millisecond),
+		WithBandwidth(1536 * 1024),
+		WithLossRate(0.001),
+	}
+}
+
+// ProfileSatellite returns options simulating a geostationary satellite
+// link: 600ms latency with up to 50ms of jitter, 1.25 MBps (~10 Mbps) of
+// bandwidth, and 0.5% packet loss.
+func ProfileSatellite() []Option {
+	return []Option{
+		WithLatency(600 * time.Millisecond),
+		WithJitter(50 * time.Millisecond),
+		WithBandwidth(1280 * 1024),
+		WithLossRate(0.005),
+	}
+}
+
+// ProfileWiFiCongested returns options simulating a congested WiFi network:
+// 30ms latency with up to 70ms of jitter, 250 KBps (~2 Mbps) of bandwidth,
+// 2% packet loss, and 1% packet reordering.
+func ProfileWiFiCongested() []Option {
+	return []Option{
+		WithLatency(30 * time.Millisecond),
+		WithJitter(70 * time.Millisecond),
+		WithBandwidth(250 * 1024),
+		WithLossRate(0.02),
+		WithReorderRate(0.01),
+	}
+}
+
+// ProfileDSL returns options simulating a home DSL connection: 25ms latency
+// with up to 5ms of jitter, 1 MBps (~8 Mbps) of bandwidth, and 0.1% packet
+// loss.
+func ProfileDSL() []Option {
+	return []Option{
+		WithLatency(25 * time.Millisecond),
+		WithJitter(5 * time.Millisecond),
+		WithBandwidth(1024 * 1024),
+		WithLossRate(0.001),
+	}
+}
+
+// ProfileLossyMobile returns options simulating a poor mobile connection at
+// the edge of coverage: 150ms latency with up to 100ms of jitter, 64 KBps
+// (~512 Kbps) of bandwidth, 5% packet loss, 2% packet reordering, and 1%
+// packet duplication.
+func ProfileLossyMobile() []Option {
+	return []Option{
+		WithLatency(150 * time.Millisecond),
+		WithJitter(100 * time.Millisecond),
+		WithBandwidth(64 * 1024),
+		WithLossRate(0.05),
+		WithReorderRate(0.02),
+		WithDuplicateRate(0.01),
+	}
+}
@@ -0,0 +1,74 @@
+package simnet_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile func() []simnet.Option
+		latency [2]time.Duration
+		loss    [2]float64
+	}{
+		{
+			name:    "3G",
+			profile: simnet.Profile3G,
+			latency: [2]time.Duration{50 * time.Millisecond, 200 * time.Millisecond},
+			loss:    [2]float64{0.001, 0.05},
+		},
+		{
+			name:    "4G",
+			profile: simnet.Profile4G,
+			latency: [2]time.Duration{20 * time.Millisecond, 100 * time.Millisecond},
+			loss:    [2]float64{0, 0.01},
+		},
+		{
+			name:    "satellite",
+			profile: simnet.ProfileSatellite,
+			latency: [2]time.Duration{500 * time.Millisecond, 800 * time.Millisecond},
+			loss:    [2]float64{0.001, 0.01},
+		},
+		{
+			name:    "congested WiFi",
+			profile: simnet.ProfileWiFiCongested,
+			latency: [2]time.Duration{5 * time.Millisecond, 100 * time.Millisecond},
+			loss:    [2]float64{0.005, 0.05},
+		},
+		{
+			name:    "DSL",
+			profile: simnet.ProfileDSL,
+			latency: [2]time.Duration{10 * time.Millisecond, 60 * time.Millisecond},
+			loss:    [2]float64{0, 0.01},
+		},
+		{
+			name:    "lossy mobile",
+			profile: simnet.ProfileLossyMobile,
+			latency: [2]time.Duration{100 * time.Millisecond, 300 * time.Millisecond},
+			loss:    [2]float64{0.02, 0.1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := simnet.NewConfig(test.profile()...)
+
+			must.Between(t, test.latency[0], cfg.Latency, test.latency[1])
+			must.Between(t, 0, cfg.Jitter, 100*time.Millisecond)
+			must.Between(t, test.loss[0], cfg.LossRate, test.loss[1])
+			must.Between(t, 0, cfg.ReorderRate, 0.05)
+			must.Between(t, 0, cfg.DuplicateRate, 0.05)
+			must.Positive(t, cfg.Bandwidth)
+
+			// The config is usable by a dialer.
+			addr := startEchoServer(t)
+			conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+			must.NoError(t, err)
+			must.NoError(t, conn.Close())
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package simnet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// traceEvent is a simulated decision written by WithTraceWriter, one JSON
+// object per line.
+type traceEvent struct {
+	Time  time.Time     `json:"time"`
+	Event string        `json:"event"`           // "draw", "drop", "duplicate", "reorder", or "delay"
+	Conn  int64         `json:"conn,omitempty"`  // Connection making a draw, numbered from 1 in order of creation
+	Value int64         `json:"value,omitempty"` // Value drawn from the connection's random source
+	Addr  string        `json:"addr,omitempty"`  // Remote address of the packet
+	Size  int           `json:"size,omitempty"`  // Bytes affected
+	Delay time.Duration `json:"delay,omitempty"` // Simulated delay, in nanoseconds
+}
+
+// tracer writes trace events to a writer.
+type tracer struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	clock Clock
+}
+
+// record writes an event, stamped with the current time.
+func (t *tracer) record(event traceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	event.Time = t.clock.Now()
+	t.enc.Encode(event)
+}
+
+// WithTraceWriter records every simulated decision to w as newline-delimited
+// JSON, so that a run can be inspected, or reproduced exactly with
+// ReplayConfig. Lost, duplicated, reordered, and delayed packets are
+// recorded as "drop", "duplicate", "reorder", and "delay" events with the
+// remote address and size of the packet, and every value drawn from a
+// connection's random source is recorded as a "draw" event. Events are
+// written as they happen, so w must be safe to write to while the
+// connections are in use.
+func WithTraceWriter(w io.Writer) Option {
+	return func(cfg *Config) {
+		cfg.TraceWriter = w
+	}
+}
+
+// tracerLocked returns the tracer writing to TraceWriter, or nil if it is
+// unset. The caller must hold cfg.mu.
+func (cfg *Config) tracerLocked() *tracer {
+	if cfg.TraceWriter == nil {
+		return nil
+	}
+	if cfg.tracer == nil || cfg.tracerWriter != cfg.TraceWriter {
+		clock := cfg.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		cfg.tracer = &tracer{enc: json.NewEncoder(cfg.TraceWriter), clock: clock}
+		cfg.tracerWriter = cfg.TraceWriter
+	}
+	return cfg.tracer
+}
+
+// decision records a decision about a packet. It does nothing if t is nil,
+// so that it may be called whether or not a trace writer is set.
+func (t *tracer) decision(event string, addr net.Addr, size int, d time.Duration) {
+	if t == nil {
+		return
+	}
+	e := traceEvent{Event: event, Size: size, Delay: d}
+	if addr != nil {
+		e.Addr = addr.String()
+	}
+	t.record(e)
+}
+
+// recordingSource is a rand.Source recording the values drawn from it as
+// "draw" events.
+type recordingSource struct {
+	src    rand.Source
+	tracer *tracer
+	conn   int64
+}
+
+// Int63 returns a value drawn from the underlying source, recording it.
+func (s *recordingSource) Int63() int64 {
+	v := s.src.Int63()
+	s.tracer.record(traceEvent{Event: "draw", Conn: s.conn, Value: v})
+	return v
+}
+
+// Seed seeds the underlying source.
+func (s *recordingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+}
+
+// replay holds the values drawn by each connection of a recorded run.
+type replay struct {
+	draws map[int64][]int64
+}
+
+// ReplayConfig returns a Config reproducing a run recorded with
+// WithTraceWriter. Each connection, numbered in order of creation, draws the
+// values recorded for it rather than new random values, so that it makes the
+// same decisions as in the recorded run, whatever the seed. Once a
+// connection's recorded values run out, it draws random values as usual.
+//
+// The options should set the same conditions as the recorded run, and the
+// connections should be created and used in the same order, since which
+// values are drawn depends on the conditions and on the packets sent.
+// Decisions about written packets replay exactly, as long as they are drawn
+// in the same order; with jitter, WithDeterministic ensures delays are drawn
+// as packets are written. Decisions about received data depend on how it
+// arrives, which may differ from one run to the next.
+func ReplayConfig(r io.Reader, opts ...Option) (*Config, error) {
+	rp := &replay{draws: make(map[int64][]int64)}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event traceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidTrace, line, err)
+		}
+		if event.Event == "draw" {
+			rp.draws[event.Conn] = append(rp.draws[event.Conn], event.Value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTrace, err)
+	}
+
+	cfg := NewConfig(opts...)
+	cfg.replay = rp
+	return cfg, nil
+}
+
+// replaySource is a rand.Source returning recorded values, then values from
+// another source once they run out.
+type replaySource struct {
+	values []int64
+	src    rand.Source
+}
+
+// Int63 returns the next recorded value.
+func (s *replaySource) Int63() int64 {
+	if len(s.values) == 0 {
+		return s.src.Int63()
+	}
+	v := s.values[0]
+	s.values = s.values[1:]
+	return v
+}
+
+// Seed seeds the source used once the recorded values run out.
+func (s *replaySource) Seed(seed int64) {
+	s.src.Seed(seed)
+}
@@ -0,0 +1,88 @@
+package simnet_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestTraceWriter(t *testing.T) {
+	const packets = 50
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// Without a seed, every run draws different values.
+	opts := []simnet.Option{
+		simnet.WithLossRate(0.5),
+		simnet.WithDuplicateRate(0.3),
+	}
+
+	// outcomes writes packets, returning what happened to each: "dropped",
+	// "duplicated", or "sent".
+	outcomes := func(cfg *simnet.Config) []string {
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		defer conn.Close()
+
+		var outcomes []string
+		for range packets {
+			before := conn.(simnet.StatsProvider).Stats()
+			_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+			must.NoError(t, err)
+			after := conn.(simnet.StatsProvider).Stats()
+
+			switch {
+			case after.PacketsDropped > before.PacketsDropped:
+				outcomes = append(outcomes, "dropped")
+			case after.PacketsDuplicated > before.PacketsDuplicated:
+				outcomes = append(outcomes, "duplicated")
+			default:
+				outcomes = append(outcomes, "sent")
+			}
+		}
+		return outcomes
+	}
+
+	var trace bytes.Buffer
+	recorded := outcomes(simnet.NewConfig(append(opts, simnet.WithTraceWriter(&trace))...))
+	must.SliceContains(t, recorded, "dropped")
+
+	// Every event is a JSON object on its own line, and each drop is
+	// recorded.
+	events := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(trace.String()))
+	for scanner.Scan() {
+		var event struct {
+			Event string `json:"event"`
+		}
+		must.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events[event.Event]++
+	}
+	dropped := 0
+	for _, outcome := range recorded {
+		if outcome == "dropped" {
+			dropped++
+		}
+	}
+	must.Eq(t, dropped, events["drop"])
+	must.Positive(t, events["draw"])
+
+	replayCfg, err := simnet.ReplayConfig(&trace, opts...)
+	must.NoError(t, err)
+	must.Eq(t, recorded, outcomes(replayCfg))
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := simnet.ReplayConfig(strings.NewReader("not json\n"))
+		must.ErrorIs(t, err, simnet.ErrInvalidTrace)
+	})
+}
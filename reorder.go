@@ -0,0 +1,87 @@
+package simnet
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultReorderTimeout is the longest a packet is held for reordering when
+// ReorderConfig.Timeout is not set.
+const defaultReorderTimeout = 100 * time.Millisecond
+
+// hold determines if a packet should be held back for reordering.
+func (rc ReorderConfig) hold(r *rand.Rand) bool {
+	return rc.Probability > 0 && r.Float64() < rc.Probability
+}
+
+// timeout returns the longest a packet may be held.
+func (rc ReorderConfig) timeout() time.Duration {
+	if rc.Timeout <= 0 {
+		return defaultReorderTimeout
+	}
+	return rc.Timeout
+}
+
+// holdBuffer holds packets selected for reordering until a number of later
+// packets have been delivered, bounding how far they are displaced.
+type holdBuffer struct {
+	mu   sync.Mutex
+	held []*heldPacket
+}
+
+// heldPacket is a packet waiting in a holdBuffer.
+type heldPacket struct {
+	pkt       packet
+	remaining int // Later packets to deliver before this one
+}
+
+// hold adds a packet to the buffer, to be released once gap later packets
+// have passed.
+func (hb *holdBuffer) hold(pkt packet, gap int) *heldPacket {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	h := &heldPacket{
+		pkt:       pkt,
+		remaining: max(gap, 1),
+	}
+	hb.held = append(hb.held, h)
+	return h
+}
+
+// pass records that a later packet has been delivered, returning the held
+// packets that are now due, in the order they were held.
+func (hb *holdBuffer) pass() []packet {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	var due []packet
+	held := hb.held[:0]
+	for _, h := range hb.held {
+		h.remaining--
+		if h.remaining <= 0 {
+			due = append(due, h.pkt)
+			continue
+		}
+		held = append(held, h)
+	}
+	clear(hb.held[len(held):])
+	hb.held = held
+	return due
+}
+
+// release removes a held packet from the buffer, reporting whether it was
+// still held rather than already released by pass.
+func (hb *holdBuffer) release(h *heldPacket) bool {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	for i, held := range hb.held {
+		if held == h {
+			hb.held = append(hb.held[:i], hb.held[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package simnet_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestReorderConfig(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(
+		simnet.WithReorder(simnet.ReorderConfig{
+			Gap:         2,
+			Probability: 0.3,
+		}),
+		simnet.WithSeed(42),
+	)
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	for seq := range byte(10) {
+		_, err := conn.WriteTo([]byte{'0' + seq}, peer.LocalAddr())
+		must.NoError(t, err)
+	}
+
+	var order []byte
+	buf := make([]byte, 1)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	for range 10 {
+		_, _, err := peer.ReadFrom(buf)
+		must.NoError(t, err)
+		order = append(order, buf[0])
+	}
+
+	// Packets 1, 3, and 4 are held, each delivered after two later packets.
+	must.Eq(t, "0251634789", string(order))
+	must.Eq(t, 3, conn.(simnet.StatsProvider).Stats().PacketsReordered)
+}
+
+func TestReorderConfigTimeout(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	cfg := simnet.NewConfig(simnet.WithReorder(simnet.ReorderConfig{
+		Gap:         5,
+		Probability: 1.0,
+		Timeout:     50 * time.Millisecond,
+	}))
+
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	// No later packets follow, so the held packet is released by the
+	// timeout instead.
+	start := time.Now()
+	_, err = conn.WriteTo([]byte("held"), peer.LocalAddr())
+	must.NoError(t, err)
+
+	buf := make([]byte, 4)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peer.ReadFrom(buf)
+	must.NoError(t, err)
+	must.Eq(t, "held", string(buf[:n]))
+	must.GreaterEq(t, 50*time.Millisecond, time.Since(start))
+}
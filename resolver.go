@@ -0,0 +1,122 @@
+package simnet
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+)
+
+// ErrNoSuchHost can be used in Config.ResolverFailAddrs to make a lookup fail
+// as if the host does not exist (NXDOMAIN).
+var ErrNoSuchHost = errors.New("simnet: no such host")
+
+// Resolver looks up hosts with simulated network conditions applied to the
+// DNS queries, so that lookups are slowed by latency and retried after loss.
+type Resolver struct {
+	config   *Config       // Network simulation configuration
+	server   string        // Address of the DNS server to query (optional)
+	resolver *net.Resolver // Underlying resolver, dialing through the simulation
+	rand     *rand.Rand
+}
+
+// NewResolver creates a new simulated Resolver with the given configuration,
+// querying the DNS servers from the system configuration.
+func NewResolver(cfg *Config) *Resolver {
+	return NewResolverWithServer(cfg, "")
+}
+
+// NewResolverWithServer creates a new simulated Resolver with the given
+// configuration, querying the DNS server at the server address instead of the
+// servers from the system configuration. A nil cfg applies no conditions.
+func NewResolverWithServer(cfg *Config, server string) *Resolver {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	r := &Resolver{
+		config: cfg,
+		server: server,
+		rand:   cfg.deriveRand(),
+	}
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial:     r.dial,
+	}
+	return r
+}
+
+// LookupHost looks up the given host, returning its addresses.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if err := r.fail(ctx, host); err != nil {
+		return nil, err
+	}
+	return r.resolver.LookupHost(ctx, host)
+}
+
+// LookupIPAddr looks up the given host, returning its IP addresses.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := r.fail(ctx, host); err != nil {
+		return nil, err
+	}
+	return r.resolver.LookupIPAddr(ctx, host)
+}
+
+// fail returns a *net.DNSError wrapping the configured error if the host is
+// in Config.ResolverFailAddrs, after the round trip the failed query would
+// take.
+func (r *Resolver) fail(ctx context.Context, host string) error {
+	r.config.mu.Lock()
+	failErr, ok := r.config.ResolverFailAddrs[strings.TrimSuffix(host, ".")]
+	r.config.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	out, in := r.config.conditions(outbound, nil), r.config.conditions(inbound, nil)
+	r.config.mu.Lock()
+	rtt := out.latency(r.rand) + in.latency(r.rand)
+	r.config.mu.Unlock()
+
+	select {
+	case <-r.config.clock().After(rtt):
+	case <-ctx.Done():
+		return &net.DNSError{
+			UnwrapErr: ctx.Err(),
+			Err:       ctx.Err().Error(),
+			Name:      host,
+			IsTimeout: errors.Is(ctx.Err(), context.DeadlineExceeded),
+		}
+	}
+
+	var netErr net.Error
+	return &net.DNSError{
+		UnwrapErr:  failErr,
+		Err:        failErr.Error(),
+		Name:       host,
+		Server:     r.server,
+		IsNotFound: errors.Is(failErr, ErrNoSuchHost),
+		IsTimeout:  errors.Is(failErr, os.ErrDeadlineExceeded) || (errors.As(failErr, &netErr) && netErr.Timeout()),
+	}
+}
+
+// dial connects to a DNS server for the underlying resolver, with simulated
+// network conditions applied. Queries over UDP are subject to packet loss,
+// while queries over TCP are retransmitted as on any other stream.
+func (r *Resolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if r.server != "" {
+		address = r.server
+	}
+
+	if !strings.HasPrefix(network, "udp") {
+		return NewDialer(r.config).DialContext(ctx, network, address)
+	}
+
+	// The server address is an IP address, so resolving it makes no query.
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, dialError(err)
+	}
+	return DialUDP(r.config, nil, raddr)
+}
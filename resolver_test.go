@@ -0,0 +1,137 @@
+package simnet_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// startDNSServer starts a DNS server over UDP that answers every A query with
+// 192.0.2.1 and every other query with no records, returning its address.
+func startDNSServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := dnsReply(buf[:n]); reply != nil {
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// dnsReply returns the reply to a DNS query with a single question.
+func dnsReply(query []byte) []byte {
+	const headerLen = 12
+
+	// Skip the labels of the question name, then its type and class.
+	end := headerLen
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end-4:])
+
+	reply := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(reply[2:], 0x8580) // Response, authoritative, recursion available
+	binary.BigEndian.PutUint16(reply[6:], 0)      // Answers
+	binary.BigEndian.PutUint16(reply[8:], 0)      // Authority records
+	binary.BigEndian.PutUint16(reply[10:], 0)     // Additional records
+
+	if qtype == 1 { // A
+		binary.BigEndian.PutUint16(reply[6:], 1)
+		reply = append(reply,
+			0xc0, headerLen, // Name, pointing to the question
+			0, 1, // Type A
+			0, 1, // Class IN
+			0, 0, 0, 60, // TTL
+			0, 4, // Data length
+			192, 0, 2, 1,
+		)
+	}
+	return reply
+}
+
+func TestResolver(t *testing.T) {
+	const latency = 50 * time.Millisecond
+
+	t.Run("lookup is delayed by latency", func(t *testing.T) {
+		server := startDNSServer(t)
+		r := simnet.NewResolverWithServer(simnet.NewConfig(simnet.WithLatency(latency)), server)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		start := time.Now()
+		addrs, err := r.LookupHost(ctx, "service.simnet.test.")
+		must.NoError(t, err)
+		must.Eq(t, []string{"192.0.2.1"}, addrs)
+
+		// The query and the response are both delayed.
+		must.GreaterEq(t, 2*latency, time.Since(start))
+	})
+
+	t.Run("configured failures", func(t *testing.T) {
+		server := startDNSServer(t)
+		timeout := &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+		r := simnet.NewResolverWithServer(simnet.NewConfig(
+			simnet.WithLatency(latency),
+			simnet.WithResolverFailAddrs(map[string]error{
+				"missing.simnet.test": simnet.ErrNoSuchHost,
+				"slow.simnet.test":    timeout,
+			}),
+		), server)
+
+		start := time.Now()
+		_, err := r.LookupIPAddr(context.Background(), "missing.simnet.test")
+		must.ErrorIs(t, err, simnet.ErrNoSuchHost)
+		must.GreaterEq(t, 2*latency, time.Since(start))
+
+		var dnsErr *net.DNSError
+		must.True(t, errors.As(err, &dnsErr))
+		must.True(t, dnsErr.IsNotFound)
+		must.False(t, dnsErr.IsTimeout)
+
+		_, err = r.LookupHost(context.Background(), "slow.simnet.test.")
+		must.ErrorIs(t, err, timeout)
+		must.True(t, errors.As(err, &dnsErr))
+		must.True(t, dnsErr.Timeout())
+	})
+
+	t.Run("partitioned server", func(t *testing.T) {
+		server := startDNSServer(t)
+		cfg := simnet.NewConfig()
+		cfg.AddPartition(server)
+		r := simnet.NewResolverWithServer(cfg, server)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		_, err := r.LookupHost(ctx, "service.simnet.test.")
+		var dnsErr *net.DNSError
+		must.True(t, errors.As(err, &dnsErr))
+		must.StrContains(t, dnsErr.Err, "network partitioned")
+	})
+}
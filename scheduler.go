@@ -0,0 +1,182 @@
+package simnet
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// scheduler delivers delayed data from a single goroutine once its simulated
+// delay has elapsed, so that callers do not have to sleep through delays and
+// many deliveries can be in flight at once.
+//
+// In deterministic mode deliveries are ordered by virtual delivery time, so
+// that delivery order depends only on the simulated delays and the order
+// deliveries were scheduled in, not on goroutine scheduling. Virtual time
+// advances as deliveries are made: a delivery scheduled with a delay is due
+// at the virtual time of the last delivery plus that delay. Each delivery is
+// still held for its delay in real time.
+type scheduler struct {
+	mu      sync.Mutex
+	queue   scheduledQueue
+	now     time.Duration // Virtual time of the last delivery
+	seq     uint64        // Breaks ties between deliveries due at the same time
+	lastAt  time.Duration // Virtual time of the last in-order delivery
+	lastDue time.Time     // Real time of the last in-order delivery
+	clock   Clock
+	wake    chan struct{}
+	stop    <-chan struct{}
+}
+
+// scheduled is a delivery waiting in a scheduler.
+type scheduled struct {
+	at      time.Duration // Virtual delivery time
+	due     time.Time     // Real delivery time
+	seq     uint64
+	deliver func()
+}
+
+// newScheduler returns a scheduler that runs until stop is closed. Deliveries
+// still waiting when it stops are discarded.
+func newScheduler(clock Clock, stop <-chan struct{}, deterministic bool) *scheduler {
+	s := &scheduler{
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		stop:  stop,
+	}
+	s.queue.deterministic = deterministic
+	go s.run()
+	return s
+}
+
+// schedule schedules deliver to be called after delay.
+func (s *scheduler) schedule(delay time.Duration, deliver func()) {
+	s.mu.Lock()
+	s.push(s.now+delay, s.clock.Now().Add(delay), deliver)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// scheduleInOrder schedules deliver to be called after delay, but not before
+// any delivery previously scheduled in order, as for data on a stream.
+func (s *scheduler) scheduleInOrder(delay time.Duration, deliver func()) {
+	s.mu.Lock()
+	at := max(s.now+delay, s.lastAt)
+	due := s.clock.Now().Add(delay)
+	if due.Before(s.lastDue) {
+		due = s.lastDue
+	}
+	s.lastAt, s.lastDue = at, due
+	s.push(at, due, deliver)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// push adds a delivery to the queue. The caller must hold s.mu.
+func (s *scheduler) push(at time.Duration, due time.Time, deliver func()) {
+	heap.Push(&s.queue, &scheduled{
+		at:      at,
+		due:     due,
+		seq:     s.seq,
+		deliver: deliver,
+	})
+	s.seq++
+}
+
+// signal wakes the run loop to consider a new delivery.
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run makes deliveries as they become due, until stopped.
+//
+// The loop keeps a single timer for the next delivery, reusing it across
+// wakeups while that delivery stays first and stopping it when an earlier
+// one takes its place.
+func (s *scheduler) run() {
+	var (
+		timer     <-chan time.Time
+		stopTimer func()
+		timerDue  time.Time
+	)
+	defer func() {
+		if stopTimer != nil {
+			stopTimer()
+		}
+	}()
+
+	for {
+		s.mu.Lock()
+		if s.queue.Len() == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		next := s.queue.items[0]
+		if wait := next.due.Sub(s.clock.Now()); wait > 0 {
+			if timer == nil || !timerDue.Equal(next.due) {
+				if stopTimer != nil {
+					stopTimer()
+				}
+				timer, stopTimer = newClockTimer(s.clock, wait)
+				timerDue = next.due
+			}
+			s.mu.Unlock()
+			select {
+			case <-timer:
+				timer, stopTimer = nil, nil
+			case <-s.wake:
+			case <-s.stop:
+				return
+			}
+			continue
+		}
+
+		heap.Pop(&s.queue)
+		s.now = max(s.now, next.at)
+		s.mu.Unlock()
+
+		next.deliver()
+	}
+}
+
+// scheduledQueue is a min-heap of scheduled deliveries, ordered by virtual
+// delivery time in deterministic mode and by real delivery time otherwise.
+type scheduledQueue struct {
+	items         []*scheduled
+	deterministic bool
+}
+
+func (q *scheduledQueue) Len() int { return len(q.items) }
+
+func (q *scheduledQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.deterministic {
+		if a.at != b.at {
+			return a.at < b.at
+		}
+	} else if !a.due.Equal(b.due) {
+		return a.due.Before(b.due)
+	}
+	return a.seq < b.seq
+}
+
+func (q *scheduledQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *scheduledQueue) Push(x any) { q.items = append(q.items, x.(*scheduled)) }
+
+func (q *scheduledQueue) Pop() any {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
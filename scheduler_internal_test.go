@@ -0,0 +1,60 @@
+package simnet
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestSchedulerTimer(t *testing.T) {
+	clock := NewFakeClock()
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+	})
+	s := newScheduler(clock, stop, false)
+
+	delivered := make(chan time.Duration, 3)
+	schedule := func(d time.Duration) {
+		s.schedule(d, func() { delivered <- d })
+	}
+
+	// waitFor blocks until the run loop waits on the clock for the delivery
+	// due after d, and checks that no abandoned waits are left behind.
+	waitFor := func(d time.Duration) {
+		t.Helper()
+		until := clock.Now().Add(d)
+		for {
+			clock.mu.Lock()
+			waiting := slices.ContainsFunc(clock.waiters, func(w fakeWaiter) bool {
+				return w.until.Equal(until)
+			})
+			n := len(clock.waiters)
+			clock.mu.Unlock()
+			if waiting {
+				must.Eq(t, 1, n)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	schedule(10 * time.Second)
+	waitFor(10 * time.Second)
+
+	// A later delivery wakes the loop but keeps the same timer; an earlier
+	// one replaces it.
+	schedule(20 * time.Second)
+	schedule(time.Second)
+	waitFor(time.Second)
+
+	clock.Advance(time.Second)
+	must.Eq(t, time.Second, <-delivered)
+	waitFor(9 * time.Second)
+
+	clock.Advance(9 * time.Second)
+	must.Eq(t, 10*time.Second, <-delivered)
+	waitFor(10 * time.Second)
+}
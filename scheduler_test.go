@@ -0,0 +1,106 @@
+package simnet_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestDeterministic(t *testing.T) {
+	opts := []simnet.Option{
+		simnet.WithLatency(5 * time.Millisecond),
+		simnet.WithJitter(20 * time.Millisecond),
+		simnet.WithReorderRate(0.3),
+		simnet.WithDuplicateRate(0.1),
+		simnet.WithSeed(7),
+		simnet.WithDeterministic(true),
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		// run writes sequence-numbered messages through a pipe, returning
+		// the byte stream received by the other end.
+		run := func() string {
+			a, b := simnet.Pipe(simnet.NewConfig(opts...))
+			t.Cleanup(func() {
+				b.Close()
+			})
+
+			received := make(chan []byte)
+			go func() {
+				data, _ := io.ReadAll(b)
+				received <- data
+			}()
+
+			for i := range 30 {
+				_, err := fmt.Fprintf(a, "%02d", i)
+				must.NoError(t, err)
+			}
+
+			// Let reordered writes arrive before closing.
+			time.Sleep(100 * time.Millisecond)
+			must.NoError(t, a.Close())
+			return string(<-received)
+		}
+
+		first := run()
+		must.NotEq(t, inOrder(30), first)
+		for range 3 {
+			must.Eq(t, first, run())
+		}
+	})
+
+	t.Run("packet conn", func(t *testing.T) {
+		// run sends sequence-numbered datagrams, returning them in the
+		// order the peer received them.
+		run := func() string {
+			peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			must.NoError(t, err)
+			t.Cleanup(func() {
+				peer.Close()
+			})
+
+			conn, err := simnet.UDPConn(simnet.NewConfig(opts...), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+			must.NoError(t, err)
+			t.Cleanup(func() {
+				conn.Close()
+			})
+
+			for i := range 30 {
+				_, err := conn.WriteTo(fmt.Appendf(nil, "%02d", i), peer.LocalAddr())
+				must.NoError(t, err)
+			}
+
+			var received []byte
+			buf := make([]byte, 2)
+			for {
+				peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				n, _, err := peer.ReadFrom(buf)
+				if err != nil {
+					break
+				}
+				received = append(received, buf[:n]...)
+			}
+			return string(received)
+		}
+
+		first := run()
+		must.NotEq(t, inOrder(30), first)
+		for range 3 {
+			must.Eq(t, first, run())
+		}
+	})
+}
+
+// inOrder returns n sequence numbers formatted as they are written, in order.
+func inOrder(n int) string {
+	var s string
+	for i := range n {
+		s += fmt.Sprintf("%02d", i)
+	}
+	return s
+}
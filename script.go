@@ -0,0 +1,67 @@
+package simnet
+
+import "time"
+
+// ScriptAction is an action applied to a packet by a ScriptedEvent.
+type ScriptAction int
+
+const (
+	ScriptDrop      ScriptAction = iota + 1 // Drop the packet, as if lost
+	ScriptDuplicate                         // Deliver a duplicate of the packet
+	ScriptDelay                             // Delay the packet by ScriptedEvent.Delay, letting later packets overtake it
+	ScriptReorder                           // Reorder the packet, as if drawn by ReorderRate or Reorder
+)
+
+// ScriptedEvent applies an action to a specific packet written on a packet
+// conn, identified by its index among the packets written on the conn,
+// counting from zero.
+type ScriptedEvent struct {
+	PacketIndex int           // Index of the packet the action applies to
+	Action      ScriptAction  // Action applied to the packet
+	Delay       time.Duration // Extra delay of the packet, for ScriptDelay
+}
+
+// WithScriptedEvents applies actions to specific packets written on packet
+// conns, so that tests can reproduce exact scenarios, such as the duplicate
+// acknowledgements that trigger a fast retransmit, rather than relying on
+// random draws. Each packet written on a conn is counted, starting from zero,
+// and the events for its index are applied in addition to the random
+// conditions of the config, which are usually left unset. Several events may
+// apply to the same packet. A scripted drop is retransmitted like any loss
+// when WithAutoRetransmit is set, and events do not apply to the
+// retransmission.
+func WithScriptedEvents(events ...ScriptedEvent) Option {
+	return func(cfg *Config) {
+		cfg.ScriptedEvents = events
+	}
+}
+
+// scriptedOutcome is the combined effect of the scripted events for a packet.
+type scriptedOutcome struct {
+	drop       bool
+	duplicates int
+	delay      time.Duration
+	reorder    bool
+}
+
+// scriptLocked returns the combined effect of the scripted events for the
+// packet at index. The caller must hold cfg.mu.
+func (cfg *Config) scriptLocked(index int) scriptedOutcome {
+	var out scriptedOutcome
+	for _, event := range cfg.ScriptedEvents {
+		if event.PacketIndex != index {
+			continue
+		}
+		switch event.Action {
+		case ScriptDrop:
+			out.drop = true
+		case ScriptDuplicate:
+			out.duplicates++
+		case ScriptDelay:
+			out.delay += event.Delay
+		case ScriptReorder:
+			out.reorder = true
+		}
+	}
+	return out
+}
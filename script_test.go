@@ -0,0 +1,82 @@
+package simnet_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestScriptedEvents(t *testing.T) {
+	const packets = 8
+
+	// send writes numbered packets on a conn with the config, returning the
+	// conn and the numbers in the order the peer receives them.
+	send := func(t *testing.T, cfg *simnet.Config) (net.PacketConn, []int) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		for i := range packets {
+			_, err := conn.WriteTo([]byte(strconv.Itoa(i)), peer.LocalAddr())
+			must.NoError(t, err)
+		}
+
+		var received []int
+		buf := make([]byte, 16)
+		for {
+			peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, err := peer.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return conn, received
+			}
+			must.NoError(t, err)
+			i, err := strconv.Atoi(string(buf[:n]))
+			must.NoError(t, err)
+			received = append(received, i)
+		}
+	}
+
+	t.Run("drop and duplicate", func(t *testing.T) {
+		conn, received := send(t, simnet.NewConfig(simnet.WithScriptedEvents(
+			simnet.ScriptedEvent{PacketIndex: 3, Action: simnet.ScriptDrop},
+			simnet.ScriptedEvent{PacketIndex: 5, Action: simnet.ScriptDuplicate},
+		)))
+		must.Eq(t, []int{0, 1, 2, 4, 5, 5, 6, 7}, received)
+
+		stats := conn.(simnet.StatsProvider).Stats()
+		must.Eq(t, int64(packets), stats.PacketsSent)
+		must.Eq(t, int64(1), stats.PacketsDropped)
+		must.Eq(t, int64(1), stats.PacketsDuplicated)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		_, received := send(t, simnet.NewConfig(simnet.WithScriptedEvents(
+			simnet.ScriptedEvent{PacketIndex: 1, Action: simnet.ScriptDelay, Delay: 50 * time.Millisecond},
+		)))
+		must.Eq(t, []int{0, 2, 3, 4, 5, 6, 7, 1}, received)
+	})
+
+	t.Run("drop is retransmitted", func(t *testing.T) {
+		// The retransmission trails the packets written after the drop,
+		// as after a fast retransmit, and is not dropped again.
+		_, received := send(t, simnet.NewConfig(
+			simnet.WithScriptedEvents(simnet.ScriptedEvent{PacketIndex: 3, Action: simnet.ScriptDrop}),
+			simnet.WithAutoRetransmit(1, 50*time.Millisecond),
+		))
+		must.Eq(t, []int{0, 1, 2, 4, 5, 6, 7, 3}, received)
+	})
+}
@@ -1,25 +1,140 @@
 package simnet
 
 import (
+	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"math/rand"
+	"net"
+	"reflect"
+	"slices"
 	"sync"
 	"time"
 )
 
 // Config defines the simulated network conditions.
 type Config struct {
-	mu               sync.Mutex      // Mutex to help ensure thread safety
-	rand             *rand.Rand      // Random number generator
-	Latency          time.Duration   // Base latency
-	Jitter           time.Duration   // Maximum additional latency
-	Bandwidth        int64           // Bytes per second (0 means unlimited)
-	LossRate         float64         // Packet loss rate (0.0 to 1.0)
-	ReorderRate      float64         // Packet reorder rate (0.0 to 1.0)
-	DuplicateRate    float64         // Packet duplication rate (0.0 to 1.0)
-	PartitionedAddrs map[string]bool // Addresses that are partitioned (unreachable)
-	Seed             int64           // Seed for randomness (optional)
+	mu                       sync.Mutex                 // Mutex to help ensure thread safety
+	rand                     *rand.Rand                 // Random number generator drawing from RandSource
+	derived                  int64                      // Generators derived for connections, offsetting their seeds
+	dialRand                 *rand.Rand                 // Random number generator for connection failures
+	trace                    *trace                     // Recorded conditions replayed over the top-level fields (see NewTraceConfig)
+	tracer                   *tracer                    // Writes events to TraceWriter
+	tracerWriter             io.Writer                  // TraceWriter the tracer writes to
+	replay                   *replay                    // Recorded draws replayed by connections (see ReplayConfig)
+	passthrough              bool                       // Stream connections bypass the simulation (see Passthrough)
+	totals                   *stats                     // Statistics summed over every connection (see Config.Stats)
+	partitions               *partitionSet              // Parsed PartitionedAddrs, rebuilt when nil
+	partitionGroups          []partitionGroup           // Groups of addresses partitioned from each other
+	flapping                 []flappingPartition        // Partitions that toggle on a schedule (see AddFlappingPartition)
+	sharedBuckets            [2]*bucket                 // Bandwidth limiters shared by every connection, by direction
+	Latency                  time.Duration              // Base latency
+	Jitter                   time.Duration              // Maximum additional latency
+	SymmetricJitter          bool                       // Vary latency by up to Jitter/2 either way, rather than only adding up to Jitter
+	LatencyDist              LatencyDistribution        // Latency distribution, overriding Latency and Jitter (optional)
+	SpikeRate                float64                    // Rate at which a packet gets SpikeLatency added to its latency (0.0 to 1.0)
+	SpikeLatency             time.Duration              // Latency added by a spike, as by a GC pause or router hiccup
+	Bandwidth                int64                      // Bytes per second (0 means unlimited)
+	BandwidthFunc            func(t time.Time) int64    // Bytes per second at time t, overriding Bandwidth (optional)
+	DownloadBandwidth        int64                      // Bytes per second read, overriding Bandwidth for inbound traffic (0 means Bandwidth)
+	UploadBandwidth          int64                      // Bytes per second written, overriding Bandwidth for outbound traffic (0 means Bandwidth)
+	Burst                    int64                      // Bytes that may be sent at once (0 means one second of bandwidth)
+	SharedBandwidth          bool                       // Share the bandwidth limit across every connection using the config
+	LossRate                 float64                    // Packet loss rate (0.0 to 1.0)
+	BitErrorRate             float64                    // Probability of flipping each bit of a delivered payload (0.0 to 1.0)
+	ReorderRate              float64                    // Packet reorder rate (0.0 to 1.0)
+	Reorder                  *ReorderConfig             // Bounded reordering for packet conns, overriding ReorderRate (optional)
+	DuplicateRate            float64                    // Packet duplication rate (0.0 to 1.0)
+	MaxDuplicates            int                        // Most duplicates delivered of a duplicated packet (0 means 1)
+	DuplicateDelay           time.Duration              // Extra delay of each duplicate on packet conns, drawn between half of it and all of it
+	CorruptFunc              func(data []byte) []byte   // Mangles the payload of each write, received segment, or datagram (see WithCorruptFunc)
+	MTU                      int                        // Largest datagram sent unfragmented, in bytes (0 means unlimited)
+	QueueSize                int                        // Packets or writes queued per connection and direction (0 means 100)
+	ReadBufferSize           int                        // Largest datagram packet conns read from the underlying connection, in bytes (0 means 65535)
+	MaxRetransmits           int                        // Times packet conns retransmit a lost packet (see WithAutoRetransmit)
+	RetransmitTimeout        time.Duration              // Delay before packet conns retransmit a lost packet
+	LinkBuffer               int                        // Bytes in flight on packet conns per direction before more wait or are dropped (0 means unlimited)
+	LinkBufferDrop           bool                       // Drop packets on packet conns when the link buffer is full, rather than waiting for room
+	TailDrop                 bool                       // Drop incoming packets on packet conns when the read queue is full, rather than waiting for room
+	WriteErrorOnLoss         bool                       // Fail WriteTo on packet conns with ErrPacketDropped when the packet is lost
+	StrictDatagramTruncation bool                       // Fail ReadFrom on packet conns with ErrDatagramTruncated when the buffer is too small for the datagram
+	DontFragment             bool                       // Fail WriteTo on packet conns with EMSGSIZE for datagrams larger than the MTU, rather than fragmenting them
+	ConnectLatency           time.Duration              // Time taken by Dialer to establish a connection, before data-plane conditions apply
+	ConnectFailureRate       float64                    // Rate at which Dialer fails to connect, as if the connection were refused (0.0 to 1.0)
+	PartitionTimeout         time.Duration              // Time Dialer waits before timing out a dial to a partitioned address (0 means failing at once)
+	ResetRate                float64                    // Rate at which a write or received segment resets a stream connection instead (0.0 to 1.0)
+	ResetAfter               time.Duration              // Time after which stream connections are reset (0 means never)
+	MaxConns                 int                        // Connections a Listener keeps open at once (0 means unlimited)
+	RejectOverMaxConns       bool                       // Close connections arriving beyond MaxConns, rather than leaving them queued
+	PartitionedAddrs         map[string]bool            // Addresses, hosts, or CIDR ranges that are partitioned (unreachable); use AddPartition and RemovePartition once in use
+	ResolverFailAddrs        map[string]error           // Hostnames that Resolver fails to look up, with the error returned (optional)
+	AddrConditions           map[string]DirectionConfig // Conditions for traffic to and from specific addresses or hosts, overriding the rest (optional)
+	Seed                     int64                      // Seed for randomness (optional)
+	RandSource               rand.Source                // Source of randomness, overriding Seed (optional)
+	Clock                    Clock                      // Source of time for simulated delays (optional, defaults to the real clock)
+	ScriptedEvents           []ScriptedEvent            // Actions applied to specific packets written on packet conns (see WithScriptedEvents)
+	Deterministic            bool                       // Deliver delayed packets in a reproducible order (see WithDeterministic)
+	InOrderDelivery          bool                       // Deliver packets on packet conns in the order sent, despite jitter (see WithInOrderDelivery)
+	NATRebind                time.Duration              // Interval after which packet conns send from a new source port, as a NAT rebinding (see WithNATRebind)
+	Inbound                  *DirectionConfig           // Conditions for inbound traffic (optional)
+	Outbound                 *DirectionConfig           // Conditions for outbound traffic (optional)
+	Logger                   *slog.Logger               // Logs simulated decisions at debug level (optional)
+	TraceWriter              io.Writer                  // Records simulated decisions as newline-delimited JSON (see WithTraceWriter)
+
+	// Callbacks observing simulated decisions (optional). The address is
+	// the remote address of the packet or connection, and size is the
+	// number of bytes affected. They are called from the goroutine making
+	// the decision, without holding the config lock, so they may use the
+	// config but must be safe for concurrent use.
+	OnDrop       func(addr net.Addr, size int)                  // Called when a packet is lost
+	OnDropTagged func(addr net.Addr, size int, tag string)      // Called when a packet written with a tag is lost (see TaggedWriter)
+	OnDuplicate  func(addr net.Addr, size int)                  // Called when a packet is duplicated
+	OnReorder    func(addr net.Addr, size int)                  // Called when a packet is reordered
+	OnDelay      func(addr net.Addr, size int, d time.Duration) // Called when a packet is delayed
+	OnSendError  func(addr net.Addr, size int, err error)       // Called when the underlying connection fails to send data (see ErrSendFailed)
+}
+
+// DirectionConfig defines the simulated network conditions for a single
+// direction of traffic, allowing asymmetric links to be modeled. When set,
+// it takes precedence over the top-level fields of Config, including changes
+// made through the Config setters.
+type DirectionConfig struct {
+	Latency         time.Duration         // Base latency
+	Jitter          time.Duration         // Maximum additional latency
+	SymmetricJitter bool                  // Vary latency by up to Jitter/2 either way, rather than only adding up to Jitter
+	LatencyDist     LatencyDistribution   // Latency distribution, overriding Latency and Jitter (optional)
+	SpikeRate       float64               // Rate at which a packet gets SpikeLatency added to its latency (0.0 to 1.0)
+	SpikeLatency    time.Duration         // Latency added by a spike, as by a GC pause or router hiccup
+	Bandwidth       int64                 // Bytes per second (0 means unlimited)
+	BandwidthFunc   func(time.Time) int64 // Bytes per second at time t, overriding Bandwidth (optional)
+	Burst           int64                 // Bytes that may be sent at once (0 means one second of bandwidth)
+	LossRate        float64               // Packet loss rate (0.0 to 1.0)
+	BitErrorRate    float64               // Probability of flipping each bit of a delivered payload (0.0 to 1.0)
+	ReorderRate     float64               // Packet reorder rate (0.0 to 1.0)
+	Reorder         *ReorderConfig        // Bounded reordering for packet conns, overriding ReorderRate (optional)
+	DuplicateRate   float64               // Packet duplication rate (0.0 to 1.0)
+	MaxDuplicates   int                   // Most duplicates delivered of a duplicated packet (0 means 1)
+	DuplicateDelay  time.Duration         // Extra delay of each duplicate on packet conns, drawn between half of it and all of it
 }
 
+// ReorderConfig defines bounded reordering for packet conns. A packet selected
+// for reordering is held back until Gap later packets have been delivered, so
+// it is displaced by exactly Gap positions while traffic keeps flowing.
+type ReorderConfig struct {
+	Gap         int           // Later packets delivered before a held packet
+	Probability float64       // Probability a packet is held (0.0 to 1.0)
+	Timeout     time.Duration // Longest a packet is held if fewer than Gap packets follow (0 means 100ms)
+}
+
+// direction identifies which way traffic is flowing on a connection.
+type direction int
+
+const (
+	inbound  direction = iota // Traffic read from the network
+	outbound                  // Traffic written to the network
+)
+
 // Option defines a functional option for configuring network conditions.
 type Option func(*Config)
 
@@ -37,6 +152,34 @@ func WithJitter(jitter time.Duration) Option {
 	}
 }
 
+// WithSymmetricJitter makes jitter vary the latency by up to half of Jitter
+// either way, so that the mean latency is the base latency, rather than only
+// adding up to Jitter to it. Latency is never negative.
+func WithSymmetricJitter(symmetric bool) Option {
+	return func(cfg *Config) {
+		cfg.SymmetricJitter = symmetric
+	}
+}
+
+// WithLatencyDistribution sets the distribution latency is sampled from,
+// overriding the base latency and jitter.
+func WithLatencyDistribution(dist LatencyDistribution) Option {
+	return func(cfg *Config) {
+		cfg.LatencyDist = dist
+	}
+}
+
+// WithLatencySpikes adds occasional latency spikes, as from a GC pause or a
+// router hiccup: at the given rate, a packet or write gets the spike latency
+// added on top of its latency. Spikes model tail latency better than uniform
+// jitter.
+func WithLatencySpikes(rate float64, latency time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.SpikeRate = rate
+		cfg.SpikeLatency = latency
+	}
+}
+
 // WithBandwidth sets the bandwidth limit.
 func WithBandwidth(bandwidth int64) Option {
 	return func(cfg *Config) {
@@ -44,6 +187,51 @@ func WithBandwidth(bandwidth int64) Option {
 	}
 }
 
+// WithBandwidthFunc sets a bandwidth limit that varies over time, as on a
+// wireless link. The function is called with the current time of the config's
+// clock for every write or packet, returning the bandwidth in bytes per second
+// at that time, or zero for unlimited bandwidth. It overrides Bandwidth, and
+// must be fast and safe for concurrent use.
+func WithBandwidthFunc(fn func(t time.Time) int64) Option {
+	return func(cfg *Config) {
+		cfg.BandwidthFunc = fn
+	}
+}
+
+// WithAsymmetricBandwidth sets separate bandwidth limits for each direction,
+// in bytes per second, as on a cable or DSL link that downloads faster than
+// it uploads: reads are limited to download and writes to upload. Either may
+// be zero to use Bandwidth instead. Like Bandwidth, they are overridden by
+// BandwidthFunc and by direction configs.
+func WithAsymmetricBandwidth(download, upload int64) Option {
+	return func(cfg *Config) {
+		cfg.DownloadBandwidth = download
+		cfg.UploadBandwidth = upload
+	}
+}
+
+// WithBurst sets the number of bytes that may be sent at once before the
+// bandwidth limit applies.
+func WithBurst(burst int64) Option {
+	return func(cfg *Config) {
+		cfg.Burst = burst
+	}
+}
+
+// WithSharedBandwidth shares the bandwidth limit across every connection
+// created from the config, as on a shared uplink, rather than applying it to
+// each connection separately. It must be set before connections are created.
+//
+// Traffic on a shared link is sent in the order it is written, whichever
+// connection it belongs to, so a bulk transfer queued on one connection holds
+// up the traffic written behind it on others, as head-of-line blocking on a
+// congested uplink does.
+func WithSharedBandwidth(shared bool) Option {
+	return func(cfg *Config) {
+		cfg.SharedBandwidth = shared
+	}
+}
+
 // WithLossRate sets the packet loss rate.
 func WithLossRate(lossRate float64) Option {
 	return func(cfg *Config) {
@@ -51,13 +239,33 @@ func WithLossRate(lossRate float64) Option {
 	}
 }
 
-// WithReorderRate sets the packet reorder rate.
+// WithBitErrorRate flips each bit of delivered payloads independently with
+// the given probability, modeling a noisy physical link whose errors
+// checksums and retransmissions must catch. It applies to payloads that are
+// not lost, before they are delivered.
+func WithBitErrorRate(rate float64) Option {
+	return func(cfg *Config) {
+		cfg.BitErrorRate = rate
+	}
+}
+
+// WithReorderRate sets the packet reorder rate. Stream connections deliver
+// bytes in order, so a reordered write arrives late and holds up the data
+// written after it, rather than being overtaken by it.
 func WithReorderRate(reorderRate float64) Option {
 	return func(cfg *Config) {
 		cfg.ReorderRate = reorderRate
 	}
 }
 
+// WithReorder sets bounded reordering for packet conns, overriding the
+// reorder rate.
+func WithReorder(reorder ReorderConfig) Option {
+	return func(cfg *Config) {
+		cfg.Reorder = &reorder
+	}
+}
+
 // WithDuplicateRate sets the packet duplication rate.
 func WithDuplicateRate(duplicateRate float64) Option {
 	return func(cfg *Config) {
@@ -65,6 +273,216 @@ func WithDuplicateRate(duplicateRate float64) Option {
 	}
 }
 
+// WithMaxDuplicates sets the most duplicates delivered of a duplicated
+// packet. Each time duplication happens, between one and max duplicates are
+// delivered along with the original.
+func WithMaxDuplicates(max int) Option {
+	return func(cfg *Config) {
+		cfg.MaxDuplicates = max
+	}
+}
+
+// WithDuplicateDelay delays each duplicate delivered by packet conns by an
+// extra amount, drawn independently between half of delay and all of it, so
+// that duplicates arrive after the original rather than alongside it.
+func WithDuplicateDelay(delay time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.DuplicateDelay = delay
+	}
+}
+
+// WithCorruptFunc sets a function mangling payloads, such as by flipping
+// bits, truncating, or corrupting headers, to model link-layer corruption
+// that CRCs would normally catch. It is applied to each write to a stream
+// connection and each segment received by one, and to each datagram written
+// or received by a packet conn, before the other conditions, so duplicates
+// of a corrupted payload are corrupted alike. It is passed a copy of the
+// payload, which it may modify, and returns the bytes to deliver. It decides
+// which payloads to corrupt, and must be safe for concurrent use.
+func WithCorruptFunc(fn func(data []byte) []byte) Option {
+	return func(cfg *Config) {
+		cfg.CorruptFunc = fn
+	}
+}
+
+// WithMTU sets the maximum transmission unit. Datagrams larger than it are
+// split into fragments, and are dropped if any fragment is lost.
+func WithMTU(mtu int) Option {
+	return func(cfg *Config) {
+		cfg.MTU = mtu
+	}
+}
+
+// WithQueueSize sets how many packets or writes each connection queues in
+// each direction, like a socket buffer.
+//
+// A stream connection's Write blocks while the queue is full of writes still
+// being delayed. A packet conn's delayed packets wait for room in the queue
+// before being delivered, holding up the packets behind them: incoming
+// packets until they are read, unless WithTailDrop is set, and outgoing
+// packets until they are written to the underlying connection.
+func WithQueueSize(size int) Option {
+	return func(cfg *Config) {
+		cfg.QueueSize = size
+	}
+}
+
+// WithReadBufferSize sets the size of the buffer packet conns read datagrams
+// from the underlying connection into, which defaults to 65535 bytes, the
+// largest UDP datagram. Datagrams larger than it are truncated, as by a socket
+// reading into a buffer that is too small. Read buffers of the default size
+// are pooled across packet conns.
+func WithReadBufferSize(n int) Option {
+	return func(cfg *Config) {
+		cfg.ReadBufferSize = n
+	}
+}
+
+// WithAutoRetransmit makes packet conns retransmit a lost packet after the
+// timeout, up to maxRetries times, as a reliable protocol over an unreliable
+// link would. Each retransmission may be lost again, and is counted in
+// Stats.PacketsDropped if it is. It serves as a reference for the delivery
+// rate of protocols that recover from loss.
+func WithAutoRetransmit(maxRetries int, timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.MaxRetransmits = maxRetries
+		cfg.RetransmitTimeout = timeout
+	}
+}
+
+// WithLinkBuffer bounds the bytes in flight on packet conns, sent but not yet
+// delivered after their simulated delay, to the given size in each
+// direction, as the buffer of a router or modem does. Sized near the
+// bandwidth-delay product, it models a realistic link; sized well beyond it,
+// it models bufferbloat. While the buffer is full, packets wait for room,
+// adding queueing delay, or are dropped with WithLinkBufferDrop.
+//
+// Packets written by a single writer only fill the buffer if writes return
+// before delivery, as with WithInOrderDelivery or WithDeterministic, since
+// otherwise each write waits for its packet to be delivered.
+func WithLinkBuffer(bytes int) Option {
+	return func(cfg *Config) {
+		cfg.LinkBuffer = bytes
+	}
+}
+
+// WithLinkBufferDrop makes packet conns drop packets arriving at a full link
+// buffer, as a router's tail drop does, rather than holding them until there
+// is room. Dropped packets are counted as lost, and may be retransmitted with
+// WithAutoRetransmit.
+func WithLinkBufferDrop(drop bool) Option {
+	return func(cfg *Config) {
+		cfg.LinkBufferDrop = drop
+	}
+}
+
+// WithTailDrop makes packet conns drop incoming packets when the read queue
+// is full, as a socket does when its receive buffer overflows, rather than
+// holding them until there is room. Dropped packets are counted in
+// Stats.PacketsOverflowed.
+func WithTailDrop(tailDrop bool) Option {
+	return func(cfg *Config) {
+		cfg.TailDrop = tailDrop
+	}
+}
+
+// WithWriteErrorOnLoss makes WriteTo on packet conns return
+// ErrPacketDropped when the packet is lost, as a congested link might fail
+// the send, rather than dropping it silently.
+func WithWriteErrorOnLoss(fail bool) Option {
+	return func(cfg *Config) {
+		cfg.WriteErrorOnLoss = fail
+	}
+}
+
+// WithConnectLatency makes Dialer take the given time to establish each
+// connection, as a slow handshake or retransmitted SYN would, before the
+// connection is returned. It is separate from the latency applied to data.
+func WithConnectLatency(latency time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ConnectLatency = latency
+	}
+}
+
+// WithConnectFailureRate makes Dialer fail to establish connections at the
+// given rate, as if they were refused, with an error for which errors.Is
+// reports syscall.ECONNREFUSED.
+func WithConnectFailureRate(rate float64) Option {
+	return func(cfg *Config) {
+		cfg.ConnectFailureRate = rate
+	}
+}
+
+// WithPartitionTimeout makes Dialer wait for the given duration before
+// failing to dial a partitioned address, as a dial to a black-holed host
+// times out rather than being refused. The error returned is a timeout, for
+// which errors.Is still reports ErrNetworkPartitioned. The dial gives up
+// sooner if its context is done, or the underlying dialer's Timeout, if set,
+// is shorter.
+func WithPartitionTimeout(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.PartitionTimeout = d
+	}
+}
+
+// WithResetRate makes each write to a stream connection, and each segment
+// received from it, reset the connection instead at the given rate, as if the
+// peer had sent a RST. Reads and writes on a reset connection fail with an
+// error for which errors.Is reports syscall.ECONNRESET, and the underlying
+// connection is closed.
+func WithResetRate(rate float64) Option {
+	return func(cfg *Config) {
+		cfg.ResetRate = rate
+	}
+}
+
+// WithResetAfter resets stream connections once they have been open for the
+// given duration, as described by WithResetRate.
+func WithResetAfter(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ResetAfter = d
+	}
+}
+
+// WithMaxConns limits the connections a Listener keeps open at once, as on a
+// server with a connection cap. Once n accepted connections are open, further
+// connections are left queued by the underlying listener, as in a full accept
+// queue, until one of them is closed, unless WithRejectOverMaxConns is set.
+func WithMaxConns(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxConns = n
+	}
+}
+
+// WithRejectOverMaxConns makes a Listener close connections arriving while
+// MaxConns connections are open, as when an accept queue overflows, rather
+// than leaving them queued. The clients see the connection reset or closed.
+func WithRejectOverMaxConns(reject bool) Option {
+	return func(cfg *Config) {
+		cfg.RejectOverMaxConns = reject
+	}
+}
+
+// WithDontFragment makes WriteTo on packet conns fail for datagrams larger
+// than the MTU, as on a socket with the don't fragment (DF) bit set, rather
+// than splitting them into fragments. The error is a *net.OpError for which
+// errors.Is reports syscall.EMSGSIZE.
+func WithDontFragment(dontFragment bool) Option {
+	return func(cfg *Config) {
+		cfg.DontFragment = dontFragment
+	}
+}
+
+// WithStrictDatagramTruncation makes ReadFrom on packet conns return
+// ErrDatagramTruncated, along with the part of the datagram that fit, when
+// the buffer is too small for the datagram. Otherwise the rest of the
+// datagram is discarded silently, as by most platforms.
+func WithStrictDatagramTruncation(strict bool) Option {
+	return func(cfg *Config) {
+		cfg.StrictDatagramTruncation = strict
+	}
+}
+
 // WithPartitionedAddrs adds partitioned addresses (that are unreachable).
 func WithPartitionedAddrs(partitionedAddrs map[string]bool) Option {
 	return func(cfg *Config) {
@@ -74,16 +492,173 @@ func WithPartitionedAddrs(partitionedAddrs map[string]bool) Option {
 		for addr, val := range partitionedAddrs {
 			cfg.PartitionedAddrs[addr] = val
 		}
+		cfg.partitions = nil
 	}
 }
 
-// WithSeed sets the seed for randomness.
+// WithResolverFailAddrs adds hostnames that Resolver fails to look up, with
+// the error returned for each, such as ErrNoSuchHost.
+func WithResolverFailAddrs(failAddrs map[string]error) Option {
+	return func(cfg *Config) {
+		if cfg.ResolverFailAddrs == nil {
+			cfg.ResolverFailAddrs = make(map[string]error)
+		}
+		for host, err := range failAddrs {
+			cfg.ResolverFailAddrs[host] = err
+		}
+	}
+}
+
+// WithAddrConditions sets the conditions for traffic to and from specific
+// remote addresses, such as "10.0.0.2:8080", or hosts, such as "10.0.0.2",
+// in both directions. They take precedence over the direction configs and
+// the top-level fields, allowing each peer in a simulation to be a different
+// distance away.
+func WithAddrConditions(conditions map[string]DirectionConfig) Option {
+	return func(cfg *Config) {
+		if cfg.AddrConditions == nil {
+			cfg.AddrConditions = make(map[string]DirectionConfig)
+		}
+		for addr, cond := range conditions {
+			cfg.AddrConditions[addr] = cond
+		}
+	}
+}
+
+// WithInbound sets the conditions applied to inbound traffic.
+func WithInbound(inbound DirectionConfig) Option {
+	return func(cfg *Config) {
+		cfg.Inbound = &inbound
+	}
+}
+
+// WithOutbound sets the conditions applied to outbound traffic.
+func WithOutbound(outbound DirectionConfig) Option {
+	return func(cfg *Config) {
+		cfg.Outbound = &outbound
+	}
+}
+
+// WithSeed sets the seed for randomness. Each connection draws from its own
+// generator derived from the seed, in the order connections are created, so
+// one connection's decisions do not depend on traffic on another.
 func WithSeed(seed int64) Option {
 	return func(cfg *Config) {
 		cfg.Seed = seed
 	}
 }
 
+// WithRandSource sets the source of randomness for simulated decisions,
+// overriding the seed, so that they can be driven by any generator, such as
+// a PCG or a fuzzer's input. The source may be shared by several configs,
+// since it is only used while holding a lock. It must be set before
+// connections are created.
+func WithRandSource(src rand.Source) Option {
+	return func(cfg *Config) {
+		cfg.RandSource = src
+	}
+}
+
+// WithOnDrop sets the callback called when a packet is lost.
+func WithOnDrop(fn func(addr net.Addr, size int)) Option {
+	return func(cfg *Config) {
+		cfg.OnDrop = fn
+	}
+}
+
+// WithOnDropTagged sets the callback called when a packet written with a tag
+// by TaggedWriter is lost, so that tests can tell which application messages
+// were affected. It is called in addition to the OnDrop callback.
+func WithOnDropTagged(fn func(addr net.Addr, size int, tag string)) Option {
+	return func(cfg *Config) {
+		cfg.OnDropTagged = fn
+	}
+}
+
+// WithOnDuplicate sets the callback called when a packet is duplicated.
+func WithOnDuplicate(fn func(addr net.Addr, size int)) Option {
+	return func(cfg *Config) {
+		cfg.OnDuplicate = fn
+	}
+}
+
+// WithOnReorder sets the callback called when a packet is reordered.
+func WithOnReorder(fn func(addr net.Addr, size int)) Option {
+	return func(cfg *Config) {
+		cfg.OnReorder = fn
+	}
+}
+
+// WithOnDelay sets the callback called when a packet is delayed.
+func WithOnDelay(fn func(addr net.Addr, size int, d time.Duration)) Option {
+	return func(cfg *Config) {
+		cfg.OnDelay = fn
+	}
+}
+
+// WithOnSendError sets the callback called when the underlying connection
+// fails to send data. The write has already returned by then, so the failure
+// is otherwise only reported by later calls, as ErrSendFailed.
+func WithOnSendError(fn func(addr net.Addr, size int, err error)) Option {
+	return func(cfg *Config) {
+		cfg.OnSendError = fn
+	}
+}
+
+// WithLogger sets a logger that records every simulated drop, duplication,
+// reordering, and delay at debug level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *Config) {
+		cfg.Logger = logger
+	}
+}
+
+// WithClock sets the source of time for simulated delays, such as a
+// FakeClock in tests.
+func WithClock(clock Clock) Option {
+	return func(cfg *Config) {
+		cfg.Clock = clock
+	}
+}
+
+// WithDeterministic routes all delayed delivery through a single scheduler
+// per connection and direction, ordered by virtual delivery time rather than
+// by goroutine scheduling. Combined with WithSeed, the same sequence of writes
+// always produces the same sequence of deliveries.
+func WithDeterministic(deterministic bool) Option {
+	return func(cfg *Config) {
+		cfg.Deterministic = deterministic
+	}
+}
+
+// WithInOrderDelivery makes packet conns deliver packets in the order they
+// were sent, as on a link that queues packets rather than letting them
+// overtake each other: each packet is delivered once its own delay has
+// elapsed, but never before a packet sent ahead of it. Without it, jitter
+// alone can reorder packets. Packets reordered on purpose, by ReorderRate or
+// Reorder, and duplicates with a DuplicateDelay are still delivered out of
+// order. Stream connections always deliver data in order. It must be set
+// before connections are created.
+func WithInOrderDelivery(inOrder bool) Option {
+	return func(cfg *Config) {
+		cfg.InOrderDelivery = inOrder
+	}
+}
+
+// WithNATRebind makes packet conns send from a new source port every
+// interval, as a NAT does when it drops a mapping and makes a new one, for
+// testing NAT traversal and connection migration. Peers see packets arrive
+// from the new address, and packets they send to the old one are no longer
+// received. The local address of the conn does not change, as the host
+// behind a NAT does not see its mapping. A conn is rebound when it sends
+// after the interval has passed, and only if its local address is an IP
+// address. Zero, the default, disables rebinding.
+func WithNATRebind(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.NATRebind = interval
+	}
+}
+
 // apply applies the options to the config.
 func (cfg *Config) apply(opts ...Option) {
 	for _, opt := range opts {
@@ -108,18 +683,240 @@ func NewConfig(opts ...Option) *Config {
 	return cfg
 }
 
-// randSource initializes or returns the existing rand.Rand with the given seed.
-func (cfg *Config) randSource() *rand.Rand {
+// Passthrough returns a Config whose stream connections, created by Dialer,
+// Listener, or Pipe, delegate reads and writes directly to the underlying
+// connections, without the goroutines and buffering that apply simulated
+// conditions. It serves as a baseline for measuring the overhead of the
+// simulation, running the same code path with no effect. Conditions set on
+// the config are ignored by its stream connections, though partitions still
+// apply to dials. Packet conns are simulated as usual.
+func Passthrough() *Config {
+	cfg := NewConfig()
+	cfg.passthrough = true
+	return cfg
+}
+
+// merge returns a new Config with the exported fields of cfg, overridden by
+// the fields of override that are not the zero value. Maps are cloned, and
+// partition groups, flapping partitions, and any trace are kept from cfg, so
+// that the merged config is a snapshot that later changes to either config
+// do not affect.
+func (cfg *Config) merge(override *Config) *Config {
+	merged := NewConfig()
+	dst := reflect.ValueOf(merged).Elem()
+
+	cfg.mu.Lock()
+	copyFields(dst, reflect.ValueOf(cfg).Elem(), false)
+	merged.partitionGroups = slices.Clone(cfg.partitionGroups)
+	merged.flapping = slices.Clone(cfg.flapping)
+	merged.trace = cfg.trace
+	merged.passthrough = cfg.passthrough
+	merged.totals = cfg.totalStatsLocked()
+	cfg.mu.Unlock()
+
+	override.mu.Lock()
+	copyFields(dst, reflect.ValueOf(override).Elem(), true)
+	merged.passthrough = merged.passthrough || override.passthrough
+	override.mu.Unlock()
+
+	merged.PartitionedAddrs = maps.Clone(merged.PartitionedAddrs)
+	if merged.PartitionedAddrs == nil {
+		merged.PartitionedAddrs = make(map[string]bool)
+	}
+	merged.ResolverFailAddrs = maps.Clone(merged.ResolverFailAddrs)
+	merged.AddrConditions = maps.Clone(merged.AddrConditions)
+	return merged
+}
+
+// copyFields copies the exported fields of the Config src to dst, skipping
+// zero values if nonZero is set.
+func copyFields(dst, src reflect.Value, nonZero bool) {
+	for i := range src.NumField() {
+		if !src.Type().Field(i).IsExported() || (nonZero && src.Field(i).IsZero()) {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+}
+
+// deriveRand returns a random number generator for a new connection. Each
+// connection gets its own generator, seeded from Seed and the number of
+// generators derived before it, so that its decisions do not depend on those
+// of other connections. A RandSource is shared by every connection instead.
+func (cfg *Config) deriveRand() *rand.Rand {
 	cfg.mu.Lock()
 	defer cfg.mu.Unlock()
-	if cfg.rand == nil {
-		if cfg.Seed != 0 {
-			cfg.rand = rand.New(rand.NewSource(cfg.Seed))
-		} else {
-			cfg.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return cfg.deriveRandLocked()
+}
+
+// deriveRandLocked is deriveRand for callers holding cfg.mu.
+func (cfg *Config) deriveRandLocked() *rand.Rand {
+	if cfg.RandSource != nil {
+		if cfg.rand == nil {
+			cfg.rand = rand.New(cfg.wrapSource(0, newLockedSource(cfg.RandSource)))
 		}
+		return cfg.rand
+	}
+
+	seed := time.Now().UnixNano()
+	if cfg.Seed != 0 {
+		seed = cfg.Seed + cfg.derived
+	}
+	cfg.derived++
+	return rand.New(cfg.wrapSource(cfg.derived, rand.NewSource(seed)))
+}
+
+// wrapSource wraps the random source of the given connection to replay the
+// values recorded for it by ReplayConfig, and to record the values drawn
+// with WithTraceWriter. Connections sharing a RandSource are connection 0.
+// The caller must hold cfg.mu.
+func (cfg *Config) wrapSource(conn int64, src rand.Source) rand.Source {
+	if cfg.replay != nil {
+		src = &replaySource{values: cfg.replay.draws[conn], src: src}
+	}
+	if t := cfg.tracerLocked(); t != nil {
+		src = &recordingSource{src: src, tracer: t, conn: conn}
+	}
+	return src
+}
+
+// connect returns how long establishing a connection takes, and whether it
+// fails.
+func (cfg *Config) connect() (time.Duration, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.ConnectFailureRate <= 0 {
+		return cfg.ConnectLatency, false
 	}
-	return cfg.rand
+	if cfg.dialRand == nil {
+		cfg.dialRand = cfg.deriveRandLocked()
+	}
+	return cfg.ConnectLatency, cfg.dialRand.Float64() < cfg.ConnectFailureRate
+}
+
+// lockedSource is a rand.Source safe for concurrent use, so that a source
+// given by the user may be shared by several configs.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+// newLockedSource returns a lockedSource drawing from src.
+func newLockedSource(src rand.Source) *lockedSource {
+	return &lockedSource{src: src}
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer from the source.
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+// Seed seeds the source.
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// clock returns the source of time for simulated delays.
+func (cfg *Config) clock() Clock {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.clockLocked()
+}
+
+// clockLocked returns the source of time for simulated delays. The caller
+// must hold cfg.mu.
+func (cfg *Config) clockLocked() Clock {
+	if cfg.Clock == nil {
+		return realClock{}
+	}
+	return cfg.Clock
+}
+
+// defaultQueueSize is the queue size used when Config.QueueSize is unset.
+const defaultQueueSize = 100
+
+// queueSize returns the number of packets or writes queued per connection
+// and direction.
+func (cfg *Config) queueSize() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.QueueSize <= 0 {
+		return defaultQueueSize
+	}
+	return cfg.QueueSize
+}
+
+// linkBuffer returns the size of the link buffer of packet conns, with zero
+// meaning unlimited, and whether packets are dropped when it is full.
+func (cfg *Config) linkBuffer() (int, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.LinkBuffer, cfg.LinkBufferDrop
+}
+
+// defaultReadBufferSize is the read buffer size used when
+// Config.ReadBufferSize is unset, large enough for any UDP datagram.
+const defaultReadBufferSize = 65535
+
+// readBufferSize returns the size of the buffer packet conns read datagrams
+// into.
+func (cfg *Config) readBufferSize() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.ReadBufferSize <= 0 {
+		return defaultReadBufferSize
+	}
+	return cfg.ReadBufferSize
+}
+
+// Stats returns statistics summed over every connection created from the
+// config, including closed ones, such as to export them as metrics while a
+// long-running test is underway. Connections dialed with an override by
+// Dialer.DialWithConfig count towards the dialer's config.
+func (cfg *Config) Stats() Stats {
+	return cfg.totalStats().snapshot()
+}
+
+// totalStats returns the statistics summed over every connection created from
+// the config, which connections link their own statistics to.
+func (cfg *Config) totalStats() *stats {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.totalStatsLocked()
+}
+
+// totalStatsLocked is like totalStats, but requires the caller to hold cfg.mu.
+func (cfg *Config) totalStatsLocked() *stats {
+	if cfg.totals == nil {
+		cfg.totals = new(stats)
+	}
+	return cfg.totals
+}
+
+// maxConns returns the connections a Listener keeps open at once, with zero
+// meaning unlimited, and whether connections beyond them are rejected.
+func (cfg *Config) maxConns() (int, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.MaxConns, cfg.RejectOverMaxConns
+}
+
+// isDeterministic reports whether delayed delivery is deterministic.
+func (cfg *Config) isDeterministic() bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.Deterministic
+}
+
+// isInOrderDelivery reports whether packet conns deliver packets in order.
+func (cfg *Config) isInOrderDelivery() bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.InOrderDelivery
 }
 
 // AddPartition adds an address to the partitioned addresses.
@@ -130,6 +927,7 @@ func (cfg *Config) AddPartition(address string) {
 		cfg.PartitionedAddrs = make(map[string]bool)
 	}
 	cfg.PartitionedAddrs[address] = true
+	cfg.partitions = nil
 }
 
 // RemovePartition removes an address from the partitioned addresses.
@@ -137,4 +935,226 @@ func (cfg *Config) RemovePartition(address string) {
 	cfg.mu.Lock()
 	defer cfg.mu.Unlock()
 	delete(cfg.PartitionedAddrs, address)
+	cfg.partitions = nil
+	cfg.flapping = slices.DeleteFunc(cfg.flapping, func(fp flappingPartition) bool {
+		return fp.address == address
+	})
+}
+
+// SetAddrConditions sets the conditions for traffic to and from the given
+// remote address or host, taking effect on live connections.
+func (cfg *Config) SetAddrConditions(address string, cond DirectionConfig) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.AddrConditions == nil {
+		cfg.AddrConditions = make(map[string]DirectionConfig)
+	}
+	cfg.AddrConditions[address] = cond
+}
+
+// RemoveAddrConditions removes the conditions for the given remote address
+// or host, so its traffic falls back to the rest of the config.
+func (cfg *Config) RemoveAddrConditions(address string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	delete(cfg.AddrConditions, address)
+}
+
+// SetLatency sets the base latency, taking effect on live connections.
+func (cfg *Config) SetLatency(latency time.Duration) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.Latency = latency
+}
+
+// SetJitter sets the maximum additional latency, taking effect on live connections.
+func (cfg *Config) SetJitter(jitter time.Duration) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.Jitter = jitter
+}
+
+// SetBandwidth sets the bandwidth limit, taking effect on live connections.
+func (cfg *Config) SetBandwidth(bandwidth int64) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.Bandwidth = bandwidth
+}
+
+// SetLossRate sets the packet loss rate, taking effect on live connections.
+func (cfg *Config) SetLossRate(lossRate float64) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.LossRate = lossRate
+}
+
+// SetReorderRate sets the packet reorder rate, taking effect on live connections.
+func (cfg *Config) SetReorderRate(reorderRate float64) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.ReorderRate = reorderRate
+}
+
+// SetDuplicateRate sets the packet duplication rate, taking effect on live connections.
+func (cfg *Config) SetDuplicateRate(duplicateRate float64) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.DuplicateRate = duplicateRate
+}
+
+// conditions returns the network conditions for traffic to or from addr in
+// the given direction. Conditions for the address or its host take
+// precedence, then the direction config, falling back to the top-level
+// fields. The address may be nil.
+func (cfg *Config) conditions(dir direction, addr net.Addr) DirectionConfig {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if addr != nil && len(cfg.AddrConditions) > 0 {
+		address := addr.String()
+		if dc, ok := cfg.AddrConditions[address]; ok {
+			return dc
+		}
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			if dc, ok := cfg.AddrConditions[host]; ok {
+				return dc
+			}
+		}
+	}
+
+	dc := cfg.Inbound
+	if dir == outbound {
+		dc = cfg.Outbound
+	}
+	if dc != nil {
+		return *dc
+	}
+	top := DirectionConfig{
+		Latency:         cfg.Latency,
+		Jitter:          cfg.Jitter,
+		SymmetricJitter: cfg.SymmetricJitter,
+		LatencyDist:     cfg.LatencyDist,
+		SpikeRate:       cfg.SpikeRate,
+		SpikeLatency:    cfg.SpikeLatency,
+		Bandwidth:       cfg.Bandwidth,
+		BandwidthFunc:   cfg.BandwidthFunc,
+		Burst:           cfg.Burst,
+		LossRate:        cfg.LossRate,
+		BitErrorRate:    cfg.BitErrorRate,
+		ReorderRate:     cfg.ReorderRate,
+		Reorder:         cfg.Reorder,
+		DuplicateRate:   cfg.DuplicateRate,
+		MaxDuplicates:   cfg.MaxDuplicates,
+		DuplicateDelay:  cfg.DuplicateDelay,
+	}
+	if dir == inbound && cfg.DownloadBandwidth > 0 {
+		top.Bandwidth = cfg.DownloadBandwidth
+	}
+	if dir == outbound && cfg.UploadBandwidth > 0 {
+		top.Bandwidth = cfg.UploadBandwidth
+	}
+	if cfg.trace != nil {
+		clock := cfg.Clock
+		if clock == nil {
+			clock = realClock{}
+		}
+		if sample, ok := cfg.trace.at(clock.Now()); ok {
+			top.Latency = sample.latency
+			top.LossRate = sample.lossRate
+			top.Bandwidth = sample.bandwidth
+		}
+	}
+	return top
+}
+
+// latency calculates the propagation latency based on the conditions,
+// including any spike. Bandwidth limits are applied separately by a bucket.
+func (dc DirectionConfig) latency(r *rand.Rand) time.Duration {
+	var latency time.Duration
+	if dc.LatencyDist != nil {
+		latency = dc.LatencyDist.Sample(r)
+	} else {
+		latency = dc.Latency
+		if dc.Jitter > 0 {
+			jitter := time.Duration(r.Int63n(int64(dc.Jitter)))
+			if dc.SymmetricJitter {
+				jitter -= dc.Jitter / 2
+			}
+			latency = max(latency+jitter, 0)
+		}
+	}
+	if dc.SpikeRate > 0 && r.Float64() < dc.SpikeRate {
+		latency += dc.SpikeLatency
+	}
+	return latency
+}
+
+// flipBits flips each bit of data with probability BitErrorRate, returning
+// the number of bits flipped. Rather than drawing for every bit, the gaps
+// between flipped bits are drawn from the geometric distribution.
+func (dc DirectionConfig) flipBits(data []byte, r *rand.Rand) int {
+	if dc.BitErrorRate <= 0 {
+		return 0
+	}
+
+	bits := len(data) * 8
+	flipped := 0
+	for pos := 0; ; pos++ {
+		if dc.BitErrorRate < 1 {
+			gap := math.Floor(math.Log(1-r.Float64()) / math.Log1p(-dc.BitErrorRate))
+			if gap >= float64(bits-pos) {
+				return flipped
+			}
+			pos += int(gap)
+		}
+		if pos >= bits {
+			return flipped
+		}
+		data[pos/8] ^= 1 << (pos % 8)
+		flipped++
+	}
+}
+
+// loss determines if a packet should be dropped based on the loss rate.
+func (dc DirectionConfig) loss(r *rand.Rand) bool {
+	return dc.LossRate > 0 && r.Float64() < dc.LossRate
+}
+
+// reorder determines if a packet should be reordered based on the reorder rate.
+func (dc DirectionConfig) reorder(r *rand.Rand) bool {
+	return dc.ReorderRate > 0 && r.Float64() < dc.ReorderRate
+}
+
+// duplicateDelay returns the extra delay of a duplicate, between half of
+// DuplicateDelay and all of it.
+func (dc DirectionConfig) duplicateDelay(r *rand.Rand) time.Duration {
+	if dc.DuplicateDelay <= 0 {
+		return 0
+	}
+	half := dc.DuplicateDelay / 2
+	return dc.DuplicateDelay - time.Duration(r.Int63n(int64(half)+1))
+}
+
+// corrupt returns data as mangled by Config.CorruptFunc, if set, without
+// holding cfg.mu. The data must be a copy owned by the caller.
+func (cfg *Config) corrupt(data []byte) []byte {
+	cfg.mu.Lock()
+	fn := cfg.CorruptFunc
+	cfg.mu.Unlock()
+	if fn == nil {
+		return data
+	}
+	return fn(data)
+}
+
+// duplicates determines how many duplicates of a packet to deliver based on
+// the duplicate rate: none, or between one and MaxDuplicates.
+func (dc DirectionConfig) duplicates(r *rand.Rand) int {
+	if dc.DuplicateRate <= 0 || r.Float64() >= dc.DuplicateRate {
+		return 0
+	}
+	if dc.MaxDuplicates <= 1 {
+		return 1
+	}
+	return 1 + r.Intn(dc.MaxDuplicates)
 }
@@ -0,0 +1,568 @@
+package simnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// startEchoServer starts a TCP server that echoes everything it reads back
+// to the client, returning its address.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestDirectionConfig(t *testing.T) {
+	const latency = 200 * time.Millisecond
+
+	t.Run("outbound latency applies to writes", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig(simnet.WithOutbound(simnet.DirectionConfig{
+			Latency: latency,
+		}))
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		// Writes return without waiting for the latency, which delays the
+		// echo instead.
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		must.Less(t, latency, time.Since(start))
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+		must.Between(t, latency, time.Since(start), 2*latency)
+	})
+
+	t.Run("inbound latency applies to reads", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{
+			Latency: latency,
+		}))
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		must.Less(t, latency, time.Since(start))
+
+		buf := make([]byte, 4)
+		start = time.Now()
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+		must.GreaterEq(t, latency, time.Since(start))
+	})
+
+	t.Run("top-level fields apply when direction is unset", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(latency),
+			simnet.WithInbound(simnet.DirectionConfig{}),
+		)
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		// Writes return without waiting for the latency, which delays the
+		// echo instead.
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		must.Less(t, latency, time.Since(start))
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+		must.Between(t, latency, time.Since(start), 2*latency)
+	})
+
+	t.Run("inbound loss drops only received datagrams", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		cfg := simnet.NewConfig(simnet.WithInbound(simnet.DirectionConfig{
+			LossRate: 1.0,
+		}))
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+
+		// The outbound datagram reaches the peer.
+		buf := make([]byte, 4)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := peer.ReadFrom(buf)
+		must.NoError(t, err)
+		must.Eq(t, "ping", string(buf[:n]))
+
+		// The reply is dropped on the inbound path.
+		_, err = peer.WriteTo([]byte("pong"), addr)
+		must.NoError(t, err)
+
+		read := make(chan struct{})
+		go func() {
+			conn.ReadFrom(buf)
+			close(read)
+		}()
+
+		select {
+		case <-read:
+			t.Fatal("expected inbound datagram to be dropped")
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+}
+
+func TestAsymmetricBandwidth(t *testing.T) {
+	const (
+		download = 400_000 // 400KBps
+		upload   = 100_000 // 100KBps
+		size     = 40_000
+	)
+
+	// The server sends size bytes to each client, and reads size bytes
+	// from it, unsimulated, so only the client's limits apply.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	received := make(chan time.Time, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go conn.Write(make([]byte, size))
+		io.CopyN(io.Discard, conn, size)
+		received <- time.Now()
+	}()
+
+	cfg := simnet.NewConfig(
+		simnet.WithAsymmetricBandwidth(download, upload),
+		simnet.WithBurst(1_000),
+	)
+	conn, err := simnet.NewDialer(cfg).Dial("tcp", ln.Addr().String())
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	start := time.Now()
+	go conn.Write(make([]byte, size))
+	_, err = io.CopyN(io.Discard, conn, size)
+	must.NoError(t, err)
+	down := float64(size) / time.Since(start).Seconds()
+	up := float64(size) / (<-received).Sub(start).Seconds()
+
+	must.Between(t, 0.8*download, down, 1.2*download)
+	must.Between(t, 0.8*upload, up, 1.2*upload)
+}
+
+func TestNilConfig(t *testing.T) {
+	// echo makes a round trip over a stream connection.
+	echo := func(t *testing.T, conn net.Conn, err error) {
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must.NoError(t, err)
+		must.Eq(t, "ping", string(buf))
+	}
+
+	t.Run("dialers", func(t *testing.T) {
+		addr := startEchoServer(t)
+		for _, d := range []*simnet.Dialer{
+			simnet.NewDialer(nil),
+			simnet.NewDialerWith(nil, net.Dialer{}),
+			simnet.NewDialerFrom(nil, "127.0.0.1:0"),
+		} {
+			conn, err := d.Dial("tcp", addr)
+			echo(t, conn, err)
+		}
+	})
+
+	t.Run("listener", func(t *testing.T) {
+		conn, err := net.Dial("tcp", startSimulatedEchoServer(t, nil))
+		echo(t, conn, err)
+	})
+
+	t.Run("wrapped conn", func(t *testing.T) {
+		conn, err := net.Dial("tcp", startEchoServer(t))
+		must.NoError(t, err)
+		echo(t, simnet.WrapConn(conn, nil), nil)
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		a, b := simnet.Pipe(nil)
+		t.Cleanup(func() {
+			b.Close()
+		})
+		go io.Copy(b, b)
+		echo(t, a, nil)
+	})
+
+	t.Run("packet conns", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+		go echoUDP(peer)
+
+		inner, err := net.ListenPacket("udp", "127.0.0.1:0")
+		must.NoError(t, err)
+		wrapped := simnet.WrapPacketConn(inner, nil)
+		udp, err := simnet.UDPConn(nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		for _, conn := range []net.PacketConn{wrapped, udp} {
+			t.Cleanup(func() {
+				conn.Close()
+			})
+			_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+			must.NoError(t, err)
+			buf := make([]byte, 1024)
+			n, _, err := conn.ReadFrom(buf)
+			must.NoError(t, err)
+			must.Eq(t, "ping", string(buf[:n]))
+		}
+
+		conn, err := simnet.DialUDP(nil, nil, peer.LocalAddr().(*net.UDPAddr))
+		echo(t, conn, err)
+	})
+
+	t.Run("resolver", func(t *testing.T) {
+		r := simnet.NewResolverWithServer(nil, startDNSServer(t))
+		addrs, err := r.LookupHost(context.Background(), "service.simnet.test.")
+		must.NoError(t, err)
+		must.Eq(t, []string{"192.0.2.1"}, addrs)
+	})
+
+	t.Run("topology link", func(t *testing.T) {
+		addr := startEchoServer(t)
+		topo := simnet.NewTopology()
+		topo.AddNode("a", "127.0.0.1:0")
+		topo.AddNode("b", addr)
+		topo.AddLink("a", "b", nil)
+		conn, err := topo.Dialer("a").Dial("tcp", addr)
+		echo(t, conn, err)
+	})
+}
+
+func TestAddrConditions(t *testing.T) {
+	near := startEchoServer(t)
+	far := startEchoServer(t)
+
+	cfg := simnet.NewConfig(
+		simnet.WithLatency(time.Second),
+		simnet.WithAddrConditions(map[string]simnet.DirectionConfig{
+			near: {Latency: 10 * time.Millisecond},
+		}),
+	)
+	cfg.SetAddrConditions(far, simnet.DirectionConfig{Latency: 100 * time.Millisecond})
+
+	roundTrip := func(t *testing.T, addr string) time.Duration {
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		must.NoError(t, err)
+		return time.Since(start)
+	}
+
+	// Each peer is as far away as configured for it, in both directions,
+	// rather than the top-level latency.
+	must.Between(t, 20*time.Millisecond, roundTrip(t, near), 150*time.Millisecond)
+	must.Between(t, 200*time.Millisecond, roundTrip(t, far), 500*time.Millisecond)
+
+	t.Run("packet conn", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+		go echoUDP(peer)
+
+		// Conditions may also be set for a host, covering every port.
+		cfg := simnet.NewConfig(simnet.WithAddrConditions(map[string]simnet.DirectionConfig{
+			"127.0.0.1": {LossRate: 1.0},
+		}))
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		must.Eq(t, 1, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+
+		cfg.RemoveAddrConditions("127.0.0.1")
+		_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		must.Eq(t, 1, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+	})
+}
+
+// alternatingSource is a rand.Source that alternates between values drawn as
+// 0 and 0.5 by Float64, counting how many it has returned.
+type alternatingSource struct {
+	calls int
+}
+
+func (s *alternatingSource) Int63() int64 {
+	s.calls++
+	if s.calls%2 == 1 {
+		return 0
+	}
+	return 1 << 62
+}
+
+func (s *alternatingSource) Seed(int64) {}
+
+func TestRandSource(t *testing.T) {
+	const packets = 10
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// Each loss decision draws once from the source, so every other packet
+	// is dropped, starting with the first.
+	src := &alternatingSource{}
+	cfg := simnet.NewConfig(simnet.WithLossRate(0.5), simnet.WithRandSource(src))
+	conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	var dropped []int
+	for i := range packets {
+		before := conn.(simnet.StatsProvider).Stats().PacketsDropped
+		_, err := conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		if conn.(simnet.StatsProvider).Stats().PacketsDropped > before {
+			dropped = append(dropped, i)
+		}
+	}
+	must.Eq(t, []int{0, 2, 4, 6, 8}, dropped)
+	must.Eq(t, packets, src.calls)
+}
+
+func TestConnRandIndependent(t *testing.T) {
+	const packets = 20
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+
+	// dropPattern opens two conns sharing a seeded config and returns which
+	// packets written by the first are dropped, with the second writing
+	// between each of them when busy is set.
+	dropPattern := func(busy bool) []int {
+		cfg := simnet.NewConfig(simnet.WithLossRate(0.5), simnet.WithSeed(42))
+		a, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		defer a.Close()
+		b, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		defer b.Close()
+
+		var dropped []int
+		for i := range packets {
+			if busy {
+				for range 3 {
+					_, err := b.WriteTo([]byte("noise"), peer.LocalAddr())
+					must.NoError(t, err)
+				}
+			}
+			before := a.(simnet.StatsProvider).Stats().PacketsDropped
+			_, err := a.WriteTo([]byte("ping"), peer.LocalAddr())
+			must.NoError(t, err)
+			if a.(simnet.StatsProvider).Stats().PacketsDropped > before {
+				dropped = append(dropped, i)
+			}
+		}
+		return dropped
+	}
+
+	quiet := dropPattern(false)
+	must.SliceNotEmpty(t, quiet)
+	must.Less(t, packets, len(quiet))
+	must.Eq(t, quiet, dropPattern(true))
+}
+
+func TestConfigSetters(t *testing.T) {
+	t.Run("packet conn", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+		go echoUDP(peer)
+
+		cfg := simnet.NewConfig()
+
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		received := make(chan string, 10)
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				received <- string(buf[:n])
+			}
+		}()
+
+		_, err = conn.WriteTo([]byte("before"), peer.LocalAddr())
+		must.NoError(t, err)
+
+		select {
+		case msg := <-received:
+			must.Eq(t, "before", msg)
+		case <-time.After(time.Second):
+			t.Fatal("expected echo before loss was enabled")
+		}
+
+		// Drop everything on the live connection.
+		cfg.SetLossRate(1.0)
+
+		_, err = conn.WriteTo([]byte("after"), peer.LocalAddr())
+		must.NoError(t, err)
+
+		select {
+		case msg := <-received:
+			t.Fatalf("expected no echo after loss was enabled, got %q", msg)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		// Latency changes also apply to the live connection.
+		cfg.SetLossRate(0)
+		cfg.SetLatency(100 * time.Millisecond)
+
+		start := time.Now()
+		_, err = conn.WriteTo([]byte("slow"), peer.LocalAddr())
+		must.NoError(t, err)
+
+		select {
+		case msg := <-received:
+			must.Eq(t, "slow", msg)
+			must.GreaterEq(t, 200*time.Millisecond, time.Since(start))
+		case <-time.After(time.Second):
+			t.Fatal("expected echo after loss was disabled")
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig()
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		roundTrip := func(msg string) time.Duration {
+			start := time.Now()
+			_, err := conn.Write([]byte(msg))
+			must.NoError(t, err)
+
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(conn, buf)
+			must.NoError(t, err)
+			must.Eq(t, msg, string(buf))
+			return time.Since(start)
+		}
+
+		must.Less(t, 100*time.Millisecond, roundTrip("before"))
+		must.Eq(t, 0, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+
+		// Lose every segment partway through the stream. Lost segments are
+		// retransmitted, so the stream stays intact but slows down.
+		cfg.SetLossRate(1.0)
+
+		must.GreaterEq(t, 400*time.Millisecond, roundTrip("after"))
+		must.Eq(t, 2, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+
+		cfg.SetLossRate(0)
+
+		must.Less(t, 100*time.Millisecond, roundTrip("recovered"))
+		must.Eq(t, 2, conn.(simnet.StatsProvider).Stats().PacketsDropped)
+	})
+}
@@ -0,0 +1,113 @@
+package simnet
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains runtime statistics about the network conditions simulated
+// on a connection.
+type Stats struct {
+	PacketsSent       int64         // Packets (or writes on a stream) sent, including dropped ones
+	PacketsDropped    int64         // Packets lost to simulated loss (retransmitted on streams)
+	PacketsDuplicated int64         // Duplicate copies delivered by simulated duplication
+	PacketsReordered  int64         // Packets reordered by simulated reordering
+	PacketsOverflowed int64         // Packets dropped because the read queue was full (see WithTailDrop)
+	BytesSent         int64         // Bytes written to the underlying connection
+	BytesReceived     int64         // Bytes returned to readers of the connection
+	TotalLatency      time.Duration // Total simulated delay applied
+}
+
+// StatsProvider is implemented by the simulated connections returned by this
+// package, exposing the statistics collected for each connection.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// stats holds the counters for a connection, updated atomically so they
+// can be collected from any goroutine.
+type stats struct {
+	packetsSent       counter
+	packetsDropped    counter
+	packetsDuplicated counter
+	packetsReordered  counter
+	packetsOverflowed counter
+	bytesSent         counter
+	bytesReceived     counter
+	totalLatency      counter
+}
+
+// counter is a connection statistic, which is also added to the totals of the
+// connection's config once linked to them.
+type counter struct {
+	atomic.Int64
+	total *atomic.Int64
+}
+
+// Add adds delta to the counter and its total, returning the new value of the
+// counter.
+func (c *counter) Add(delta int64) int64 {
+	if c.total != nil {
+		c.total.Add(delta)
+	}
+	return c.Int64.Add(delta)
+}
+
+// link adds future updates of the counters to totals as well. It must be
+// called before the counters are updated.
+func (s *stats) link(totals *stats) {
+	s.packetsSent.total = &totals.packetsSent.Int64
+	s.packetsDropped.total = &totals.packetsDropped.Int64
+	s.packetsDuplicated.total = &totals.packetsDuplicated.Int64
+	s.packetsReordered.total = &totals.packetsReordered.Int64
+	s.packetsOverflowed.total = &totals.packetsOverflowed.Int64
+	s.bytesSent.total = &totals.bytesSent.Int64
+	s.bytesReceived.total = &totals.bytesReceived.Int64
+	s.totalLatency.total = &totals.totalLatency.Int64
+}
+
+// snapshot returns the current value of the counters.
+func (s *stats) snapshot() Stats {
+	return Stats{
+		PacketsSent:       s.packetsSent.Load(),
+		PacketsDropped:    s.packetsDropped.Load(),
+		PacketsDuplicated: s.packetsDuplicated.Load(),
+		PacketsReordered:  s.packetsReordered.Load(),
+		PacketsOverflowed: s.packetsOverflowed.Load(),
+		BytesSent:         s.bytesSent.Load(),
+		BytesReceived:     s.bytesReceived.Load(),
+		TotalLatency:      time.Duration(s.totalLatency.Load()),
+	}
+}
+
+// loss determines if a packet should be dropped, counting the drop.
+func (s *stats) loss(cond DirectionConfig, r *rand.Rand) bool {
+	if cond.loss(r) {
+		s.packetsDropped.Add(1)
+		return true
+	}
+	return false
+}
+
+// duplicates determines how many duplicates of a packet to deliver, counting
+// them.
+func (s *stats) duplicates(cond DirectionConfig, r *rand.Rand) int {
+	n := cond.duplicates(r)
+	s.packetsDuplicated.Add(int64(n))
+	return n
+}
+
+// reorder determines if a packet should be reordered, counting the reorder.
+func (s *stats) reorder(cond DirectionConfig, r *rand.Rand) bool {
+	if cond.reorder(r) {
+		s.packetsReordered.Add(1)
+		return true
+	}
+	return false
+}
+
+// delay records simulated delay applied to a packet.
+func (s *stats) delay(d time.Duration) {
+	s.totalLatency.Add(int64(d))
+}
@@ -0,0 +1,137 @@
+package simnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+)
+
+func TestStats(t *testing.T) {
+	const (
+		writes = 100
+		msg    = "0123456789"
+	)
+
+	// run drives a seeded connection and returns its statistics once every
+	// write has reached the underlying connection.
+	run := func(t *testing.T) simnet.Stats {
+		addr := startEchoServer(t)
+
+		cfg := simnet.NewConfig(
+			simnet.WithOutbound(simnet.DirectionConfig{
+				Latency:       time.Millisecond,
+				LossRate:      0.03,
+				DuplicateRate: 0.2,
+				ReorderRate:   0.2,
+			}),
+			simnet.WithSeed(7),
+		)
+
+		conn, err := simnet.NewDialer(cfg).Dial("tcp", addr)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+
+		provider, ok := conn.(simnet.StatsProvider)
+		must.True(t, ok)
+
+		for range writes {
+			_, err := conn.Write([]byte(msg))
+			must.NoError(t, err)
+		}
+
+		var stats simnet.Stats
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(func() bool {
+				stats = provider.Stats()
+				// Dropped writes are retransmitted, so still reach the
+				// underlying connection.
+				sent := stats.PacketsSent + stats.PacketsDuplicated
+				return stats.BytesSent == sent*int64(len(msg))
+			}),
+			wait.Timeout(5*time.Second),
+			wait.Gap(10*time.Millisecond),
+		))
+		return stats
+	}
+
+	first := run(t)
+	must.Eq(t, writes, first.PacketsSent)
+	must.Positive(t, first.PacketsDropped)
+	must.Positive(t, first.PacketsDuplicated)
+	must.Positive(t, first.PacketsReordered)
+	must.Positive(t, first.TotalLatency)
+
+	// The same seed produces the same decisions.
+	second := run(t)
+	must.Eq(t, first.PacketsSent, second.PacketsSent)
+	must.Eq(t, first.PacketsDropped, second.PacketsDropped)
+	must.Eq(t, first.PacketsDuplicated, second.PacketsDuplicated)
+	must.Eq(t, first.PacketsReordered, second.PacketsReordered)
+	must.Eq(t, first.BytesSent, second.BytesSent)
+}
+
+func TestPacketConnStats(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+	})
+	go echoUDP(peer)
+
+	conn, err := simnet.UDPConn(simnet.NewConfig(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	must.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	_, err = conn.WriteTo([]byte("Hello, simnet!"), peer.LocalAddr())
+	must.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	_, _, err = conn.ReadFrom(buf)
+	must.NoError(t, err)
+
+	stats := conn.(simnet.StatsProvider).Stats()
+	must.Eq(t, 1, stats.PacketsSent)
+	must.Eq(t, 0, stats.PacketsDropped)
+	must.Eq(t, 14, stats.BytesSent)
+	must.Eq(t, 14, stats.BytesReceived)
+}
+
+func TestConfigStats(t *testing.T) {
+	addr := startEchoServer(t)
+
+	cfg := simnet.NewConfig()
+	d := simnet.NewDialer(cfg)
+
+	// write writes a message on a new connection and waits for its echo,
+	// closing the connection afterwards.
+	write := func(t *testing.T, override *simnet.Config) {
+		conn, err := d.DialWithConfig(context.Background(), "tcp", addr, override)
+		must.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		must.NoError(t, err)
+	}
+
+	// Connections are counted after they are closed, including those
+	// dialed with an override.
+	write(t, nil)
+	write(t, simnet.NewConfig(simnet.WithLatency(time.Millisecond)))
+
+	stats := cfg.Stats()
+	must.Eq(t, int64(2), stats.PacketsSent)
+	must.Eq(t, int64(8), stats.BytesSent)
+	must.Eq(t, int64(8), stats.BytesReceived)
+}
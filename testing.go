@@ -0,0 +1,53 @@
+package simnet
+
+import (
+	"net"
+	"testing"
+)
+
+// DialT dials address with a Dialer for cfg, failing the test if the dial
+// fails, as it does for a partitioned address. The connection is closed when
+// the test and its subtests complete.
+func DialT(t testing.TB, cfg *Config, network, address string) net.Conn {
+	t.Helper()
+	conn, err := NewDialer(cfg).Dial(network, address)
+	if err != nil {
+		t.Fatalf("simnet: dialing %s %s: %v", network, address, err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+// ListenT listens on address like net.Listen, returning a Listener applying
+// the conditions of cfg to the connections it accepts, and failing the test if
+// it cannot listen. The listener is closed when the test and its subtests
+// complete.
+func ListenT(t testing.TB, cfg *Config, network, address string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("simnet: listening on %s %s: %v", network, address, err)
+	}
+	sln := NewListener(ln, cfg)
+	t.Cleanup(func() {
+		sln.Close()
+	})
+	return sln
+}
+
+// UDPConnT creates a simulated UDP connection like UDPConn, failing the test
+// if it cannot. The connection is closed when the test and its subtests
+// complete.
+func UDPConnT(t testing.TB, cfg *Config, laddr, raddr *net.UDPAddr) net.PacketConn {
+	t.Helper()
+	conn, err := UDPConn(cfg, laddr, raddr)
+	if err != nil {
+		t.Fatalf("simnet: listening on udp %s: %v", laddr, err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
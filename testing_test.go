@@ -0,0 +1,125 @@
+package simnet_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// fakeT records the failures and cleanups of a test, so that tests can check
+// how helpers use them.
+type fakeT struct {
+	testing.TB // Panics if a method that is not overridden is called
+
+	failure  string
+	cleanups []func()
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Fatalf(format string, args ...any) {
+	t.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func (t *fakeT) Cleanup(fn func()) {
+	t.cleanups = append(t.cleanups, fn)
+}
+
+// run calls fn as a test body would be, returning once it returns or fails.
+func (t *fakeT) run(fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	<-done
+}
+
+// cleanup runs the cleanups registered by the test.
+func (t *fakeT) cleanup() {
+	for _, fn := range t.cleanups {
+		fn()
+	}
+}
+
+func TestDialT(t *testing.T) {
+	ln := simnet.ListenT(t, nil, "tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	t.Run("cleanup closes the connection", func(t *testing.T) {
+		ft := &fakeT{}
+		var conn net.Conn
+		ft.run(func() {
+			conn = simnet.DialT(ft, nil, "tcp", ln.Addr().String())
+		})
+		must.Eq(t, "", ft.failure)
+		_, err := conn.Write([]byte("ping"))
+		must.NoError(t, err)
+
+		ft.cleanup()
+		_, err = conn.Write([]byte("ping"))
+		must.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("partitioned address fails the test", func(t *testing.T) {
+		cfg := simnet.NewConfig()
+		cfg.AddPartition(ln.Addr().String())
+
+		ft := &fakeT{}
+		returned := false
+		ft.run(func() {
+			simnet.DialT(ft, cfg, "tcp", ln.Addr().String())
+			returned = true
+		})
+		must.False(t, returned)
+		must.StrContains(t, ft.failure, "network partitioned")
+		must.SliceEmpty(t, ft.cleanups)
+	})
+}
+
+func TestListenT(t *testing.T) {
+	ft := &fakeT{}
+	var ln net.Listener
+	ft.run(func() {
+		ln = simnet.ListenT(ft, nil, "tcp", "127.0.0.1:0")
+	})
+	must.Eq(t, "", ft.failure)
+
+	ft.cleanup()
+	_, err := ln.Accept()
+	must.ErrorIs(t, err, simnet.ErrFailedToAccept)
+
+	// Listening on an address that is in use fails the test.
+	ft = &fakeT{}
+	ft.run(func() {
+		simnet.ListenT(ft, nil, "tcp", simnet.ListenT(t, nil, "tcp", "127.0.0.1:0").Addr().String())
+	})
+	must.StrContains(t, ft.failure, "listening on tcp")
+}
+
+func TestUDPConnT(t *testing.T) {
+	ft := &fakeT{}
+	var conn net.PacketConn
+	ft.run(func() {
+		conn = simnet.UDPConnT(ft, nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	})
+	must.Eq(t, "", ft.failure)
+
+	ft.cleanup()
+	_, _, err := conn.ReadFrom(make([]byte, 16))
+	must.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,133 @@
+package simnet
+
+import (
+	"net"
+	"sync"
+)
+
+// Topology is a graph of named nodes connected by links, each with its own
+// network conditions, for simulating a cluster. Nodes reach each other only
+// over a direct link; a node without a link to another is partitioned from
+// it. Links can be severed and restored while in use.
+type Topology struct {
+	mu    sync.Mutex
+	nodes map[string]string         // Node names and their addresses
+	links map[linkKey]*topologyLink // Links between nodes, by their names
+}
+
+// linkKey identifies the link between two nodes, in either direction.
+type linkKey struct {
+	a, b string
+}
+
+// newLinkKey returns the key for the link between nodes a and b.
+func newLinkKey(a, b string) linkKey {
+	if b < a {
+		a, b = b, a
+	}
+	return linkKey{a: a, b: b}
+}
+
+// topologyLink is a link between two nodes.
+type topologyLink struct {
+	config  *Config // Network conditions of the link
+	severed bool    // Whether the link is currently down
+}
+
+// NewTopology creates an empty Topology.
+func NewTopology() *Topology {
+	return &Topology{
+		nodes: make(map[string]string),
+		links: make(map[linkKey]*topologyLink),
+	}
+}
+
+// AddNode adds a node with the given name at the given address, replacing
+// any node with the same name. The address may be a host, matching
+// connections to any port, or a host and port.
+func (t *Topology) AddNode(name, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes[name] = addr
+}
+
+// AddLink connects nodes a and b with a link applying the network conditions
+// of cfg in both directions, replacing any link between them. The config may
+// be shared by other links, and changes to it apply to live connections. A
+// nil cfg applies no conditions.
+func (t *Topology) AddLink(a, b string, cfg *Config) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.links[newLinkKey(a, b)] = &topologyLink{config: cfg}
+}
+
+// SeverLink takes down the link between nodes a and b, partitioning them,
+// until it is restored with RestoreLink. Existing connections are not
+// affected.
+func (t *Topology) SeverLink(a, b string) {
+	t.setSevered(a, b, true)
+}
+
+// RestoreLink brings back the link between nodes a and b after SeverLink.
+func (t *Topology) RestoreLink(a, b string) {
+	t.setSevered(a, b, false)
+}
+
+// setSevered sets whether the link between nodes a and b is severed.
+func (t *Topology) setSevered(a, b string, severed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if link, ok := t.links[newLinkKey(a, b)]; ok {
+		link.severed = severed
+	}
+}
+
+// Dialer returns a Dialer for the named node, dialing other nodes with the
+// network conditions of the link to them. Dialing a node without a link, or
+// an address that is not a node, fails with ErrNetworkPartitioned.
+func (t *Topology) Dialer(from string) *Dialer {
+	t.mu.Lock()
+	source := t.nodes[from]
+	t.mu.Unlock()
+
+	return &Dialer{
+		source: source,
+		route: func(address string) (*Config, bool) {
+			return t.route(from, address)
+		},
+	}
+}
+
+// route returns the network conditions of the link from the named node to
+// the node at address, or false if there is no such link.
+func (t *Topology) route(from, address string) (*Config, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, addr := range t.nodes {
+		if !matchNode(addr, address) {
+			continue
+		}
+		if name == from {
+			// A node can always reach itself.
+			return NewConfig(), true
+		}
+		if link, ok := t.links[newLinkKey(from, name)]; ok && !link.severed {
+			return link.config, true
+		}
+	}
+	return nil, false
+}
+
+// matchNode reports whether address belongs to a node at addr, either
+// exactly or by its host.
+func matchNode(addr, address string) bool {
+	if addr == address {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	return err == nil && host == addr
+}
@@ -0,0 +1,97 @@
+package simnet_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+// roundTrip dials addr with d and returns the time taken to echo a message.
+func roundTrip(t *testing.T, d *simnet.Dialer, addr string) (time.Duration, error) {
+	t.Helper()
+
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+func TestTopology(t *testing.T) {
+	const (
+		latencyAB = 20 * time.Millisecond
+		latencyBC = 50 * time.Millisecond
+	)
+
+	// A line of three nodes: a - b - c.
+	addrs := map[string]string{
+		"a": startEchoServer(t),
+		"b": startEchoServer(t),
+		"c": startEchoServer(t),
+	}
+	topo := simnet.NewTopology()
+	for name, addr := range addrs {
+		topo.AddNode(name, addr)
+	}
+	topo.AddLink("a", "b", simnet.NewConfig(simnet.WithLatency(latencyAB)))
+	topo.AddLink("b", "c", simnet.NewConfig(simnet.WithLatency(latencyBC)))
+
+	t.Run("adjacent nodes", func(t *testing.T) {
+		// Latency applies in each direction of the round trip.
+		rtt, err := roundTrip(t, topo.Dialer("a"), addrs["b"])
+		must.NoError(t, err)
+		must.Between(t, 2*latencyAB, rtt, 2*latencyAB+100*time.Millisecond)
+
+		rtt, err = roundTrip(t, topo.Dialer("c"), addrs["b"])
+		must.NoError(t, err)
+		must.Between(t, 2*latencyBC, rtt, 2*latencyBC+100*time.Millisecond)
+	})
+
+	t.Run("non-adjacent nodes are partitioned", func(t *testing.T) {
+		_, err := roundTrip(t, topo.Dialer("a"), addrs["c"])
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+		_, err = roundTrip(t, topo.Dialer("c"), addrs["a"])
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+	})
+
+	t.Run("unknown addresses are partitioned", func(t *testing.T) {
+		_, err := topo.Dialer("a").Dial("tcp", "127.0.0.1:1")
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+	})
+
+	t.Run("severed links", func(t *testing.T) {
+		topo.SeverLink("b", "a")
+		_, err := roundTrip(t, topo.Dialer("a"), addrs["b"])
+		must.ErrorIs(t, err, simnet.ErrNetworkPartitioned)
+
+		topo.RestoreLink("a", "b")
+		_, err = roundTrip(t, topo.Dialer("a"), addrs["b"])
+		must.NoError(t, err)
+	})
+
+	t.Run("host addresses", func(t *testing.T) {
+		host, _, err := net.SplitHostPort(addrs["c"])
+		must.NoError(t, err)
+
+		topo := simnet.NewTopology()
+		topo.AddNode("a", "192.0.2.1")
+		topo.AddNode("c", host)
+		topo.AddLink("a", "c", simnet.NewConfig())
+
+		_, err = roundTrip(t, topo.Dialer("a"), addrs["c"])
+		must.NoError(t, err)
+	})
+}
@@ -0,0 +1,212 @@
+package simnet
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidTrace is returned by NewTraceConfig when a trace cannot be
+// parsed.
+var ErrInvalidTrace = errors.New("simnet: invalid trace")
+
+// traceSample is the link conditions recorded at one point in a trace.
+type traceSample struct {
+	offset    time.Duration // Time since the start of the trace
+	latency   time.Duration // Base latency
+	lossRate  float64       // Packet loss rate (0.0 to 1.0)
+	bandwidth int64         // Bytes per second (0 means unlimited)
+}
+
+// trace replays recorded link conditions, stepping from one sample to the
+// next as time passes.
+type trace struct {
+	start   time.Time     // When the trace started replaying
+	samples []traceSample // Samples in order of offset
+}
+
+// at returns the sample in effect at now, or false before the first sample.
+func (tr *trace) at(now time.Time) (traceSample, bool) {
+	offset := now.Sub(tr.start)
+	i := sort.Search(len(tr.samples), func(i int) bool {
+		return tr.samples[i].offset > offset
+	})
+	if i == 0 {
+		return traceSample{}, false
+	}
+	return tr.samples[i-1], true
+}
+
+// NewTraceConfig returns a Config replaying link conditions recorded in a
+// trace, so that tests can run against the conditions of a real link.
+//
+// The trace is CSV, with one sample per line of the form
+//
+//	offset,latency,loss_rate,bandwidth
+//
+// such as "1.5s,40ms,0.01,125000", where offset is the time since the start
+// of the trace, latency is the base latency, loss_rate is the packet loss rate
+// between 0 and 1, and bandwidth is in bytes per second, or 0 for unlimited.
+// A header line naming the fields and lines starting with "#" are ignored.
+// Alternatively the trace is a JSON array of objects with the same fields,
+// with offset and latency given as duration strings:
+//
+//	[{"offset": "1.5s", "latency": "40ms", "loss_rate": 0.01, "bandwidth": 125000}]
+//
+// Samples must be in order of offset. The trace starts replaying when the
+// Config is returned, by the time of the config's clock, and each sample sets
+// the latency, loss rate, and bandwidth until the next sample, with the last
+// sample holding once the trace ends. Until the first sample, the conditions
+// set by the options apply. Other conditions, such as jitter, and settings
+// such as the seed or clock, may be set with the options. Inbound, Outbound,
+// and AddrConditions override the trace, as they do the top-level fields.
+func NewTraceConfig(r io.Reader, opts ...Option) (*Config, error) {
+	br := bufio.NewReader(r)
+	var (
+		samples []traceSample
+		err     error
+	)
+	if first, _ := peekNonSpace(br); first == '[' {
+		samples, err = parseJSONTrace(br)
+	} else {
+		samples, err = parseCSVTrace(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTrace(samples); err != nil {
+		return nil, err
+	}
+
+	cfg := NewConfig(opts...)
+	cfg.trace = &trace{
+		start:   cfg.clock().Now(),
+		samples: samples,
+	}
+	return cfg, nil
+}
+
+// peekNonSpace returns the first byte of r that is not white space, without
+// consuming it.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			return b[0], nil
+		}
+		r.ReadByte()
+	}
+}
+
+// parseCSVTrace parses a trace of CSV samples.
+func parseCSVTrace(r io.Reader) ([]traceSample, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 4
+	cr.TrimLeadingSpace = true
+
+	var samples []traceSample
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTrace, err)
+		}
+		if len(samples) == 0 && strings.EqualFold(record[0], "offset") {
+			continue // Header
+		}
+
+		line, _ := cr.FieldPos(0)
+		sample, err := parseTraceFields(record[0], record[1], record[2], record[3])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidTrace, line, err)
+		}
+		samples = append(samples, sample)
+	}
+}
+
+// parseTraceFields parses the fields of a CSV sample.
+func parseTraceFields(offset, latency, lossRate, bandwidth string) (traceSample, error) {
+	var (
+		sample traceSample
+		err    error
+	)
+	if sample.offset, err = time.ParseDuration(offset); err != nil {
+		return sample, fmt.Errorf("offset: %w", err)
+	}
+	if sample.latency, err = time.ParseDuration(latency); err != nil {
+		return sample, fmt.Errorf("latency: %w", err)
+	}
+	if sample.lossRate, err = strconv.ParseFloat(lossRate, 64); err != nil {
+		return sample, fmt.Errorf("loss_rate: %w", err)
+	}
+	if sample.bandwidth, err = strconv.ParseInt(bandwidth, 10, 64); err != nil {
+		return sample, fmt.Errorf("bandwidth: %w", err)
+	}
+	return sample, nil
+}
+
+// parseJSONTrace parses a trace of JSON samples.
+func parseJSONTrace(r io.Reader) ([]traceSample, error) {
+	var records []struct {
+		Offset    string  `json:"offset"`
+		Latency   string  `json:"latency"`
+		LossRate  float64 `json:"loss_rate"`
+		Bandwidth int64   `json:"bandwidth"`
+	}
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTrace, err)
+	}
+
+	samples := make([]traceSample, 0, len(records))
+	for i, record := range records {
+		var (
+			sample traceSample
+			err    error
+		)
+		if sample.offset, err = time.ParseDuration(record.Offset); err != nil {
+			return nil, fmt.Errorf("%w: sample %d: offset: %w", ErrInvalidTrace, i, err)
+		}
+		if record.Latency != "" {
+			if sample.latency, err = time.ParseDuration(record.Latency); err != nil {
+				return nil, fmt.Errorf("%w: sample %d: latency: %w", ErrInvalidTrace, i, err)
+			}
+		}
+		sample.lossRate = record.LossRate
+		sample.bandwidth = record.Bandwidth
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// validateTrace checks that samples are in order and within range.
+func validateTrace(samples []traceSample) error {
+	for i, sample := range samples {
+		switch {
+		case sample.offset < 0:
+			return fmt.Errorf("%w: sample %d: offset must not be negative, got %s", ErrInvalidTrace, i, sample.offset)
+		case i > 0 && sample.offset < samples[i-1].offset:
+			return fmt.Errorf("%w: sample %d: offset %s is before the previous sample", ErrInvalidTrace, i, sample.offset)
+		case sample.latency < 0:
+			return fmt.Errorf("%w: sample %d: latency must not be negative, got %s", ErrInvalidTrace, i, sample.latency)
+		case !(sample.lossRate >= 0 && sample.lossRate <= 1):
+			return fmt.Errorf("%w: sample %d: loss_rate must be between 0 and 1, got %v", ErrInvalidTrace, i, sample.lossRate)
+		case sample.bandwidth < 0:
+			return fmt.Errorf("%w: sample %d: bandwidth must not be negative, got %d", ErrInvalidTrace, i, sample.bandwidth)
+		}
+	}
+	return nil
+}
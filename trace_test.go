@@ -0,0 +1,104 @@
+package simnet_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestNewTraceConfig(t *testing.T) {
+	// oneWay returns how long a write on a takes to be read from b.
+	oneWay := func(t *testing.T, a, b io.ReadWriter) time.Duration {
+		t.Helper()
+		start := time.Now()
+		_, err := a.Write([]byte("ping"))
+		must.NoError(t, err)
+		_, err = io.ReadFull(b, make([]byte, 4))
+		must.NoError(t, err)
+		return time.Since(start)
+	}
+
+	traces := map[string]string{
+		"csv": `# A link getting slower.
+offset,latency,loss_rate,bandwidth
+0s,20ms,0,0
+300ms,100ms,0,0
+`,
+		"json": `[
+	{"offset": "0s", "latency": "20ms"},
+	{"offset": "300ms", "latency": "100ms"}
+]`,
+	}
+
+	for name, tr := range traces {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			cfg, err := simnet.NewTraceConfig(strings.NewReader(tr))
+			must.NoError(t, err)
+
+			a, b := simnet.Pipe(cfg)
+			t.Cleanup(func() {
+				a.Close()
+				b.Close()
+			})
+
+			must.Between(t, 20*time.Millisecond, oneWay(t, a, b), 100*time.Millisecond)
+
+			// Once the second sample's offset passes, its latency applies.
+			time.Sleep(time.Until(start.Add(350 * time.Millisecond)))
+			must.Between(t, 100*time.Millisecond, oneWay(t, a, b), 200*time.Millisecond)
+		})
+	}
+
+	t.Run("loss", func(t *testing.T) {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			peer.Close()
+		})
+
+		// Every packet is lost for the first 200ms, and none after.
+		start := time.Now()
+		cfg, err := simnet.NewTraceConfig(strings.NewReader("0s,0s,1,0\n200ms,0s,0,0\n"))
+		must.NoError(t, err)
+		conn, err := simnet.UDPConn(cfg, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+		must.NoError(t, err)
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		stats := conn.(simnet.StatsProvider)
+
+		_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		must.Eq(t, 1, stats.Stats().PacketsDropped)
+
+		time.Sleep(time.Until(start.Add(250 * time.Millisecond)))
+		_, err = conn.WriteTo([]byte("ping"), peer.LocalAddr())
+		must.NoError(t, err)
+		must.Eq(t, 1, stats.Stats().PacketsDropped)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]string{
+			"malformed offset":     "soon,10ms,0,0",
+			"missing field":        "0s,10ms,0",
+			"negative latency":     "0s,-10ms,0,0",
+			"loss rate above one":  "0s,10ms,2,0",
+			"negative bandwidth":   "0s,10ms,0,-1",
+			"out of order":         "1s,10ms,0,0\n0s,10ms,0,0",
+			"malformed json":       `[{"offset": 1}]`,
+			"malformed json delay": `[{"offset": "0s", "latency": "slow"}]`,
+		}
+		for name, tr := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := simnet.NewTraceConfig(strings.NewReader(tr))
+				must.ErrorIs(t, err, simnet.ErrInvalidTrace)
+			})
+		}
+	})
+}
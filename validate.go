@@ -0,0 +1,154 @@
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ErrInvalidConfig is returned by Config.Validate for each invalid field.
+var ErrInvalidConfig = errors.New("simnet: invalid config")
+
+// Validate checks that the configured conditions are within range, returning
+// an error joining every problem found, or nil if the config is valid. Each
+// problem wraps ErrInvalidConfig.
+func (cfg *Config) Validate() error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	errs := validateDirection("", DirectionConfig{
+		Latency:        cfg.Latency,
+		Jitter:         cfg.Jitter,
+		SpikeRate:      cfg.SpikeRate,
+		SpikeLatency:   cfg.SpikeLatency,
+		Bandwidth:      cfg.Bandwidth,
+		Burst:          cfg.Burst,
+		LossRate:       cfg.LossRate,
+		BitErrorRate:   cfg.BitErrorRate,
+		ReorderRate:    cfg.ReorderRate,
+		Reorder:        cfg.Reorder,
+		DuplicateRate:  cfg.DuplicateRate,
+		MaxDuplicates:  cfg.MaxDuplicates,
+		DuplicateDelay: cfg.DuplicateDelay,
+	})
+	if cfg.Inbound != nil {
+		errs = append(errs, validateDirection("Inbound.", *cfg.Inbound)...)
+	}
+	if cfg.Outbound != nil {
+		errs = append(errs, validateDirection("Outbound.", *cfg.Outbound)...)
+	}
+	for _, addr := range slices.Sorted(maps.Keys(cfg.AddrConditions)) {
+		errs = append(errs, validateDirection(fmt.Sprintf("AddrConditions[%q].", addr), cfg.AddrConditions[addr])...)
+	}
+	if cfg.DownloadBandwidth < 0 {
+		errs = append(errs, fmt.Errorf("%w: DownloadBandwidth must not be negative, got %d", ErrInvalidConfig, cfg.DownloadBandwidth))
+	}
+	if cfg.UploadBandwidth < 0 {
+		errs = append(errs, fmt.Errorf("%w: UploadBandwidth must not be negative, got %d", ErrInvalidConfig, cfg.UploadBandwidth))
+	}
+	if cfg.NATRebind < 0 {
+		errs = append(errs, fmt.Errorf("%w: NATRebind must not be negative, got %s", ErrInvalidConfig, cfg.NATRebind))
+	}
+	if cfg.PartitionTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%w: PartitionTimeout must not be negative, got %s", ErrInvalidConfig, cfg.PartitionTimeout))
+	}
+	if cfg.MaxConns < 0 {
+		errs = append(errs, fmt.Errorf("%w: MaxConns must not be negative, got %d", ErrInvalidConfig, cfg.MaxConns))
+	}
+	if cfg.MTU < 0 {
+		errs = append(errs, fmt.Errorf("%w: MTU must not be negative, got %d", ErrInvalidConfig, cfg.MTU))
+	}
+	if cfg.MaxRetransmits < 0 {
+		errs = append(errs, fmt.Errorf("%w: MaxRetransmits must not be negative, got %d", ErrInvalidConfig, cfg.MaxRetransmits))
+	}
+	if cfg.RetransmitTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%w: RetransmitTimeout must not be negative, got %s", ErrInvalidConfig, cfg.RetransmitTimeout))
+	}
+	if cfg.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("%w: QueueSize must not be negative, got %d", ErrInvalidConfig, cfg.QueueSize))
+	}
+	if cfg.LinkBuffer < 0 {
+		errs = append(errs, fmt.Errorf("%w: LinkBuffer must not be negative, got %d", ErrInvalidConfig, cfg.LinkBuffer))
+	}
+	if cfg.ReadBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("%w: ReadBufferSize must not be negative, got %d", ErrInvalidConfig, cfg.ReadBufferSize))
+	}
+	if cfg.ConnectLatency < 0 {
+		errs = append(errs, fmt.Errorf("%w: ConnectLatency must not be negative, got %s", ErrInvalidConfig, cfg.ConnectLatency))
+	}
+	if !(cfg.ConnectFailureRate >= 0 && cfg.ConnectFailureRate <= 1) {
+		errs = append(errs, fmt.Errorf("%w: ConnectFailureRate must be between 0 and 1, got %v", ErrInvalidConfig, cfg.ConnectFailureRate))
+	}
+	if !(cfg.ResetRate >= 0 && cfg.ResetRate <= 1) {
+		errs = append(errs, fmt.Errorf("%w: ResetRate must be between 0 and 1, got %v", ErrInvalidConfig, cfg.ResetRate))
+	}
+	if cfg.ResetAfter < 0 {
+		errs = append(errs, fmt.Errorf("%w: ResetAfter must not be negative, got %s", ErrInvalidConfig, cfg.ResetAfter))
+	}
+	for i, event := range cfg.ScriptedEvents {
+		if event.PacketIndex < 0 {
+			errs = append(errs, fmt.Errorf("%w: ScriptedEvents[%d].PacketIndex must not be negative, got %d", ErrInvalidConfig, i, event.PacketIndex))
+		}
+		if event.Action < ScriptDrop || event.Action > ScriptReorder {
+			errs = append(errs, fmt.Errorf("%w: ScriptedEvents[%d].Action must be a ScriptAction, got %d", ErrInvalidConfig, i, event.Action))
+		}
+		if event.Delay < 0 {
+			errs = append(errs, fmt.Errorf("%w: ScriptedEvents[%d].Delay must not be negative, got %s", ErrInvalidConfig, i, event.Delay))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateDirection returns an error for each invalid field of dc, naming
+// fields with the given prefix.
+func validateDirection(prefix string, dc DirectionConfig) []error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s%s", ErrInvalidConfig, prefix, fmt.Sprintf(format, args...)))
+	}
+	rate := func(name string, value float64) {
+		// Written to also reject NaN.
+		if !(value >= 0 && value <= 1) {
+			invalid("%s must be between 0 and 1, got %v", name, value)
+		}
+	}
+
+	if dc.Latency < 0 {
+		invalid("Latency must not be negative, got %s", dc.Latency)
+	}
+	if dc.Jitter < 0 {
+		invalid("Jitter must not be negative, got %s", dc.Jitter)
+	}
+	rate("SpikeRate", dc.SpikeRate)
+	if dc.SpikeLatency < 0 {
+		invalid("SpikeLatency must not be negative, got %s", dc.SpikeLatency)
+	}
+	if dc.Bandwidth < 0 {
+		invalid("Bandwidth must not be negative, got %d", dc.Bandwidth)
+	}
+	if dc.Burst < 0 {
+		invalid("Burst must not be negative, got %d", dc.Burst)
+	}
+	rate("LossRate", dc.LossRate)
+	rate("BitErrorRate", dc.BitErrorRate)
+	rate("ReorderRate", dc.ReorderRate)
+	rate("DuplicateRate", dc.DuplicateRate)
+	if dc.MaxDuplicates < 0 {
+		invalid("MaxDuplicates must not be negative, got %d", dc.MaxDuplicates)
+	}
+	if dc.DuplicateDelay < 0 {
+		invalid("DuplicateDelay must not be negative, got %s", dc.DuplicateDelay)
+	}
+
+	if dc.Reorder != nil {
+		rate("Reorder.Probability", dc.Reorder.Probability)
+		if dc.Reorder.Gap < 0 {
+			invalid("Reorder.Gap must not be negative, got %d", dc.Reorder.Gap)
+		}
+		if dc.Reorder.Timeout < 0 {
+			invalid("Reorder.Timeout must not be negative, got %s", dc.Reorder.Timeout)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,95 @@
+package simnet_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/simnet"
+	"github.com/shoenig/test/must"
+)
+
+func TestConfigValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		cfg := simnet.NewConfig(
+			simnet.WithLatency(50*time.Millisecond),
+			simnet.WithJitter(10*time.Millisecond),
+			simnet.WithBandwidth(1024),
+			simnet.WithBurst(512),
+			simnet.WithLossRate(1),
+			simnet.WithReorderRate(0.5),
+			simnet.WithDuplicateRate(0),
+			simnet.WithMTU(1500),
+			simnet.WithQueueSize(10),
+			simnet.WithReorder(simnet.ReorderConfig{Gap: 2, Probability: 0.1}),
+			simnet.WithInbound(simnet.DirectionConfig{LossRate: 0.1}),
+			simnet.WithSeed(-1),
+		)
+		must.NoError(t, cfg.Validate())
+		must.NoError(t, simnet.NewConfig().Validate())
+	})
+
+	tests := []struct {
+		name   string
+		option simnet.Option
+		field  string
+	}{
+		{"negative latency", simnet.WithLatency(-time.Second), "Latency"},
+		{"negative jitter", simnet.WithJitter(-time.Second), "Jitter"},
+		{"spike rate above one", simnet.WithLatencySpikes(2, time.Second), "SpikeRate"},
+		{"negative spike latency", simnet.WithLatencySpikes(0.1, -time.Second), "SpikeLatency"},
+		{"negative bandwidth", simnet.WithBandwidth(-1), "Bandwidth"},
+		{"negative download bandwidth", simnet.WithAsymmetricBandwidth(-1, 0), "DownloadBandwidth"},
+		{"negative upload bandwidth", simnet.WithAsymmetricBandwidth(0, -1), "UploadBandwidth"},
+		{"negative burst", simnet.WithBurst(-1), "Burst"},
+		{"loss rate above one", simnet.WithLossRate(1.5), "LossRate"},
+		{"negative loss rate", simnet.WithLossRate(-0.1), "LossRate"},
+		{"bit error rate above one", simnet.WithBitErrorRate(2), "BitErrorRate"},
+		{"NaN loss rate", simnet.WithLossRate(math.NaN()), "LossRate"},
+		{"reorder rate above one", simnet.WithReorderRate(2), "ReorderRate"},
+		{"duplicate rate above one", simnet.WithDuplicateRate(2), "DuplicateRate"},
+		{"negative max duplicates", simnet.WithMaxDuplicates(-1), "MaxDuplicates"},
+		{"negative duplicate delay", simnet.WithDuplicateDelay(-1), "DuplicateDelay"},
+		{"negative partition timeout", simnet.WithPartitionTimeout(-time.Second), "PartitionTimeout"},
+		{"negative NAT rebind", simnet.WithNATRebind(-time.Second), "NATRebind"},
+		{"negative max conns", simnet.WithMaxConns(-1), "MaxConns"},
+		{"negative MTU", simnet.WithMTU(-1), "MTU"},
+		{"negative max retransmits", simnet.WithAutoRetransmit(-1, time.Second), "MaxRetransmits"},
+		{"negative retransmit timeout", simnet.WithAutoRetransmit(1, -time.Second), "RetransmitTimeout"},
+		{"negative queue size", simnet.WithQueueSize(-1), "QueueSize"},
+		{"negative link buffer", simnet.WithLinkBuffer(-1), "LinkBuffer"},
+		{"negative read buffer size", simnet.WithReadBufferSize(-1), "ReadBufferSize"},
+		{"negative connect latency", simnet.WithConnectLatency(-time.Second), "ConnectLatency"},
+		{"connect failure rate above one", simnet.WithConnectFailureRate(2), "ConnectFailureRate"},
+		{"reset rate above one", simnet.WithResetRate(2), "ResetRate"},
+		{"negative reset after", simnet.WithResetAfter(-time.Second), "ResetAfter"},
+		{"reorder probability above one", simnet.WithReorder(simnet.ReorderConfig{Probability: 2}), "Reorder.Probability"},
+		{"negative reorder gap", simnet.WithReorder(simnet.ReorderConfig{Gap: -1}), "Reorder.Gap"},
+		{"negative reorder timeout", simnet.WithReorder(simnet.ReorderConfig{Timeout: -1}), "Reorder.Timeout"},
+		{"invalid inbound", simnet.WithInbound(simnet.DirectionConfig{LossRate: 2}), "Inbound.LossRate"},
+		{"invalid outbound", simnet.WithOutbound(simnet.DirectionConfig{Latency: -1}), "Outbound.Latency"},
+		{"negative scripted packet index", simnet.WithScriptedEvents(simnet.ScriptedEvent{PacketIndex: -1, Action: simnet.ScriptDrop}), "ScriptedEvents[0].PacketIndex"},
+		{"unknown scripted action", simnet.WithScriptedEvents(simnet.ScriptedEvent{}), "ScriptedEvents[0].Action"},
+		{"negative scripted delay", simnet.WithScriptedEvents(simnet.ScriptedEvent{Action: simnet.ScriptDelay, Delay: -1}), "ScriptedEvents[0].Delay"},
+		{"invalid addr conditions", simnet.WithAddrConditions(map[string]simnet.DirectionConfig{"10.0.0.2": {Jitter: -1}}), `AddrConditions["10.0.0.2"].Jitter`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := simnet.NewConfig(test.option).Validate()
+			must.ErrorIs(t, err, simnet.ErrInvalidConfig)
+			must.StrContains(t, err.Error(), test.field+" must")
+		})
+	}
+
+	t.Run("every problem is reported", func(t *testing.T) {
+		err := simnet.NewConfig(
+			simnet.WithLossRate(1.5),
+			simnet.WithBandwidth(-1),
+			simnet.WithMTU(-1),
+		).Validate()
+		must.ErrorIs(t, err, simnet.ErrInvalidConfig)
+		must.Eq(t, 3, len(strings.Split(err.Error(), "\n")))
+	})
+}